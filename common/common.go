@@ -1 +1,47 @@
 package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseMapsFileMemoryLimits parses the memory limits of a mapping as found in /proc/PID/maps. It's available in every OS,
+// as dumps of the memory of Linux processes can be analyzed anywhere.
+func ParseMapsFileMemoryLimits(limits string) (start uintptr, end uintptr, err error) {
+	fields := strings.Split(limits, "-")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("Invalid memory limits, it must have two hexa numbers separeted by a single -")
+	}
+
+	start64, err := strconv.ParseUint(fields[0], 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	start = uintptr(start64)
+
+	end64, err := strconv.ParseUint(fields[1], 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	end = uintptr(end64)
+
+	return
+}
+
+// SplitMapsFileEntry method splits a line of the maps files returning a slice with an element for each of its parts.
+// Like ParseMapsFileMemoryLimits, it's used in every OS to read the mappings saved with the dumps.
+func SplitMapsFileEntry(entry string) []string {
+	res := make([]string, 0, 6)
+	for i := 0; i < 5; i++ {
+		if strings.Index(entry, " ") != -1 {
+			res = append(res, entry[0:strings.Index(entry, " ")])
+			entry = entry[strings.Index(entry, " ")+1:]
+		} else {
+			res = append(res, entry, "")
+			return res
+		}
+	}
+	res = append(res, strings.TrimLeft(entry, " "))
+	return res
+}
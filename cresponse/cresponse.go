@@ -12,12 +12,21 @@ import (
 
 // CError is the Go represnentation of response.h's error_t.
 type CError struct {
-	number      int
-	description string
+	// Number is the error number as returned by the OS, or -1 for errors that don't come from it.
+	Number int
+
+	Description string
 }
 
 func (err CError) Error() string {
-	return fmt.Sprintf("System error number %d: %s", err.number, err.description)
+	return fmt.Sprintf("System error number %d: %s", err.Number, err.Description)
+}
+
+// Unwrap returns the syscall.Errno that corresponds to the error number, so it can be checked with errors.Is (e.g.
+// against os.ErrPermission). It returns nil if the error doesn't come from the OS or it has no equivalent Errno.
+func (err CError) Unwrap() error {
+	// This function is implemented by the OS-specific osError function.
+	return osError(err.Number)
 }
 
 // GetResponsesErrors returns the Go representation of the errors present in a C.response_t.
@@ -51,7 +60,7 @@ func GetResponsesErrors(responsePointer unsafe.Pointer) (softerrors []error, har
 
 func cErrorFromErrorT(err C.error_t) CError {
 	return CError{
-		number:      int(err.error_number),
-		description: C.GoString(err.description),
+		Number:      int(err.error_number),
+		Description: C.GoString(err.description),
 	}
 }
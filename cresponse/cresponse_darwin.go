@@ -0,0 +1,21 @@
+package cresponse
+
+import "syscall"
+
+// These are the kern_return_t values that have an equivalent errno, from mach/kern_return.h.
+const (
+	kernInvalidAddress    = 1
+	kernProtectionFailure = 2
+	kernNoAccess          = 8
+)
+
+// osError maps a kern_return_t, as Mac OS errors are reported by the Mach API, to its equivalent syscall.Errno.
+func osError(number int) error {
+	switch number {
+	case kernInvalidAddress:
+		return syscall.EFAULT
+	case kernProtectionFailure, kernNoAccess:
+		return syscall.EACCES
+	}
+	return nil
+}
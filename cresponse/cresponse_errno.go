@@ -0,0 +1,15 @@
+//go:build !darwin
+// +build !darwin
+
+package cresponse
+
+import "syscall"
+
+// osError returns the error number as a syscall.Errno. In Windows it's a system error code as returned by
+// GetLastError, which is what syscall.Errno holds there.
+func osError(number int) error {
+	if number <= 0 {
+		return nil
+	}
+	return syscall.Errno(number)
+}
@@ -0,0 +1,543 @@
+// Package elfinfo reads the metadata that identifies the ELF objects loaded by a process: their SONAME, GNU build-id,
+// the libraries they need and the symbol versions they define.
+//
+// The metadata is read from the files of the objects when possible, and from the memory of the process otherwise, as
+// the ELF header, the program headers and the dynamic section of every object are loaded into memory.
+//
+// A Symbolizer uses the symbols of the objects to translate addresses of a process into objects and symbols, and the
+// other way around.
+package elfinfo
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mozilla/masche/listlibs"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"io"
+	"os"
+)
+
+// ErrNotELF is returned when the file or memory read isn't an ELF object.
+var ErrNotELF = errors.New("Not an ELF object")
+
+// ErrTruncated is returned when the program headers of an object are truncated, so none of its metadata can be found.
+var ErrTruncated = errors.New("The program headers of the object are truncated")
+
+// ErrNoBuildID is returned as a soft error when an object has no GNU build-id note, as when it was stripped of it.
+var ErrNoBuildID = errors.New("The object has no GNU build-id")
+
+// Info is the metadata of an ELF object.
+type Info struct {
+	// SONAME is the name the object is loaded by, if it's a shared library that has one.
+	SONAME string
+	// BuildID is the GNU build-id of the object, hex encoded.
+	BuildID string
+	// Needed are the libraries the object needs (its DT_NEEDED entries).
+	Needed []string
+	// VersionDefinitions are the names of the symbol versions defined by the object, like GLIBC_2.2.5, excluding the
+	// base version, which is its SONAME.
+	VersionDefinitions []string
+}
+
+// Object is an ELF object loaded by a process.
+type Object struct {
+	listlibs.Library
+	Info
+	// FromMemory is true if the metadata was read from the memory of the process, because the file of the object was
+	// deleted, replaced or couldn't be read.
+	FromMemory bool
+}
+
+// These are the types of the dynamic entries used, besides the ones defined in debug/elf.
+const (
+	dtVerdef    elf.DynTag = 0x6ffffffc
+	dtVerdefNum elf.DynTag = 0x6ffffffd
+)
+
+// ntGNUBuildID is the type of the GNU note that holds the build-id.
+const ntGNUBuildID = 3
+
+// verFlgBase is the flag of the version definition of the object itself.
+const verFlgBase = 1
+
+// maxTableSize bounds the size of the tables read, so corrupt images don't make us allocate huge buffers.
+const maxTableSize = 16 << 20
+
+// ListObjects returns the metadata of the executable and the libraries loaded by a process. Mapped files that aren't
+// ELF objects are ignored, and the objects whose metadata can't be read are returned as soft errors.
+func ListObjects(p process.Process) (objects []Object, softerrors []error, harderror error) {
+	files, softerrors, harderror := listlibs.ListMappedFiles(p)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	for _, f := range files {
+		o := Object{Library: f}
+		var serrs []error
+		var err error
+		// The soft errors of the files that couldn't be read are kept, they tell why the memory was read instead.
+		o.FromMemory = readObject(p, f, func(path string) error {
+			var fileSerrs []error
+			o.Info, fileSerrs, err = ReadFile(path)
+			serrs = append(serrs, fileSerrs...)
+			return err
+		}, func() {
+			var memorySerrs []error
+			o.Info, memorySerrs, err = ReadMemory(p, f.Base)
+			serrs = append(serrs, memorySerrs...)
+		})
+
+		if errors.Is(err, ErrNotELF) {
+			continue
+		}
+		for _, e := range serrs {
+			softerrors = append(softerrors, fmt.Errorf("%s: %w", f.Path, e))
+		}
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Could not read the ELF metadata of %s: %w", f.Path, err))
+			continue
+		}
+		objects = append(objects, o)
+	}
+	return objects, softerrors, nil
+}
+
+// readObject reads an object calling fromFile with each of its files until one of them can be read, or is found not to
+// be an ELF object. If none can, fromMemory is called to read it from the memory of the process, and true is returned.
+func readObject(p process.Process, f listlibs.Library, fromFile func(path string) error, fromMemory func()) bool {
+	for _, path := range objectFiles(p, f) {
+		if err := fromFile(path); err == nil || errors.Is(err, ErrNotELF) {
+			return false
+		}
+	}
+	fromMemory()
+	return true
+}
+
+// ReadFile reads the metadata of the ELF object in path. If the file isn't an ELF object ErrNotELF is returned, and if
+// its program headers are truncated ErrTruncated is, with the details as soft errors. The metadata missing from a
+// truncated or stripped object is returned as soft errors.
+func ReadFile(path string) (info Info, softerrors []error, harderror error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, nil, err
+	}
+	defer f.Close()
+
+	img, softerrors, err := openImage(f, 0, false)
+	if err != nil {
+		return Info{}, softerrors, err
+	}
+	if len(softerrors) > 0 {
+		return Info{}, softerrors, ErrTruncated
+	}
+	info, softerrors = img.info()
+	return info, softerrors, nil
+}
+
+// ReadMemory reads the metadata of the ELF object whose first byte is loaded at base in the memory of a process. If
+// there isn't an ELF object there ErrNotELF is returned, and if its program headers can't be read ErrTruncated is, as
+// ReadFile does. The metadata that isn't in memory is returned as soft errors.
+func ReadMemory(p process.Process, base uintptr) (info Info, softerrors []error, harderror error) {
+	img, softerrors, err := openImage(memoryReader{p}, uint64(base), true)
+	if err != nil {
+		return Info{}, softerrors, err
+	}
+	if len(softerrors) > 0 {
+		return Info{}, softerrors, ErrTruncated
+	}
+	info, softerrors = img.info()
+	return info, softerrors, nil
+}
+
+// memoryReader reads the memory of a process, using addresses as offsets.
+type memoryReader struct {
+	p process.Process
+}
+
+func (m memoryReader) ReadAt(b []byte, off int64) (n int, err error) {
+	if _, err = memaccess.CopyMemory(m.p, uintptr(off), b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// image is an ELF object being read from a file or from memory.
+type image struct {
+	r        io.ReaderAt
+	inMemory bool
+	// base is the offset of r where the object starts.
+	base  uint64
+	class elf.Class
+	order binary.ByteOrder
+	progs []elf.ProgHeader
+	// bias is the difference between the addresses of the object in memory and its virtual addresses, and span is the
+	// end of its highest segment.
+	bias uint64
+	span uint64
+}
+
+// openImage reads the ELF and program headers of the object that starts at base in r. inMemory tells if r reads the
+// memory of a process, or a file. If the program headers are truncated a soft error is returned, and the image has no
+// segments.
+func openImage(r io.ReaderAt, base uint64, inMemory bool) (img *image, softerrors []error, harderror error) {
+	ident := make([]byte, elf.EI_NIDENT)
+	if _, err := r.ReadAt(ident, int64(base)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, ErrNotELF
+		}
+		return nil, nil, err
+	}
+	if !bytes.Equal(ident[:4], []byte(elf.ELFMAG)) {
+		return nil, nil, ErrNotELF
+	}
+
+	img = &image{r: r, inMemory: inMemory, base: base, class: elf.Class(ident[elf.EI_CLASS])}
+	switch elf.Data(ident[elf.EI_DATA]) {
+	case elf.ELFDATA2LSB:
+		img.order = binary.LittleEndian
+	case elf.ELFDATA2MSB:
+		img.order = binary.BigEndian
+	default:
+		return nil, nil, fmt.Errorf("Unknown ELF data encoding %v", elf.Data(ident[elf.EI_DATA]))
+	}
+
+	var phoff uint64
+	var phentsize, phnum int
+	var progSize int
+	switch img.class {
+	case elf.ELFCLASS64:
+		var hdr elf.Header64
+		if err := img.readStruct(base, &hdr); err != nil {
+			return nil, nil, fmt.Errorf("Truncated ELF header: %w", err)
+		}
+		phoff, phentsize, phnum = hdr.Phoff, int(hdr.Phentsize), int(hdr.Phnum)
+		progSize = binary.Size(elf.Prog64{})
+	case elf.ELFCLASS32:
+		var hdr elf.Header32
+		if err := img.readStruct(base, &hdr); err != nil {
+			return nil, nil, fmt.Errorf("Truncated ELF header: %w", err)
+		}
+		phoff, phentsize, phnum = uint64(hdr.Phoff), int(hdr.Phentsize), int(hdr.Phnum)
+		progSize = binary.Size(elf.Prog32{})
+	default:
+		return nil, nil, fmt.Errorf("Unknown ELF class %v", img.class)
+	}
+	if phnum > 0 && phentsize < progSize {
+		return nil, nil, fmt.Errorf("Invalid size of the program headers %d", phentsize)
+	}
+
+	var progs []elf.ProgHeader
+	for i := 0; i < phnum; i++ {
+		offset := base + phoff + uint64(i*phentsize)
+		var prog elf.ProgHeader
+		if img.class == elf.ELFCLASS64 {
+			var p elf.Prog64
+			if err := img.readStruct(offset, &p); err != nil {
+				return img, []error{fmt.Errorf("Truncated program headers: %w", err)}, nil
+			}
+			prog = elf.ProgHeader{Type: elf.ProgType(p.Type), Flags: elf.ProgFlag(p.Flags), Off: p.Off, Vaddr: p.Vaddr,
+				Filesz: p.Filesz, Memsz: p.Memsz, Align: p.Align}
+		} else {
+			var p elf.Prog32
+			if err := img.readStruct(offset, &p); err != nil {
+				return img, []error{fmt.Errorf("Truncated program headers: %w", err)}, nil
+			}
+			prog = elf.ProgHeader{Type: elf.ProgType(p.Type), Flags: elf.ProgFlag(p.Flags), Off: uint64(p.Off),
+				Vaddr: uint64(p.Vaddr), Filesz: uint64(p.Filesz), Memsz: uint64(p.Memsz), Align: uint64(p.Align)}
+		}
+		progs = append(progs, prog)
+	}
+	img.progs = progs
+
+	// The first loaded segment is mapped where its offset in the file says, which gives the bias of the whole object.
+	first := true
+	for _, prog := range img.progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if first {
+			img.bias = base + prog.Off - prog.Vaddr
+			first = false
+		}
+		if end := prog.Vaddr + prog.Memsz; end > img.span {
+			img.span = end
+		}
+	}
+	return img, nil, nil
+}
+
+func (img *image) readStruct(offset uint64, data interface{}) error {
+	return binary.Read(io.NewSectionReader(img.r, int64(offset), int64(binary.Size(data))), img.order, data)
+}
+
+// readSegment reads the contents of a segment.
+func (img *image) readSegment(prog elf.ProgHeader) ([]byte, error) {
+	if img.inMemory {
+		return img.read(prog.Vaddr, prog.Filesz)
+	}
+	if prog.Filesz > maxTableSize {
+		return nil, fmt.Errorf("The segment at %x is too big", prog.Vaddr)
+	}
+	data := make([]byte, prog.Filesz)
+	if _, err := img.r.ReadAt(data, int64(prog.Off)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// read reads size bytes from a virtual address of the object.
+func (img *image) read(vaddr uint64, size uint64) ([]byte, error) {
+	if size > maxTableSize {
+		return nil, fmt.Errorf("The table at %x is too big", vaddr)
+	}
+	data := make([]byte, size)
+
+	offset := img.bias + vaddr
+	if !img.inMemory {
+		found := false
+		for _, prog := range img.progs {
+			if prog.Type == elf.PT_LOAD && vaddr >= prog.Vaddr && vaddr+size <= prog.Vaddr+prog.Filesz {
+				offset, found = vaddr-prog.Vaddr+prog.Off, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("The address %x isn't in the file", vaddr)
+		}
+	}
+
+	if _, err := img.r.ReadAt(data, int64(offset)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// pointer returns the virtual address a pointer of the dynamic section points to. The dynamic linker relocates some of
+// them in memory, depending on the architecture.
+func (img *image) pointer(ptr uint64) uint64 {
+	if img.inMemory && img.bias != 0 && ptr >= img.bias && ptr-img.bias < img.span {
+		return ptr - img.bias
+	}
+	return ptr
+}
+
+func (img *image) wordSize() int {
+	if img.class == elf.ELFCLASS64 {
+		return 8
+	}
+	return 4
+}
+
+func (img *image) word(b []byte) uint64 {
+	if img.class == elf.ELFCLASS64 {
+		return img.order.Uint64(b)
+	}
+	return uint64(img.order.Uint32(b))
+}
+
+// dynamic holds the entries of the dynamic section of an object, with its pointers converted to virtual addresses.
+type dynamic struct {
+	strtab, strsz     uint64
+	soname            uint64
+	hasSoname         bool
+	needed            []uint64
+	verdef, verdefNum uint64
+	symtab            uint64
+	hash, gnuHash     uint64
+}
+
+// dynamic reads the dynamic section of the object, returning nil if it has none, as static executables.
+func (img *image) dynamic() (*dynamic, error) {
+	var prog *elf.ProgHeader
+	for i := range img.progs {
+		if img.progs[i].Type == elf.PT_DYNAMIC {
+			prog = &img.progs[i]
+			break
+		}
+	}
+	if prog == nil {
+		return nil, nil
+	}
+
+	data, err := img.readSegment(*prog)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the dynamic section: %w", err)
+	}
+
+	d := &dynamic{}
+	size := img.wordSize()
+	for ; len(data) >= 2*size; data = data[2*size:] {
+		tag, val := elf.DynTag(img.word(data)), img.word(data[size:])
+		if tag == elf.DT_NULL {
+			break
+		}
+		switch tag {
+		case elf.DT_STRTAB:
+			d.strtab = img.pointer(val)
+		case elf.DT_STRSZ:
+			d.strsz = val
+		case elf.DT_SONAME:
+			d.soname, d.hasSoname = val, true
+		case elf.DT_NEEDED:
+			d.needed = append(d.needed, val)
+		case dtVerdef:
+			d.verdef = img.pointer(val)
+		case dtVerdefNum:
+			d.verdefNum = val
+		case elf.DT_SYMTAB:
+			d.symtab = img.pointer(val)
+		case elf.DT_HASH:
+			d.hash = img.pointer(val)
+		case elf.DT_GNU_HASH:
+			d.gnuHash = img.pointer(val)
+		}
+	}
+	return d, nil
+}
+
+// stringTable reads the string table of the dynamic section, returning a function that gets the strings by their
+// offset.
+func (img *image) stringTable(d *dynamic) (func(uint64) (string, error), error) {
+	if d.strtab == 0 {
+		return nil, errors.New("The dynamic section has no string table")
+	}
+	data, err := img.read(d.strtab, d.strsz)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the string table: %w", err)
+	}
+
+	return func(offset uint64) (string, error) {
+		if offset >= uint64(len(data)) {
+			return "", fmt.Errorf("The string at %d is out of the string table", offset)
+		}
+		s := data[offset:]
+		if end := bytes.IndexByte(s, 0); end >= 0 {
+			s = s[:end]
+		}
+		return string(s), nil
+	}, nil
+}
+
+// info reads the metadata of the object from its notes and dynamic section.
+func (img *image) info() (info Info, softerrors []error) {
+	buildID, err := img.buildID()
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	info.BuildID = buildID
+
+	d, err := img.dynamic()
+	if err != nil {
+		return info, append(softerrors, err)
+	}
+	if d == nil || (!d.hasSoname && len(d.needed) == 0 && d.verdefNum == 0) {
+		return info, softerrors
+	}
+
+	str, err := img.stringTable(d)
+	if err != nil {
+		return info, append(softerrors, err)
+	}
+
+	if d.hasSoname {
+		if info.SONAME, err = str(d.soname); err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Could not read the SONAME: %w", err))
+		}
+	}
+	for _, offset := range d.needed {
+		name, err := str(offset)
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Could not read a needed library: %w", err))
+			continue
+		}
+		info.Needed = append(info.Needed, name)
+	}
+
+	info.VersionDefinitions, err = img.versionDefinitions(d.verdef, d.verdefNum, str)
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	return info, softerrors
+}
+
+// buildID returns the GNU build-id of the object, read from its PT_NOTE segments.
+func (img *image) buildID() (string, error) {
+	for _, prog := range img.progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := img.readSegment(prog)
+		if err != nil {
+			return "", fmt.Errorf("Could not read the notes: %w", err)
+		}
+
+		align := uint64(4)
+		if prog.Align == 8 {
+			align = 8
+		}
+		alignNote := func(n uint64) uint64 {
+			return (n + align - 1) &^ (align - 1)
+		}
+		for uint64(len(data)) >= 12 {
+			namesz := uint64(img.order.Uint32(data[0:]))
+			descsz := uint64(img.order.Uint32(data[4:]))
+			typ := img.order.Uint32(data[8:])
+
+			descStart := alignNote(12 + namesz)
+			if descStart+descsz > uint64(len(data)) {
+				return "", fmt.Errorf("Truncated note of type %d", typ)
+			}
+			if typ == ntGNUBuildID && string(data[12:12+namesz]) == "GNU\x00" {
+				return hex.EncodeToString(data[descStart : descStart+descsz]), nil
+			}
+
+			next := alignNote(descStart + descsz)
+			if next > uint64(len(data)) {
+				break
+			}
+			data = data[next:]
+		}
+	}
+	return "", ErrNoBuildID
+}
+
+// versionDefinitions returns the names of the count version definitions at vaddr, except the base one.
+func (img *image) versionDefinitions(vaddr uint64, count uint64, str func(uint64) (string, error)) ([]string, error) {
+	// These are the sizes of Elfxx_Verdef and Elfxx_Verdaux, which are the same for both classes.
+	const verdefSize, verdauxSize = 20, 8
+
+	var names []string
+	for i := uint64(0); i < count; i++ {
+		verdef, err := img.read(vaddr, verdefSize)
+		if err != nil {
+			return names, fmt.Errorf("Could not read the version definitions: %w", err)
+		}
+		flags := img.order.Uint16(verdef[2:])
+		aux := uint64(img.order.Uint32(verdef[12:]))
+		next := uint64(img.order.Uint32(verdef[16:]))
+
+		if flags&verFlgBase == 0 {
+			verdaux, err := img.read(vaddr+aux, verdauxSize)
+			if err != nil {
+				return names, fmt.Errorf("Could not read the version definitions: %w", err)
+			}
+			name, err := str(uint64(img.order.Uint32(verdaux)))
+			if err != nil {
+				return names, fmt.Errorf("Could not read a version definition: %w", err)
+			}
+			names = append(names, name)
+		}
+
+		if next == 0 {
+			break
+		}
+		vaddr += next
+	}
+	return names, nil
+}
@@ -0,0 +1,33 @@
+package elfinfo
+
+import (
+	"fmt"
+	"github.com/mozilla/masche/listlibs"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"path/filepath"
+)
+
+// objectFiles returns the paths where the file of an object can be read, in order of preference. The map_files link
+// of its first segment is the file that was mapped even if it was deleted or replaced, and its path is resolved in the
+// root directory of the process, which may be in a container.
+func objectFiles(p process.Process, f listlibs.Library) []string {
+	if _, ok := p.(memaccess.MemorySource); ok {
+		if f.Deleted || f.Replaced {
+			return nil
+		}
+		return []string{f.Path}
+	}
+
+	// The directory p holds is used, so a process that reused the pid can't give its files.
+	procDir, err := process.ProcFilePath(p, "")
+	if err != nil {
+		return nil
+	}
+	s := f.Segments[0]
+	paths := []string{filepath.Join(procDir, "map_files", fmt.Sprintf("%x-%x", s.Address, s.Address+uintptr(s.Size)))}
+	if !f.Deleted && !f.Replaced {
+		paths = append(paths, filepath.Join(procDir, "root", f.Path))
+	}
+	return paths
+}
@@ -0,0 +1,177 @@
+package elfinfo
+
+import (
+	"errors"
+	"github.com/mozilla/masche/listlibs"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListObjects(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	objects, softerrors, err := ListObjects(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, softerrors, err := proc.Name()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var executable, libc *Object
+	for i, o := range objects {
+		if o.Path == name {
+			executable = &objects[i]
+		}
+		if strings.HasPrefix(o.SONAME, "libc.so") {
+			libc = &objects[i]
+		}
+	}
+	if executable == nil || libc == nil {
+		t.Fatal("Expected the executable and libc in the objects, got", objects)
+	}
+
+	if executable.SONAME != "" || len(executable.BuildID) == 0 || !contains(executable.Needed, libc.SONAME) {
+		t.Errorf("Unexpected metadata of the executable %+v", executable.Info)
+	}
+	versioned := false
+	for _, v := range libc.VersionDefinitions {
+		versioned = versioned || strings.HasPrefix(v, "GLIBC_2.")
+	}
+	if len(libc.BuildID) == 0 || !versioned || contains(libc.VersionDefinitions, libc.SONAME) {
+		t.Errorf("Unexpected metadata of libc %+v", libc.Info)
+	}
+
+	// The metadata read from memory must be the same as the one read from the files.
+	for _, o := range []*Object{executable, libc} {
+		if o.FromMemory {
+			t.Errorf("The metadata of %s was read from memory", o.Path)
+		}
+		info, softerrors, err := ReadMemory(proc, o.Base)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(info, o.Info) {
+			t.Errorf("The metadata of %s is %+v in memory and %+v in its file", o.Path, info, o.Info)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestObjectFiles(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	files, softerrors, err := listlibs.ListMappedFiles(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil || len(files) == 0 {
+		t.Fatal("Expected the mapped files of the test case and got", files, err)
+	}
+
+	// The paths go through the /proc/<pid> directory the process holds, not one looked up by pid.
+	procDir, err := process.ProcFilePath(proc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		paths := objectFiles(proc, f)
+		if len(paths) == 0 {
+			t.Errorf("No paths for %s", f.Path)
+		}
+		for _, path := range paths {
+			if !strings.HasPrefix(path, procDir+"/") {
+				t.Errorf("Path %s of %s is not in %s", path, f.Path, procDir)
+			}
+		}
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	for _, path := range objectFiles(proc, files[0]) {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("%s can still be read after the process exited", path)
+		}
+	}
+}
+
+func TestReadFileTruncatedAndNotELF(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "test", "tools", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "masche-elfinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Only the ELF header is left in the first file, so nothing can be read, and the second one lacks the dynamic
+	// section.
+	cases := []struct {
+		size int
+		err  error
+	}{
+		{64, ErrTruncated},
+		{1024, nil},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, "truncated")
+		if err := ioutil.WriteFile(path, data[:c.size], 0600); err != nil {
+			t.Fatal(err)
+		}
+		info, softerrors, err := ReadFile(path)
+		if err != c.err || len(softerrors) == 0 {
+			t.Errorf("Expected soft errors and %v reading the first %d bytes, got %+v, %v and %v", c.err, c.size,
+				info, softerrors, err)
+		}
+	}
+
+	path := filepath.Join(dir, "text")
+	if err := ioutil.WriteFile(path, []byte("Un dia vi una vaca vestida de uniforme"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadFile(path); !errors.Is(err, ErrNotELF) {
+		t.Error("Expected ErrNotELF and got", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package elfinfo
+
+import (
+	"github.com/mozilla/masche/listlibs"
+	"github.com/mozilla/masche/process"
+)
+
+// objectFiles returns the paths where the file of an object can be read, which is only its own if it wasn't deleted or
+// replaced.
+func objectFiles(p process.Process, f listlibs.Library) []string {
+	if f.Deleted || f.Replaced {
+		return nil
+	}
+	return []string{f.Path}
+}
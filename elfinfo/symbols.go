@@ -0,0 +1,323 @@
+package elfinfo
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"github.com/mozilla/masche/listlibs"
+	"github.com/mozilla/masche/process"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ErrUnknownAddress is returned when symbolizing an address that isn't in any of the objects loaded by the process.
+var ErrUnknownAddress = errors.New("The address isn't in any loaded object")
+
+// ErrSymbolNotFound is returned when looking up a symbol that isn't defined by the objects loaded by the process.
+var ErrSymbolNotFound = errors.New("Symbol not found")
+
+// Symbol is a function or variable defined by an object loaded by a process.
+type Symbol struct {
+	Name string
+	// Address is where the symbol is in the memory of the process.
+	Address uintptr
+	Size    uint64
+}
+
+// Location describes where an address of a process lies.
+type Location struct {
+	// Module is the path of the object the address is in, and Offset the distance from its base address.
+	Module string
+	Offset uintptr
+	// Symbol is the nearest symbol at or before the address, and SymbolOffset the distance from it. Symbol is empty if
+	// the object defines no symbol before the address.
+	Symbol       string
+	SymbolOffset uintptr
+}
+
+func (l Location) String() string {
+	s := fmt.Sprintf("%s+0x%x", filepath.Base(l.Module), l.Offset)
+	if l.Symbol != "" {
+		s += fmt.Sprintf(" (%s+0x%x)", l.Symbol, l.SymbolOffset)
+	}
+	return s
+}
+
+// Symbolizer translates addresses of a process to the objects and symbols they belong to, and symbol names to
+// addresses.
+//
+// The symbols of each object are read from its .symtab and .dynsym sections when its file can be read, and from the
+// dynamic symbols in memory otherwise. The objects and symbols are read when the Symbolizer is created, so it doesn't
+// know about the libraries loaded after that.
+type Symbolizer struct {
+	modules []module
+}
+
+type module struct {
+	listlibs.Library
+	// symbols are sorted by address.
+	symbols []Symbol
+}
+
+// NewSymbolizer reads the objects loaded by a process and their symbols. The objects whose symbols can't be read are
+// returned as soft errors, and addresses in them are still translated to object and offset.
+func NewSymbolizer(p process.Process) (s *Symbolizer, softerrors []error, harderror error) {
+	files, softerrors, harderror := listlibs.ListMappedFiles(p)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	s = &Symbolizer{}
+	for _, f := range files {
+		var symbols []Symbol
+		var err error
+		readObject(p, f, func(path string) error {
+			symbols, err = fileSymbols(path, f.Base)
+			return err
+		}, func() {
+			symbols, err = memorySymbols(p, f.Base)
+		})
+
+		if errors.Is(err, ErrNotELF) {
+			continue
+		}
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Could not read the symbols of %s: %w", f.Path, err))
+		}
+
+		sort.SliceStable(symbols, func(i, j int) bool {
+			return symbols[i].Address < symbols[j].Address
+		})
+		s.modules = append(s.modules, module{Library: f, symbols: symbols})
+	}
+	return s, softerrors, nil
+}
+
+// Symbolize returns the object and the nearest symbol an address is in. ErrUnknownAddress is returned if it isn't in
+// any loaded object.
+func (s *Symbolizer) Symbolize(address uintptr) (Location, error) {
+	for _, m := range s.modules {
+		inside := false
+		for _, segment := range m.Segments {
+			inside = inside || segment.Contains(address)
+		}
+		if !inside {
+			continue
+		}
+
+		l := Location{Module: m.Path, Offset: address - m.Base}
+		i := sort.Search(len(m.symbols), func(i int) bool {
+			return m.symbols[i].Address > address
+		})
+		if i > 0 {
+			symbol := m.symbols[i-1]
+			l.Symbol, l.SymbolOffset = symbol.Name, address-symbol.Address
+		}
+		return l, nil
+	}
+	return Location{}, ErrUnknownAddress
+}
+
+// Lookup returns the symbol with the given name, searching the objects in the order they are mapped, usually the
+// executable first. ErrSymbolNotFound is returned if no object defines it.
+func (s *Symbolizer) Lookup(name string) (Symbol, error) {
+	return s.LookupInModule("", name)
+}
+
+// LookupInModule works as Lookup, but only searches the objects whose path, or its last element, is module. If module
+// is empty every object is searched.
+func (s *Symbolizer) LookupInModule(module string, name string) (Symbol, error) {
+	for _, m := range s.modules {
+		if module != "" && module != m.Path && module != filepath.Base(m.Path) {
+			continue
+		}
+		for _, symbol := range m.symbols {
+			if symbol.Name == name {
+				return symbol, nil
+			}
+		}
+	}
+	return Symbol{}, ErrSymbolNotFound
+}
+
+// fileSymbols reads the symbols of the object in path, which is loaded at base.
+func fileSymbols(path string, base uintptr) ([]Symbol, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The image tells if it's an ELF object and its bias, and debug/elf finds the sections.
+	img, softerrors, err := openImage(f, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(softerrors) > 0 {
+		return nil, softerrors[0]
+	}
+	file, err := elf.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	symtab, err := file.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	dynsym, err := file.DynamicSymbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	return definedSymbols(append(symtab, dynsym...), uint64(base)+img.bias), nil
+}
+
+// memorySymbols reads the dynamic symbols of the object loaded at base in the memory of a process.
+func memorySymbols(p process.Process, base uintptr) ([]Symbol, error) {
+	img, softerrors, err := openImage(memoryReader{p}, uint64(base), true)
+	if err != nil {
+		return nil, err
+	}
+	if len(softerrors) > 0 {
+		return nil, softerrors[0]
+	}
+
+	symbols, err := img.dynamicSymbols()
+	if err != nil {
+		return nil, err
+	}
+	return definedSymbols(symbols, img.bias), nil
+}
+
+// definedSymbols returns the functions and variables defined in symbols, once each, relocated by bias. Absolute
+// symbols aren't addresses of the object and common ones aren't allocated yet, so neither of them is returned.
+func definedSymbols(symbols []elf.Symbol, bias uint64) []Symbol {
+	type key struct {
+		name  string
+		value uint64
+	}
+	seen := make(map[key]bool)
+
+	var defined []Symbol
+	for _, s := range symbols {
+		switch elf.ST_TYPE(s.Info) {
+		case elf.STT_FUNC, elf.STT_OBJECT, elf.STT_GNU_IFUNC:
+		default:
+			continue
+		}
+		k := key{s.Name, s.Value}
+		if s.Name == "" || s.Section == elf.SHN_UNDEF || s.Section == elf.SHN_ABS || s.Section == elf.SHN_COMMON ||
+			seen[k] {
+			continue
+		}
+		seen[k] = true
+		defined = append(defined, Symbol{Name: s.Name, Address: uintptr(bias + s.Value), Size: s.Size})
+	}
+	return defined
+}
+
+// dynamicSymbols reads the symbols of the dynamic section. Their number is only known through the hash tables.
+func (img *image) dynamicSymbols() ([]elf.Symbol, error) {
+	d, err := img.dynamic()
+	if err != nil {
+		return nil, err
+	}
+	if d == nil || d.symtab == 0 {
+		return nil, errors.New("The object has no dynamic symbols")
+	}
+
+	count, err := img.symbolCount(d)
+	if err != nil {
+		return nil, err
+	}
+	str, err := img.stringTable(d)
+	if err != nil {
+		return nil, err
+	}
+
+	symSize := uint64(elf.Sym32Size)
+	if img.class == elf.ELFCLASS64 {
+		symSize = elf.Sym64Size
+	}
+	if count > maxTableSize/symSize {
+		return nil, fmt.Errorf("Too many dynamic symbols (%d)", count)
+	}
+	data, err := img.read(d.symtab, count*symSize)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the dynamic symbols: %w", err)
+	}
+
+	var symbols []elf.Symbol
+	for ; len(data) > 0; data = data[symSize:] {
+		var s elf.Symbol
+		var name uint32
+		if img.class == elf.ELFCLASS64 {
+			name = img.order.Uint32(data[0:])
+			s.Info, s.Other = data[4], data[5]
+			s.Section = elf.SectionIndex(img.order.Uint16(data[6:]))
+			s.Value, s.Size = img.order.Uint64(data[8:]), img.order.Uint64(data[16:])
+		} else {
+			name = img.order.Uint32(data[0:])
+			s.Value, s.Size = uint64(img.order.Uint32(data[4:])), uint64(img.order.Uint32(data[8:]))
+			s.Info, s.Other = data[12], data[13]
+			s.Section = elf.SectionIndex(img.order.Uint16(data[14:]))
+		}
+		if s.Name, err = str(uint64(name)); err != nil {
+			return symbols, fmt.Errorf("Could not read the name of a dynamic symbol: %w", err)
+		}
+		symbols = append(symbols, s)
+	}
+	return symbols, nil
+}
+
+// symbolCount returns the number of dynamic symbols, from the DT_HASH table if there's one, or from the last chain of
+// the DT_GNU_HASH table.
+func (img *image) symbolCount(d *dynamic) (uint64, error) {
+	if d.hash != 0 {
+		header, err := img.read(d.hash, 8)
+		if err != nil {
+			return 0, fmt.Errorf("Could not read the hash table: %w", err)
+		}
+		return uint64(img.order.Uint32(header[4:])), nil
+	}
+	if d.gnuHash == 0 {
+		return 0, errors.New("The object has no hash table")
+	}
+
+	header, err := img.read(d.gnuHash, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read the GNU hash table: %w", err)
+	}
+	nbuckets := uint64(img.order.Uint32(header[0:]))
+	symoffset := uint64(img.order.Uint32(header[4:]))
+	bloomSize := uint64(img.order.Uint32(header[8:]))
+
+	bucketsAddress := d.gnuHash + 16 + bloomSize*uint64(img.wordSize())
+	buckets, err := img.read(bucketsAddress, nbuckets*4)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read the GNU hash table: %w", err)
+	}
+	last := uint64(0)
+	for ; len(buckets) > 0; buckets = buckets[4:] {
+		if b := uint64(img.order.Uint32(buckets)); b > last {
+			last = b
+		}
+	}
+	if last < symoffset {
+		return symoffset, nil
+	}
+
+	// The chain of the last bucket ends with the last symbol, which has the lowest bit of its hash set.
+	chains := bucketsAddress + nbuckets*4
+	for i := last; i-last < maxTableSize/4; i++ {
+		hash, err := img.read(chains+(i-symoffset)*4, 4)
+		if err != nil {
+			return 0, fmt.Errorf("Could not read the GNU hash table: %w", err)
+		}
+		if img.order.Uint32(hash)&1 != 0 {
+			return i + 1, nil
+		}
+	}
+	return 0, errors.New("The GNU hash table has no end")
+}
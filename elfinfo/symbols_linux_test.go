@@ -0,0 +1,102 @@
+package elfinfo
+
+import (
+	"debug/elf"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"strings"
+	"testing"
+)
+
+func TestSymbolizer(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	s, softerrors, err := NewSymbolizer(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, softerrors, err := proc.Name()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// main is only in the symbol table of the executable, and malloc in the dynamic symbols of libc.
+	for _, c := range []struct{ module, symbol string }{{name, "main"}, {"", "malloc"}} {
+		symbol, err := s.LookupInModule(c.module, c.symbol)
+		if err != nil {
+			t.Fatalf("Looking up %s: %v", c.symbol, err)
+		}
+
+		l, err := s.Symbolize(symbol.Address + 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.module != "" && l.Module != c.module {
+			t.Errorf("Expected %s in %s, got %v", c.symbol, c.module, l)
+		}
+		found, err := s.LookupInModule(l.Module, l.Symbol)
+		if err != nil || found.Address != symbol.Address || l.SymbolOffset != 1 {
+			t.Errorf("Expected %x to be 1 byte after %s, got %v", symbol.Address+1, c.symbol, l)
+		}
+		if !strings.Contains(l.String(), "+0x1)") {
+			t.Errorf("Unexpected string %s of %v", l.String(), l)
+		}
+
+		// The dynamic symbols are also read from memory, with the same addresses.
+		for _, m := range s.modules {
+			if m.Path != l.Module || c.module != "" {
+				continue
+			}
+			symbols, err := memorySymbols(proc, m.Base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inMemory := false
+			for _, sym := range symbols {
+				inMemory = inMemory || (sym.Name == c.symbol && sym.Address == symbol.Address)
+			}
+			if !inMemory {
+				t.Errorf("%s wasn't found at %x in the dynamic symbols read from memory", c.symbol, symbol.Address)
+			}
+		}
+	}
+
+	if _, err := s.Lookup("Un dia vi una vaca vestida de uniforme"); err != ErrSymbolNotFound {
+		t.Error("Expected ErrSymbolNotFound and got", err)
+	}
+	if _, err := s.Symbolize(0); err != ErrUnknownAddress {
+		t.Error("Expected ErrUnknownAddress and got", err)
+	}
+}
+
+func TestDefinedSymbols(t *testing.T) {
+	function := elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC)
+	object := elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT)
+	symbols := []elf.Symbol{
+		{Name: "defined", Info: function, Section: 12, Value: 0x1000, Size: 16},
+		{Name: "defined", Info: function, Section: 12, Value: 0x1000, Size: 16},
+		{Name: "undefined", Info: function, Section: elf.SHN_UNDEF},
+		{Name: "absolute", Info: object, Section: elf.SHN_ABS, Value: 0x2a},
+		{Name: "common", Info: object, Section: elf.SHN_COMMON, Value: 8, Size: 4},
+		{Name: "section", Info: elf.ST_INFO(elf.STB_LOCAL, elf.STT_SECTION), Section: 12, Value: 0x1000},
+	}
+
+	defined := definedSymbols(symbols, 0x400000)
+	if len(defined) != 1 || defined[0] != (Symbol{Name: "defined", Address: 0x401000, Size: 16}) {
+		t.Errorf("Expected only the defined symbol relocated, got %+v", defined)
+	}
+}
@@ -0,0 +1,40 @@
+// This program writes an ELF core file with the memory of a process, which can be opened later with gdb.
+package main
+
+import (
+	"flag"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"log"
+	"os"
+)
+
+var (
+	pid    = flag.Uint("pid", 0, "Process to dump")
+	output = flag.String("o", "core", "File to write the core to")
+)
+
+func logErrors(softerrors []error, harderror error) {
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	for _, soft := range softerrors {
+		log.Print(soft)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	p, softerrors, harderror := process.OpenFromPid(*pid)
+	logErrors(softerrors, harderror)
+	defer p.Close()
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	logErrors(memaccess.DumpCore(p, f))
+}
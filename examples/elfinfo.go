@@ -0,0 +1,50 @@
+// This program prints the SONAME, GNU build-id, needed libraries and defined versions of the executable and the
+// libraries loaded by a process, for example:
+// ./elfinfo -pid=1234
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mozilla/masche/elfinfo"
+	"github.com/mozilla/masche/process"
+	"log"
+	"strings"
+)
+
+var (
+	pid     = flag.Int("pid", 0, "Process id to analyze")
+	verbose = flag.Bool("v", false, "Print the errors found while reading the objects")
+)
+
+func main() {
+	flag.Parse()
+
+	p, softerrors, harderror := process.OpenFromPid(uint(*pid))
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	defer p.Close()
+
+	objects, serrs, harderror := elfinfo.ListObjects(p)
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	if *verbose {
+		for _, err := range append(softerrors, serrs...) {
+			log.Println(err)
+		}
+	}
+
+	for _, o := range objects {
+		source := "file"
+		if o.FromMemory {
+			source = "memory"
+		}
+		fmt.Printf("%s (read from %s)\n", o.Path, source)
+		fmt.Printf("\tSONAME: %s\n", o.SONAME)
+		fmt.Printf("\tBuild-id: %s\n", o.BuildID)
+		fmt.Printf("\tNeeded: %s\n", strings.Join(o.Needed, ", "))
+		fmt.Printf("\tVersions: %s\n", strings.Join(o.VersionDefinitions, ", "))
+	}
+}
@@ -0,0 +1,61 @@
+// This program lists the processes that have mappings that may hold injected code, like executable anonymous memory
+// or ELF headers in anonymous memory, with the reasons and the first bytes of each mapping. The -pid flag restricts
+// the search to a single process, for example:
+// ./findinjected -pid=1234
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/mozilla/masche/injection"
+	"github.com/mozilla/masche/process"
+	"log"
+	"strings"
+)
+
+var (
+	pid     = flag.Int("pid", 0, "Process id to analyze, or 0 to analyze all of them")
+	verbose = flag.Bool("v", false, "Print the errors found while checking the processes")
+)
+
+func main() {
+	flag.Parse()
+
+	var ps []process.Process
+	var softerrors []error
+	var harderror error
+	if *pid != 0 {
+		var p process.Process
+		p, softerrors, harderror = process.OpenFromPid(uint(*pid))
+		ps = []process.Process{p}
+	} else {
+		ps, softerrors, harderror = process.OpenAll()
+	}
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	defer process.CloseAll(ps)
+
+	suspicious, serrs, harderror := injection.FindSuspiciousProcesses(ps)
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	if *verbose {
+		for _, err := range append(softerrors, serrs...) {
+			log.Println(err)
+		}
+	}
+
+	for _, p := range suspicious {
+		fmt.Printf("[%d] %s\n", p.Pid, p.Name)
+		for _, f := range p.Findings {
+			reasons := make([]string, len(f.Reasons))
+			for i, r := range f.Reasons {
+				reasons[i] = string(r)
+			}
+			fmt.Printf("\t%v: %s\n", f.Mapping, strings.Join(reasons, ", "))
+			fmt.Printf("\t\t%s\n", hex.EncodeToString(f.Preview))
+		}
+	}
+}
@@ -6,12 +6,10 @@
 package main
 
 import (
-	"encoding/hex"
 	"flag"
 	"io/ioutil"
 	"log"
 	"regexp"
-	"strings"
 
 	"github.com/mozilla/masche/memaccess"
 	"github.com/mozilla/masche/memsearch"
@@ -33,7 +31,8 @@ var (
 	regexpString = flag.String("regexp", "regexp?", "Regexp to search for")
 
 	// file-search action flags
-	fileneedle = flag.String("fileneedle", "example.in", "Filename that contains hex-encoded needle (spaces are ignored)")
+	fileneedle = flag.String("fileneedle", "example.in",
+		"Filename that contains a hex pattern, e.g. \"4D 5A ?? ?? [4-16] 50 45 00 00 (6A|68)\" (spaces are ignored)")
 )
 
 func logErrors(softerrors []error, harderror error) {
@@ -60,12 +59,11 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		encoded := strings.Replace(strings.Replace(strings.TrimSpace(string(data)), " ", "", -1), "\n", "", -1)
-		data, err = hex.DecodeString(encoded)
+		pattern, err := memsearch.ParseHexPattern(string(data))
 		if err != nil {
 			log.Fatal(err)
 		}
-		found, address, softerrors, harderror := memsearch.FindBytesSequence(proc, uintptr(*addr), data)
+		found, address, softerrors, harderror := memsearch.FindMatch(proc, uintptr(*addr), pattern)
 		logErrors(softerrors, harderror)
 		if found {
 			log.Printf("Found in address: %x\n", address)
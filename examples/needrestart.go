@@ -0,0 +1,65 @@
+// This program lists the processes that must be restarted because they are still running libraries (or executables)
+// that were deleted or replaced since they were loaded, usually by a package upgrade. The -r flag restricts the report
+// to the libraries whose path matches a regexp, for example:
+// ./needrestart -r="libssl" lists the processes that still use an old version of libssl.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mozilla/masche/listlibs"
+	"github.com/mozilla/masche/process"
+	"log"
+	"regexp"
+)
+
+var (
+	rstr    = flag.String("r", "", "Regular expression the paths of the libraries must match")
+	verbose = flag.Bool("v", false, "Print the errors found while checking the processes")
+)
+
+func main() {
+	flag.Parse()
+
+	r, err := regexp.Compile(*rstr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ps, softerrors, harderror := process.OpenAll()
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	defer process.CloseAll(ps)
+
+	stale, serrs, harderror := listlibs.FindStaleProcesses(ps)
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	if *verbose {
+		for _, err := range append(softerrors, serrs...) {
+			log.Println(err)
+		}
+	}
+
+	for _, p := range stale {
+		var libraries []listlibs.Library
+		for _, l := range p.Libraries {
+			if r.MatchString(l.Path) {
+				libraries = append(libraries, l)
+			}
+		}
+		if len(libraries) == 0 {
+			continue
+		}
+
+		fmt.Printf("[%d] %s\n", p.Pid, p.Name)
+		for _, l := range libraries {
+			status := "replaced"
+			if l.Deleted {
+				status = "deleted"
+			}
+			fmt.Printf("\t%s (%s)\n", l.Path, status)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// This program evaluates the rules in a file on the memory of the processes whose name matches a given regexp, and
+// prints the rules that matched and where their strings were found.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/rules"
+	"io/ioutil"
+	"log"
+	"regexp"
+)
+
+var (
+	rulesFile = flag.String("rules", "example.rules", "File with the rules to evaluate")
+	reg       = flag.String("r", ".*", "Regular Expression the process names must match")
+	mappings  = flag.Bool("mappings", false, "Evaluate the rules on each mapping instead of on the whole process")
+)
+
+func logErrors(softerrors []error, harderror error) {
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	for _, soft := range softerrors {
+		log.Print(soft)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	src, err := ioutil.ReadFile(*rulesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rs, err := rules.Compile(string(src))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r, err := regexp.Compile(*reg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ps, softerrors, harderror := process.OpenByName(r)
+	logErrors(softerrors, harderror)
+	defer process.CloseAll(ps)
+
+	scope := rules.ProcessScope
+	if *mappings {
+		scope = rules.MappingScope
+	}
+
+	for _, p := range ps {
+		matches, softerrors, harderror := rs.ScanContext(context.Background(), p, scope, nil)
+		if harderror != nil {
+			log.Printf("Pid %d: %v", p.Pid(), harderror)
+			continue
+		}
+		logErrors(softerrors, nil)
+
+		for _, m := range matches {
+			if m.Mapping != nil {
+				fmt.Printf("Pid %d: rule %s matched in %v\n", p.Pid(), m.Rule, *m.Mapping)
+			} else {
+				fmt.Printf("Pid %d: rule %s matched\n", p.Pid(), m.Rule)
+			}
+			for _, s := range m.Strings {
+				fmt.Printf("\t%s at 0x%x (offset 0x%x in %s)\n", s.Identifier, s.Address, s.Offset, s.Mapping.Path)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// This program translates addresses of a process to the object and symbol they are in, and symbols to their addresses,
+// for example:
+// ./symbolize -pid=1234 -addr=7f3a12345678
+// ./symbolize -pid=1234 -sym=environ -module=libc.so.6
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mozilla/masche/elfinfo"
+	"github.com/mozilla/masche/process"
+	"log"
+	"strconv"
+)
+
+var (
+	pid     = flag.Int("pid", 0, "Process id to analyze")
+	addr    = flag.String("addr", "", "Address to symbolize, in hexadecimal")
+	sym     = flag.String("sym", "", "Symbol to look up")
+	module  = flag.String("module", "", "Object the symbol must be in, by its path or file name")
+	verbose = flag.Bool("v", false, "Print the errors found while reading the symbols")
+)
+
+func main() {
+	flag.Parse()
+
+	p, softerrors, harderror := process.OpenFromPid(uint(*pid))
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	defer p.Close()
+
+	s, serrs, harderror := elfinfo.NewSymbolizer(p)
+	if harderror != nil {
+		log.Fatal(harderror)
+	}
+	if *verbose {
+		for _, err := range append(softerrors, serrs...) {
+			log.Println(err)
+		}
+	}
+
+	if *addr != "" {
+		address, err := strconv.ParseUint(*addr, 16, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l, err := s.Symbolize(uintptr(address))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%x: %v\n", address, l)
+	}
+
+	if *sym != "" {
+		symbol, err := s.LookupInModule(*module, *sym)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %x (%d bytes)\n", symbol.Name, symbol.Address, symbol.Size)
+	}
+}
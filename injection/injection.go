@@ -0,0 +1,147 @@
+// Package injection looks for signs of code injected into processes, like shellcode or reflectively loaded modules, in
+// their memory mappings.
+//
+// Its findings are suspicious, not necessarily malicious: JIT compilers map anonymous executable memory too, and the
+// libraries of a process show as deleted after upgrading them. Each finding carries the first bytes of its mapping so
+// they can be reviewed.
+package injection
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"strings"
+)
+
+// Reason is a code that tells why a mapping is suspicious.
+type Reason string
+
+const (
+	// ExecutableAnonymous is reported for executable mappings that aren't backed by a file.
+	ExecutableAnonymous Reason = "executable-anonymous"
+	// WritableExecutable is reported for mappings that are both writable and executable.
+	WritableExecutable Reason = "writable-executable"
+	// ExecutableMemfd is reported for executable mappings of memfd files, which only exist in memory.
+	ExecutableMemfd Reason = "executable-memfd"
+	// ExecutableDeleted is reported for executable mappings of files that were deleted since they were mapped.
+	ExecutableDeleted Reason = "executable-deleted"
+	// AnonymousELF is reported for mappings that aren't backed by a file and start with an ELF header.
+	AnonymousELF Reason = "anonymous-elf"
+)
+
+// PreviewSize is the number of bytes of each suspicious mapping returned in its finding.
+const PreviewSize = 64
+
+// Finding is a suspicious mapping of a process.
+type Finding struct {
+	memaccess.Mapping
+	Reasons []Reason
+	// Preview holds the first bytes of the mapping, up to PreviewSize. It's empty if they couldn't be read.
+	Preview []byte
+}
+
+// SuspiciousProcess is a process that has suspicious mappings.
+type SuspiciousProcess struct {
+	process.Identity
+
+	// Findings holds the suspicious mappings of the process, as returned by FindSuspiciousMappings.
+	Findings []Finding
+}
+
+// kernelPseudoMappings are the pseudo-paths of the mappings set up by the kernel, which aren't anonymous memory of the
+// process even if they have no backing file.
+var kernelPseudoMappings = map[string]bool{
+	"[vdso]":        true,
+	"[vsyscall]":    true,
+	"[vvar]":        true,
+	"[vvar_vclock]": true,
+	"[uprobes]":     true,
+	"[sigpage]":     true,
+	"[vectors]":     true,
+}
+
+// FindSuspiciousMappings returns the mappings of a process that may hold injected code, sorted by address. The
+// mappings whose first bytes can't be read are still returned, and the reason is returned as a soft error.
+func FindSuspiciousMappings(p process.Process) (findings []Finding, softerrors []error, harderror error) {
+	mappings, softerrors, harderror := memaccess.ListMappings(p)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	for _, m := range mappings {
+		var reasons []Reason
+		anonymous := isAnonymous(m)
+		memfd := strings.HasPrefix(m.Path, "/memfd:")
+
+		if m.IsExecutable() {
+			if anonymous {
+				reasons = append(reasons, ExecutableAnonymous)
+			}
+			if m.IsWritable() {
+				reasons = append(reasons, WritableExecutable)
+			}
+			if memfd {
+				reasons = append(reasons, ExecutableMemfd)
+			} else if m.Deleted && !anonymous {
+				reasons = append(reasons, ExecutableDeleted)
+			}
+		}
+
+		// Only the first bytes of anonymous mappings are read unless they are suspicious already, as reading those of
+		// every file mapping is slow and useless.
+		if len(reasons) == 0 && !(anonymous && m.IsReadable()) {
+			continue
+		}
+
+		size := PreviewSize
+		if uint(size) > m.Size {
+			size = int(m.Size)
+		}
+		preview := make([]byte, size)
+		serrs, err := memaccess.CopyMemory(p, m.Address, preview)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Could not read the first bytes of %v: %w", m, err))
+			preview = nil
+		}
+
+		if anonymous && bytes.HasPrefix(preview, []byte("\x7fELF")) {
+			reasons = append(reasons, AnonymousELF)
+		}
+		if len(reasons) > 0 {
+			findings = append(findings, Finding{Mapping: m, Reasons: reasons, Preview: preview})
+		}
+	}
+	return findings, softerrors, nil
+}
+
+// isAnonymous returns true if the mapping isn't backed by a file, including the heap, the stacks and the shared
+// anonymous mappings, which are backed by a deleted /dev/zero.
+func isAnonymous(m memaccess.Mapping) bool {
+	if m.Pseudo {
+		return !kernelPseudoMappings[m.Path]
+	}
+	return m.Path == "" || m.Path == "/dev/zero"
+}
+
+// FindSuspiciousProcesses returns the processes of ps that have suspicious mappings, as reported by
+// FindSuspiciousMappings. They are checked as process.CheckAll does.
+func FindSuspiciousProcesses(ps []process.Process) (suspicious []SuspiciousProcess, softerrors []error,
+	harderror error) {
+
+	var findings [][]Finding
+	found, softerrors := process.CheckAll(ps, func(p process.Process) (bool, []error, error) {
+		f, softerrors, harderror := FindSuspiciousMappings(p)
+		if harderror != nil || len(f) == 0 {
+			return false, softerrors, harderror
+		}
+		findings = append(findings, f)
+		return true, softerrors, nil
+	})
+
+	for i, id := range found {
+		suspicious = append(suspicious, SuspiciousProcess{Identity: id, Findings: findings[i]})
+	}
+	return suspicious, softerrors, nil
+}
@@ -0,0 +1,126 @@
+package injection
+
+import (
+	"bytes"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestFindSuspiciousMappingsOfCleanProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	findings, softerrors, err := FindSuspiciousMappings(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Error("Expected no suspicious mappings in the test process, got", findings)
+	}
+}
+
+// mapAnonymous maps anonymous memory with the given protection in this process, starting with data.
+func mapAnonymous(t *testing.T, data []byte, prot int) []byte {
+	mem, err := syscall.Mmap(-1, 0, 4096, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(mem, data)
+	if err := syscall.Mprotect(mem, prot); err != nil {
+		syscall.Munmap(mem)
+		t.Skip("The protection can't be set, probably forbidden by the system:", err)
+	}
+	return mem
+}
+
+func TestFindSuspiciousMappings(t *testing.T) {
+	header := []byte("\x7fELF\x02\x01\x01")
+	rwx := mapAnonymous(t, header, syscall.PROT_READ|syscall.PROT_WRITE|syscall.PROT_EXEC)
+	defer syscall.Munmap(rwx)
+	readOnly := mapAnonymous(t, header, syscall.PROT_READ)
+	defer syscall.Munmap(readOnly)
+
+	dir, err := ioutil.TempDir("", "masche-injection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "payload")
+	if err := ioutil.WriteFile(path, []byte("shellcode"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := syscall.Mmap(int(f.Fd()), 0, 4096, syscall.PROT_READ|syscall.PROT_EXEC, syscall.MAP_PRIVATE)
+	f.Close()
+	if err != nil {
+		t.Skip("Files can't be mapped as executable in the temporary directory:", err)
+	}
+	defer syscall.Munmap(deleted)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	proc, softerrors, err := process.OpenFromPid(uint(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	suspicious, softerrors, err := FindSuspiciousProcesses([]process.Process{proc})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suspicious) != 1 || suspicious[0].Pid != uint(os.Getpid()) {
+		t.Fatal("Expected this process to be suspicious, got", suspicious)
+	}
+
+	expected := []struct {
+		mem     []byte
+		reasons []Reason
+		preview []byte
+	}{
+		{rwx, []Reason{ExecutableAnonymous, WritableExecutable, AnonymousELF}, header},
+		{readOnly, []Reason{AnonymousELF}, header},
+		{deleted, []Reason{ExecutableDeleted}, []byte("shellcode")},
+	}
+	for _, e := range expected {
+		address := uintptr(unsafe.Pointer(&e.mem[0]))
+		found := false
+		for _, f := range suspicious[0].Findings {
+			if f.Address != address {
+				continue
+			}
+			found = true
+			if !reflect.DeepEqual(f.Reasons, e.reasons) || len(f.Preview) != PreviewSize ||
+				!bytes.HasPrefix(f.Preview, e.preview) {
+				t.Errorf("Expected the reasons %v and the preview %q, got %v", e.reasons, e.preview, f)
+			}
+		}
+		if !found {
+			t.Errorf("The mapping at %x wasn't reported as suspicious", address)
+		}
+	}
+}
@@ -1,16 +1,167 @@
 package listlibs
 
 import (
+	"fmt"
 	"regexp"
+	"runtime"
 
+	"github.com/mozilla/masche/memaccess"
 	"github.com/mozilla/masche/process"
 )
 
-// ListLoadedLibraries lists all the libraries (their absolute paths) loaded by a process.
+// Library is a file mapped in the memory of a process, usually a shared library. Files mapped more than once are a
+// single Library, unless the mappings are of different files that were at the same path, like an old version of a
+// library that was deleted when it was upgraded and the new one.
+type Library struct {
+	// Path is the path of the file, without the " (deleted)" suffix of the deleted ones.
+	Path string
+
+	// Base is the address the start of the file is mapped at, even if that part isn't mapped anymore. For shared
+	// libraries it's the load base that their symbols' addresses are relative to.
+	Base uintptr
+
+	// Segments holds every mapping of the file, sorted by address.
+	Segments []memaccess.Mapping
+
+	// DevMajor, DevMinor and Inode identify the mapped file, unless IdentityUnknown is set because its mappings don't
+	// have them, as in core files.
+	DevMajor        uint32
+	DevMinor        uint32
+	Inode           uint64
+	IdentityUnknown bool
+
+	// Deleted is true if the file was removed from Path after it was mapped, which includes having another file
+	// renamed over it, as package managers do when they upgrade it.
+	Deleted bool
+
+	// Replaced is true if Path doesn't refer to the mapped file anymore: there's no file or there's another one. It
+	// can be true even if Deleted isn't, e.g. if the file was replaced from another mount namespace. It's only checked
+	// on Linux, and for processes opened from dumps it's checked against the files of this machine. It's always false
+	// if IdentityUnknown is set, as there's nothing to compare the file at Path with.
+	Replaced bool
+}
+
+// ListLibraries lists the libraries loaded by a process, excluding its executable. On Linux they are the files mapped
+// in its memory, as with ListLoadedLibraries, and on other OSes only their paths are known.
+func ListLibraries(p process.Process) (libraries []Library, softerrors []error, harderror error) {
+	if s, ok := p.(memaccess.MemorySource); ok {
+		libraries, softerrors, harderror = sourceLibraries(s, false)
+		if harderror != nil {
+			return nil, softerrors, harderror
+		}
+		return libraries, append(softerrors, checkReplaced(libraries, "", "")...), nil
+	}
+
+	// This function is implemented by the OS-specific listLibraries function.
+	return listLibraries(p, false)
+}
+
+// ListMappedFiles works as ListLibraries, but it also includes the executable of the process.
+//
+// NOTE: It's only supported on Linux, and for processes opened from dumps.
+func ListMappedFiles(p process.Process) (files []Library, softerrors []error, harderror error) {
+	if s, ok := p.(memaccess.MemorySource); ok {
+		files, softerrors, harderror = sourceLibraries(s, true)
+		if harderror != nil {
+			return nil, softerrors, harderror
+		}
+		return files, append(softerrors, checkReplaced(files, "", "")...), nil
+	}
+	if runtime.GOOS != "linux" {
+		return nil, nil, fmt.Errorf("Listing the mapped files is not supported on %s", runtime.GOOS)
+	}
+
+	// This function is implemented by the OS-specific listLibraries function.
+	return listLibraries(p, true)
+}
+
+// ListLoadedLibraries lists all the libraries (their absolute paths) loaded by a process. On Linux the paths of the
+// libraries that were deleted end in " (deleted)".
+//
+// If p is a memaccess.MemorySource, like the processes opened from dumps by the offline package, they are listed from
+// its mappings.
 func ListLoadedLibraries(p process.Process) (libraries []string, softerrors []error, harderror error) {
+	if s, ok := p.(memaccess.MemorySource); ok {
+		libs, softerrors, harderror := sourceLibraries(s, false)
+		return libraryPaths(libs), softerrors, harderror
+	}
 	return listLoadedLibraries(p)
 }
 
+// sourceLibraries lists the libraries of a MemorySource from its mappings, including its executable if withExecutable
+// is true.
+func sourceLibraries(s memaccess.MemorySource, withExecutable bool) (libraries []Library, softerrors []error,
+	harderror error) {
+
+	processName, softerrors, harderror := s.Name()
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	mappings, serrs, harderror := s.Mappings()
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	if withExecutable {
+		processName = ""
+	}
+	return librariesFromMappings(mappings, processName), softerrors, nil
+}
+
+// deletedSuffix is appended to the paths of the deleted files in the maps files of Linux.
+const deletedSuffix = " (deleted)"
+
+// librariesFromMappings groups the mappings of files by the file they map, leaving out the executable of the process,
+// whose path is exe unless it's empty, and the mappings that aren't of libraries.
+func librariesFromMappings(mappings []memaccess.Mapping, exe string) (libraries []Library) {
+	type fileID struct {
+		path     string
+		devMajor uint32
+		devMinor uint32
+		inode    uint64
+		deleted  bool
+	}
+
+	indexes := make(map[fileID]int)
+	for _, m := range mappings {
+		isExe := exe != "" && (m.Path == exe || m.Path+deletedSuffix == exe)
+		if m.Path == "" || m.Pseudo || m.Path == "/dev/zero" || isExe {
+			continue
+		}
+
+		id := fileID{m.Path, m.DevMajor, m.DevMinor, m.Inode, m.Deleted}
+		i, ok := indexes[id]
+		if !ok {
+			i = len(libraries)
+			indexes[id] = i
+			libraries = append(libraries, Library{Path: m.Path, Base: m.Address - uintptr(m.Offset),
+				DevMajor: m.DevMajor, DevMinor: m.DevMinor, Inode: m.Inode, IdentityUnknown: m.IdentityUnknown,
+				Deleted: m.Deleted})
+		}
+		libraries[i].Segments = append(libraries[i].Segments, m)
+	}
+	return libraries
+}
+
+// libraryPaths returns the paths of the libraries, without duplicates, and with the " (deleted)" suffix for the
+// deleted ones.
+func libraryPaths(libraries []Library) (paths []string) {
+	seen := make(map[string]bool)
+	for _, l := range libraries {
+		path := l.Path
+		if l.Deleted {
+			path += deletedSuffix
+		}
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
 // GetMatchingLoadedLibraries lists the libraries loaded by process p whose path matches r.
 func GetMatchingLoadedLibraries(p process.Process, r *regexp.Regexp) (libraries []string, softerrors []error,
 	harderror error,
@@ -29,3 +180,12 @@ func GetMatchingLoadedLibraries(p process.Process, r *regexp.Regexp) (libraries
 
 	return
 }
+
+// LibraryMatches returns a process.Selector for the processes that have loaded a library whose path matches r.
+func LibraryMatches(r *regexp.Regexp) process.Selector {
+	return process.NewSelector(process.CostExpensive,
+		func(p process.Process) (matches bool, softerrors []error, harderror error) {
+			libraries, softerrors, harderror := GetMatchingLoadedLibraries(p, r)
+			return len(libraries) != 0, softerrors, harderror
+		})
+}
@@ -1,69 +1,111 @@
 package listlibs
 
 import (
-	"bufio"
 	"fmt"
-	"github.com/mozilla/masche/common"
+	"github.com/mozilla/masche/memaccess"
 	"github.com/mozilla/masche/process"
 	"os"
+	"path/filepath"
+	"syscall"
 )
 
 func listLoadedLibraries(p process.Process) (libraries []string, softerrors []error, harderror error) {
-
-	mapsFile, harderror := os.Open(common.MapsFilePathFromPid(p.Pid()))
+	libs, softerrors, harderror := librariesOfProcess(p, false)
 	if harderror != nil {
-		return
+		return nil, softerrors, harderror
 	}
-	defer mapsFile.Close()
+	return libraryPaths(libs), softerrors, nil
+}
+
+func listLibraries(p process.Process, withExecutable bool) (libraries []Library, softerrors []error,
+	harderror error) {
 
-	scanner := bufio.NewScanner(mapsFile)
-	processName, softerrors, harderror := p.Name()
+	libraries, softerrors, harderror = librariesOfProcess(p, withExecutable)
 	if harderror != nil {
-		return
+		return nil, softerrors, harderror
 	}
 
-	libs := make([]string, 0, 10)
-	for scanner.Scan() {
-		line := scanner.Text()
-		items := common.SplitMapsFileEntry(line)
+	// The paths are resolved in the root directory of the process, which may be in a container, and the mapped files
+	// are identified through its map_files directory.
+	root, err := process.ProcFilePath(p, "root")
+	if err != nil {
+		return nil, softerrors, err
+	}
+	mapFiles, err := process.ProcFilePath(p, "map_files")
+	if err != nil {
+		return nil, softerrors, err
+	}
+	serrs := checkReplaced(libraries, root, mapFiles)
+	return libraries, append(softerrors, serrs...), nil
+}
 
-		if len(items) != 6 {
-			return libs, softerrors, fmt.Errorf("Unrecognised maps line: %s", line)
-		}
+// librariesOfProcess lists the libraries of a running process from its maps file, including its executable if
+// withExecutable is true.
+func librariesOfProcess(p process.Process, withExecutable bool) (libraries []Library, softerrors []error,
+	harderror error) {
 
-		path := items[5]
-		if path == processName {
-			continue
+	mappings, softerrors, harderror := memaccess.ListMappings(p)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	exe := ""
+	if !withExecutable {
+		var serrs []error
+		exe, serrs, harderror = p.Name()
+		softerrors = append(softerrors, serrs...)
+		if harderror != nil {
+			return nil, softerrors, harderror
 		}
+	}
 
-		if path == "/dev/zero" || path == "/dev/zero (deleted)" {
+	return librariesFromMappings(mappings, exe), softerrors, nil
+}
+
+// checkReplaced sets the Replaced field of the libraries, comparing the mapped file of each of them with the file that
+// is at its path now, resolved in the root directory. A soft error is returned for every file that can't be checked.
+// The libraries whose IdentityUnknown is set are skipped.
+//
+// If mapFiles isn't empty it's the map_files directory of the process, whose links identify the mapped files better
+// than the device and inode of the maps file: in overlay filesystems, which containers use, those are the ones of the
+// underlying file, while stat(2) returns the ones of the overlay. Reading it requires the same permissions as reading
+// the memory of the process, so if it can't be read the device and inode of the maps file are used.
+func checkReplaced(libraries []Library, root string, mapFiles string) (softerrors []error) {
+	for i := range libraries {
+		l := &libraries[i]
+		if l.IdentityUnknown {
 			continue
 		}
 
-		if path == "" {
+		info, err := os.Stat(filepath.Join(root, l.Path))
+		if os.IsNotExist(err) {
+			l.Replaced = true
 			continue
 		}
-
-		if path[0] == '[' {
+		if err != nil {
+			softerrors = append(softerrors, err)
 			continue
 		}
+		current := info.Sys().(*syscall.Stat_t)
 
-		if inSlice(path, libs) {
-			continue
+		if mapFiles != "" {
+			s := l.Segments[0]
+			link := filepath.Join(mapFiles, fmt.Sprintf("%x-%x", s.Address, s.Address+uintptr(s.Size)))
+			if mapped, err := os.Stat(link); err == nil {
+				l.Replaced = !os.SameFile(info, mapped)
+				continue
+			}
 		}
 
-		libs = append(libs, path)
+		major, minor := splitDevice(uint64(current.Dev))
+		l.Replaced = major != l.DevMajor || minor != l.DevMinor || uint64(current.Ino) != l.Inode
 	}
-
-	return libs, nil, nil
+	return softerrors
 }
 
-func inSlice(s string, slice []string) bool {
-	for _, s2 := range slice {
-		if s == s2 {
-			return true
-		}
-	}
-
-	return false
+// splitDevice returns the major and minor numbers of a device number, as the kernel encodes them.
+func splitDevice(dev uint64) (major uint32, minor uint32) {
+	major = uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor = uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor
 }
@@ -0,0 +1,229 @@
+package listlibs
+
+import (
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestListLibraries(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	libraries, softerrors, err := ListLibraries(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var libc *Library
+	for i, l := range libraries {
+		if strings.Contains(filepath.Base(l.Path), "libc") && strings.Contains(l.Path, ".so") {
+			libc = &libraries[i]
+		}
+	}
+	if libc == nil {
+		t.Fatal("The test process should have libc loaded, got", libraries)
+	}
+
+	if libc.Deleted || libc.Replaced || libc.Inode == 0 {
+		t.Errorf("Unexpected libc %+v", *libc)
+	}
+	executable := false
+	for _, s := range libc.Segments {
+		if s.Path != libc.Path || s.Inode != libc.Inode || s.Address < libc.Base {
+			t.Errorf("Segment %v doesn't belong to %+v", s, *libc)
+		}
+		executable = executable || s.IsExecutable()
+	}
+	if !executable || libc.Segments[0].Address-uintptr(libc.Segments[0].Offset) != libc.Base {
+		t.Errorf("Unexpected segments %v of libc with base %x", libc.Segments, libc.Base)
+	}
+
+	paths, softerrors, err := ListLoadedLibraries(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != len(libraries) {
+		t.Errorf("ListLoadedLibraries returned %v and ListLibraries %v", paths, libraries)
+	}
+}
+
+// mapFile maps the file in path in the memory of this process.
+func mapFile(t *testing.T, path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, 4096, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestListLibrariesDeletedAndReplaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "masche-listlibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// deleted is removed after being mapped, replaced is replaced by another file as upgrades do, and kept isn't
+	// modified.
+	deleted := filepath.Join(dir, "deleted.so")
+	replaced := filepath.Join(dir, "replaced.so")
+	kept := filepath.Join(dir, "kept.so")
+	for _, path := range []string{deleted, replaced, replaced + ".new", kept} {
+		if err := ioutil.WriteFile(path, make([]byte, 4096), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{deleted, replaced, kept} {
+		data := mapFile(t, path)
+		defer syscall.Munmap(data)
+	}
+
+	if err := os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(replaced+".new", replaced); err != nil {
+		t.Fatal(err)
+	}
+
+	proc, softerrors, err := process.OpenFromPid(uint(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	libraries, softerrors, err := ListLibraries(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]Library)
+	for _, l := range libraries {
+		found[l.Path] = l
+	}
+	if l := found[deleted]; !l.Deleted || !l.Replaced || len(l.Segments) != 1 {
+		t.Errorf("Expected %s to be deleted and replaced, got %+v", deleted, l)
+	}
+	if l := found[replaced]; !l.Deleted || !l.Replaced || len(l.Segments) != 1 {
+		t.Errorf("Expected %s to be deleted and replaced, got %+v", replaced, l)
+	}
+	if l := found[kept]; l.Deleted || l.Replaced || len(l.Segments) != 1 ||
+		l.Segments[0].Permissions&memaccess.PermShared == 0 {
+		t.Errorf("Expected %s to be neither deleted nor replaced, got %+v", kept, l)
+	}
+
+	paths, softerrors, err := ListLoadedLibraries(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := false
+	for _, path := range paths {
+		listed = listed || path == deleted+" (deleted)"
+	}
+	if !listed {
+		t.Errorf("Expected %s (deleted) in %v", deleted, paths)
+	}
+}
+
+func TestStaleLibraries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "masche-stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Only the executable mappings of replaced files make a process stale.
+	code := filepath.Join(dir, "code.so")
+	data := filepath.Join(dir, "data")
+	for _, path := range []string{code, data} {
+		if err := ioutil.WriteFile(path, make([]byte, 4096), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeData, err := syscall.Mmap(int(f.Fd()), 0, 4096, syscall.PROT_READ|syscall.PROT_EXEC, syscall.MAP_PRIVATE)
+	f.Close()
+	if err != nil {
+		t.Skip("Files can't be mapped as executable in the temporary directory:", err)
+	}
+	defer syscall.Munmap(codeData)
+	dataData := mapFile(t, data)
+	defer syscall.Munmap(dataData)
+
+	proc, softerrors, err := process.OpenFromPid(uint(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	stale, softerrors, err := FindStaleProcesses([]process.Process{proc})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range stale {
+		for _, l := range p.Libraries {
+			if l.Path == code || l.Path == data {
+				t.Errorf("%s is not stale yet", l.Path)
+			}
+		}
+	}
+
+	for _, path := range []string{code, data} {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale, softerrors, err = FindStaleProcesses([]process.Process{proc})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].Pid != uint(os.Getpid()) {
+		t.Fatal("Expected this process to be stale, got", stale)
+	}
+	found := false
+	for _, l := range stale[0].Libraries {
+		if l.Path == data {
+			t.Errorf("%s isn't code, so it doesn't make the process stale", data)
+		}
+		found = found || (l.Path == code && l.Deleted && l.Replaced)
+	}
+	if !found {
+		t.Errorf("Expected %s in the stale libraries %v", code, stale[0].Libraries)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package listlibs
+
+import (
+	"github.com/mozilla/masche/process"
+)
+
+// listLibraries lists the libraries of the process, of which only the paths are known in this OS. The executable is
+// never included.
+func listLibraries(p process.Process, withExecutable bool) (libraries []Library, softerrors []error,
+	harderror error) {
+
+	paths, softerrors, harderror := listLoadedLibraries(p)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	for _, path := range paths {
+		libraries = append(libraries, Library{Path: path})
+	}
+	return libraries, softerrors, nil
+}
+
+// checkReplaced does nothing, as the mappings of the processes don't identify the files they map in this OS.
+func checkReplaced(libraries []Library, root string, mapFiles string) (softerrors []error) {
+	return nil
+}
@@ -0,0 +1,66 @@
+package listlibs
+
+import (
+	"github.com/mozilla/masche/process"
+	"strings"
+)
+
+// StaleProcess is a process that has stale libraries mapped, so it must be restarted to use their current version.
+type StaleProcess struct {
+	process.Identity
+
+	// Libraries holds the stale libraries of the process, as returned by StaleLibraries.
+	Libraries []Library
+}
+
+// StaleLibraries returns the libraries of a process, including its executable, whose files were deleted or replaced
+// since they were mapped, as it happens after upgrading them. Only files with executable mappings are taken into
+// account, so data files like locales don't make processes stale, and neither do memfd files, which are always
+// deleted.
+//
+// NOTE: It's only supported on Linux, and for processes opened from dumps.
+func StaleLibraries(p process.Process) (stale []Library, softerrors []error, harderror error) {
+	libraries, softerrors, harderror := ListMappedFiles(p)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	for _, l := range libraries {
+		if (l.Deleted || l.Replaced) && isCode(l) {
+			stale = append(stale, l)
+		}
+	}
+	return stale, softerrors, nil
+}
+
+// isCode returns true if the library has executable mappings, and it isn't a memfd file.
+func isCode(l Library) bool {
+	if strings.HasPrefix(l.Path, "/memfd:") {
+		return false
+	}
+	for _, s := range l.Segments {
+		if s.IsExecutable() {
+			return true
+		}
+	}
+	return false
+}
+
+// FindStaleProcesses returns the processes of ps that have stale libraries, as reported by StaleLibraries. They are
+// checked as process.CheckAll does.
+func FindStaleProcesses(ps []process.Process) (stale []StaleProcess, softerrors []error, harderror error) {
+	var libraries [][]Library
+	found, softerrors := process.CheckAll(ps, func(p process.Process) (bool, []error, error) {
+		l, softerrors, harderror := StaleLibraries(p)
+		if harderror != nil || len(l) == 0 {
+			return false, softerrors, harderror
+		}
+		libraries = append(libraries, l)
+		return true, softerrors, nil
+	})
+
+	for i, id := range found {
+		stale = append(stale, StaleProcess{Identity: id, Libraries: libraries[i]})
+	}
+	return stale, softerrors, nil
+}
@@ -0,0 +1,491 @@
+package memaccess
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"github.com/mozilla/masche/process"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// coreWordSize is the size of the longs and pointers of the core files, which are the same as the native ones.
+const coreWordSize = int(unsafe.Sizeof(uintptr(0)))
+
+// coreChunkSize is the size of the chunks the memory of the process is copied in.
+const coreChunkSize = 1 << 20
+
+// These are the types of the notes of core files that the debug/elf package doesn't define.
+const (
+	ntAuxv elf.NType = 6
+	ntFile elf.NType = 0x46494c45
+)
+
+// pnXNum is the number of program headers in the ELF header of files with extended numbering, in which the actual
+// number is in the sh_info field of the first section header.
+const pnXNum = 0xffff
+
+// coreThread is a thread of a process being dumped.
+type coreThread struct {
+	ptraceThread
+
+	// regs holds the registers of the thread if they could be read.
+	regs *syscall.PtraceRegs
+}
+
+// coreSegment is a program header of a core file.
+type coreSegment struct {
+	typ    elf.ProgType
+	flags  elf.ProgFlag
+	offset uint64
+	vaddr  uint64
+	filesz uint64
+	memsz  uint64
+	align  uint64
+}
+
+func dumpCore(p process.Process, w io.Writer) (softerrors []error, harderror error) {
+	if coreMachine == elf.EM_NONE {
+		return nil, fmt.Errorf("Dumping core files is not supported on %s", runtime.GOARCH)
+	}
+
+	// The threads are stopped while the process is dumped, so its memory and registers are consistent. ptrace(2)
+	// requests must come from the thread that attached, so the goroutine is locked to it until they are detached.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	attached, softerrors, harderror := attachAllThreads(p)
+	defer detachThreads(attached)
+	if harderror != nil {
+		return softerrors, harderror
+	}
+	threads, serrs := threadRegisters(p, attached)
+	softerrors = append(softerrors, serrs...)
+
+	mappings, serrs, harderror := listMappings(p)
+	softerrors = append(softerrors, serrs...)
+	if harderror != nil {
+		return softerrors, harderror
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	notes, serrs := coreNotes(p, threads, mappings, pageSize)
+	softerrors = append(softerrors, serrs...)
+
+	ehsize, phentsize, _ := coreHeaderSizes()
+	headersSize := ehsize + uint64(len(mappings)+1)*phentsize
+	segments := []coreSegment{{typ: elf.PT_NOTE, offset: headersSize, filesz: uint64(len(notes)), align: 4}}
+
+	offset := alignUp(headersSize+uint64(len(notes)), pageSize)
+	dataOffset := offset
+	for _, m := range mappings {
+		s := coreSegment{typ: elf.PT_LOAD, flags: progFlags(m.Permissions), offset: offset, vaddr: uint64(m.Address),
+			memsz: uint64(m.Size), align: pageSize}
+
+		readable, err := isMappingDumpable(p, m)
+		if err == process.ErrProcessGone {
+			return softerrors, err
+		}
+		if err != nil {
+			softerrors = append(softerrors, err)
+		}
+		if readable {
+			s.filesz = s.memsz
+		}
+
+		offset += s.filesz
+		segments = append(segments, s)
+	}
+
+	headers := coreHeaders(segments, offset)
+	headers = append(headers, notes...)
+	headers = append(headers, make([]byte, dataOffset-uint64(len(headers)))...)
+	if _, err := w.Write(headers); err != nil {
+		return softerrors, err
+	}
+
+	buf := make([]byte, coreChunkSize)
+	for i, m := range mappings {
+		if segments[i+1].filesz == 0 {
+			continue
+		}
+
+		serrs, harderror := copySegment(p, m, w, buf)
+		softerrors = append(softerrors, serrs...)
+		if harderror != nil {
+			return softerrors, harderror
+		}
+	}
+
+	// With extended numbering the number of program headers is in the only section header, after the segments.
+	if len(segments) >= pnXNum {
+		if _, err := w.Write(coreSectionHeader(len(segments))); err != nil {
+			return softerrors, err
+		}
+	}
+
+	return softerrors, nil
+}
+
+// threadRegisters reads the registers of the attached threads. The threads whose registers can't be read are returned
+// anyway, with a soft error. The main thread is returned first.
+func threadRegisters(p process.Process, attached []ptraceThread) (threads []coreThread, softerrors []error) {
+	for _, t := range attached {
+		ct := coreThread{ptraceThread: t}
+		if t.attached {
+			var regs syscall.PtraceRegs
+			if err := syscall.PtraceGetRegs(t.tid, &regs); err != nil {
+				softerrors = append(softerrors, fmt.Errorf("Could not read the registers of thread %d: %w", t.tid,
+					err))
+			} else {
+				ct.regs = &regs
+			}
+		}
+		threads = append(threads, ct)
+	}
+
+	// The first NT_PRSTATUS note must be the one of the main thread, which debuggers consider the current thread.
+	pid := int(p.Pid())
+	sort.SliceStable(threads, func(i, j int) bool {
+		return threads[i].tid == pid && threads[j].tid != pid
+	})
+	return threads, softerrors
+}
+
+// unreadablePseudoMappings are the mappings set up by the kernel that can never be read through the memory file, so
+// their segments are always empty. The kernel doesn't dump their contents either.
+var unreadablePseudoMappings = map[string]bool{"[vsyscall]": true, "[vvar]": true, "[vvar_vclock]": true}
+
+// isMappingDumpable returns true if the contents of m can be dumped. If they can't, because it isn't readable or
+// reading it fails, it returns the reason as the error, except for the unreadablePseudoMappings.
+func isMappingDumpable(p process.Process, m Mapping) (bool, error) {
+	if unreadablePseudoMappings[m.Path] {
+		return false, nil
+	}
+	if !m.IsReadable() {
+		return false, &RegionReadError{Addr: m.Address, Len: m.Size, Err: ErrUnreadable}
+	}
+
+	_, err := copyMemory(p, m.Address, make([]byte, 1))
+	return err == nil, err
+}
+
+// copySegment writes the contents of m to w, reading them in chunks the size of buf. The chunks that can't be read are
+// written as zeros, so the offsets of the following segments are kept, and a soft error is returned for the first one.
+func copySegment(p process.Process, m Mapping, w io.Writer, buf []byte) (softerrors []error, harderror error) {
+	reported := false
+	for offset := uint(0); offset < m.Size; {
+		chunk := buf
+		if m.Size-offset < uint(len(chunk)) {
+			chunk = chunk[:m.Size-offset]
+		}
+
+		serrs, err := copyMemory(p, m.Address+uintptr(offset), chunk)
+		softerrors = append(softerrors, serrs...)
+		if err == process.ErrProcessGone {
+			return softerrors, err
+		}
+		if err != nil {
+			if !reported {
+				softerrors = append(softerrors, err)
+				reported = true
+			}
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return softerrors, err
+		}
+		offset += uint(len(chunk))
+	}
+	return softerrors, nil
+}
+
+func progFlags(perms Permissions) (flags elf.ProgFlag) {
+	if perms&PermRead != 0 {
+		flags |= elf.PF_R
+	}
+	if perms&PermWrite != 0 {
+		flags |= elf.PF_W
+	}
+	if perms&PermExecute != 0 {
+		flags |= elf.PF_X
+	}
+	return flags
+}
+
+func alignUp(n uint64, alignment uint64) uint64 {
+	return (n + alignment - 1) / alignment * alignment
+}
+
+// coreHeaderSizes returns the sizes of the ELF header, a program header and a section header of the native class.
+func coreHeaderSizes() (ehsize, phentsize, shentsize uint64) {
+	if coreWordSize == 8 {
+		return 64, 56, 64
+	}
+	return 52, 32, 40
+}
+
+// coreHeaders returns the ELF header followed by the program headers of a core file with the given segments. If there
+// are too many of them to fit in the ELF header, shoff is the offset of the section header that holds their number.
+func coreHeaders(segments []coreSegment, shoff uint64) []byte {
+	ehsize, phentsize, shentsize := coreHeaderSizes()
+
+	var ident [elf.EI_NIDENT]byte
+	copy(ident[:], elf.ELFMAG)
+	ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	if coreWordSize == 8 {
+		ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	}
+	ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	phnum, shnum := len(segments), 0
+	if phnum >= pnXNum {
+		phnum, shnum = pnXNum, 1
+	} else {
+		shoff, shentsize = 0, 0
+	}
+
+	// All the architectures DumpCore supports are little endian.
+	var b bytes.Buffer
+	if coreWordSize == 8 {
+		binary.Write(&b, binary.LittleEndian, elf.Header64{Ident: ident, Type: uint16(elf.ET_CORE),
+			Machine: uint16(coreMachine), Version: uint32(elf.EV_CURRENT), Phoff: ehsize, Shoff: shoff,
+			Ehsize: uint16(ehsize), Phentsize: uint16(phentsize), Phnum: uint16(phnum),
+			Shentsize: uint16(shentsize), Shnum: uint16(shnum)})
+		for _, s := range segments {
+			binary.Write(&b, binary.LittleEndian, elf.Prog64{Type: uint32(s.typ), Flags: uint32(s.flags),
+				Off: s.offset, Vaddr: s.vaddr, Filesz: s.filesz, Memsz: s.memsz, Align: s.align})
+		}
+	} else {
+		binary.Write(&b, binary.LittleEndian, elf.Header32{Ident: ident, Type: uint16(elf.ET_CORE),
+			Machine: uint16(coreMachine), Version: uint32(elf.EV_CURRENT), Phoff: uint32(ehsize),
+			Shoff: uint32(shoff), Ehsize: uint16(ehsize), Phentsize: uint16(phentsize), Phnum: uint16(phnum),
+			Shentsize: uint16(shentsize), Shnum: uint16(shnum)})
+		for _, s := range segments {
+			binary.Write(&b, binary.LittleEndian, elf.Prog32{Type: uint32(s.typ), Flags: uint32(s.flags),
+				Off: uint32(s.offset), Vaddr: uint32(s.vaddr), Filesz: uint32(s.filesz), Memsz: uint32(s.memsz),
+				Align: uint32(s.align)})
+		}
+	}
+	return b.Bytes()
+}
+
+// coreSectionHeader returns the section header that holds the number of program headers when they don't fit in the
+// ELF header.
+func coreSectionHeader(phnum int) []byte {
+	var b bytes.Buffer
+	if coreWordSize == 8 {
+		binary.Write(&b, binary.LittleEndian, elf.Section64{Info: uint32(phnum)})
+	} else {
+		binary.Write(&b, binary.LittleEndian, elf.Section32{Info: uint32(phnum)})
+	}
+	return b.Bytes()
+}
+
+// coreNotes returns the contents of the PT_NOTE segment of a core file: the NT_PRSTATUS notes of every thread, followed
+// by the NT_PRPSINFO, NT_AUXV and NT_FILE notes of the process. Any note that can't be built is left out with a soft
+// error, except the ones of the threads, which have zeroed registers if they couldn't be read.
+func coreNotes(p process.Process, threads []coreThread, mappings []Mapping, pageSize uint64) (notes []byte,
+	softerrors []error) {
+
+	info, softerrors, err := process.GetInfo(p)
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+
+	var b coreBuffer
+	for _, t := range threads {
+		b.note(elf.NT_PRSTATUS, prstatus(t, info))
+	}
+
+	psinfo, err := prpsinfo(p, info)
+	if err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Could not build the NT_PRPSINFO note: %w", err))
+	} else {
+		b.note(elf.NT_PRPSINFO, psinfo)
+	}
+
+	auxv, err := readProcFile(p, "auxv")
+	if err != nil {
+		softerrors = append(softerrors, fmt.Errorf("Could not build the NT_AUXV note: %w", err))
+	} else {
+		b.note(ntAuxv, auxv)
+	}
+
+	b.note(ntFile, fileNote(mappings, pageSize))
+	return b.Bytes(), softerrors
+}
+
+// prstatus returns the contents of the NT_PRSTATUS note of a thread, an elf_prstatus structure. The process group,
+// session and times aren't recorded.
+func prstatus(t coreThread, info process.Info) []byte {
+	var b coreBuffer
+	b.u32(0) // pr_info.si_signo
+	b.u32(0) // pr_info.si_code
+	b.u32(0) // pr_info.si_errno
+	b.u16(0) // pr_cursig
+	b.pad(coreWordSize)
+	b.word(0) // pr_sigpend
+	b.word(0) // pr_sighold
+	b.u32(uint32(t.tid))
+	b.u32(uint32(info.PPid))
+	b.u32(0) // pr_pgrp
+	b.u32(0) // pr_sid
+	for i := 0; i < 8; i++ {
+		b.word(0) // pr_utime, pr_stime, pr_cutime and pr_cstime
+	}
+
+	regs := t.regs
+	if regs == nil {
+		regs = &syscall.PtraceRegs{}
+	}
+	binary.Write(&b, binary.LittleEndian, regs)
+
+	b.u32(0) // pr_fpvalid
+	b.pad(coreWordSize)
+	return b.Bytes()
+}
+
+// prpsinfo returns the contents of the NT_PRPSINFO note of the process, an elf_prpsinfo structure.
+func prpsinfo(p process.Process, info process.Info) ([]byte, error) {
+	comm, err := readProcFile(p, "comm")
+	if err != nil {
+		return nil, err
+	}
+
+	var sname byte
+	if info.State != "" {
+		sname = info.State[0]
+	}
+	state := strings.IndexByte("RSDTZW", sname)
+	if state < 0 {
+		state = 0
+	}
+	zomb := byte(0)
+	if sname == 'Z' {
+		zomb = 1
+	}
+
+	var b coreBuffer
+	b.WriteByte(byte(state))
+	b.WriteByte(sname)
+	b.WriteByte(zomb)
+	b.WriteByte(0) // pr_nice
+	b.pad(coreWordSize)
+	b.word(0) // pr_flag
+	b.uid(info.RealUID)
+	b.uid(info.RealGID)
+	b.u32(uint32(p.Pid()))
+	b.u32(uint32(info.PPid))
+	b.u32(0) // pr_pgrp
+	b.u32(0) // pr_sid
+	b.fixed(strings.TrimSuffix(string(comm), "\n"), 16)
+	b.fixed(strings.Join(info.Argv, " "), 80)
+	b.pad(coreWordSize)
+	return b.Bytes(), nil
+}
+
+// fileNote returns the contents of the NT_FILE note, which lists the file-backed mappings of the process: their
+// number, the page size, the start, end and offset (in pages) of each of them, and then their paths.
+func fileNote(mappings []Mapping, pageSize uint64) []byte {
+	var files []Mapping
+	for _, m := range mappings {
+		if m.Path != "" && !m.Pseudo {
+			files = append(files, m)
+		}
+	}
+
+	var b coreBuffer
+	b.word(uint64(len(files)))
+	b.word(pageSize)
+	for _, m := range files {
+		b.word(uint64(m.Address))
+		b.word(uint64(m.Address) + uint64(m.Size))
+		b.word(m.Offset / pageSize)
+	}
+	for _, m := range files {
+		b.WriteString(m.Path)
+		if m.Deleted {
+			b.WriteString(deletedSuffix)
+		}
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+func readProcFile(p process.Process, name string) ([]byte, error) {
+	f, err := process.OpenProcFile(p, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// coreBuffer builds the notes of a core file, whose structures have the native layout, in little endian.
+type coreBuffer struct {
+	bytes.Buffer
+}
+
+func (b *coreBuffer) u16(v uint16) {
+	binary.Write(b, binary.LittleEndian, v)
+}
+
+func (b *coreBuffer) u32(v uint32) {
+	binary.Write(b, binary.LittleEndian, v)
+}
+
+// word writes a long.
+func (b *coreBuffer) word(v uint64) {
+	if coreWordSize == 8 {
+		binary.Write(b, binary.LittleEndian, v)
+	} else {
+		b.u32(uint32(v))
+	}
+}
+
+// uid writes a user or group id, which are 16 bits long in the notes of some architectures.
+func (b *coreBuffer) uid(v uint) {
+	if coreUIDSize == 2 {
+		b.u16(uint16(v))
+	} else {
+		b.u32(uint32(v))
+	}
+}
+
+// fixed writes s in a NUL-terminated field of the given size, truncating it if needed.
+func (b *coreBuffer) fixed(s string, size int) {
+	field := make([]byte, size)
+	copy(field[:size-1], s)
+	b.Write(field)
+}
+
+// pad writes zeros until the length of the buffer is a multiple of alignment.
+func (b *coreBuffer) pad(alignment int) {
+	for b.Len()%alignment != 0 {
+		b.WriteByte(0)
+	}
+}
+
+// note writes a note with the "CORE" name, which is the one of the notes of core files.
+func (b *coreBuffer) note(typ elf.NType, desc []byte) {
+	const name = "CORE\x00"
+	b.u32(uint32(len(name)))
+	b.u32(uint32(len(desc)))
+	b.u32(uint32(typ))
+	b.WriteString(name)
+	b.pad(4)
+	b.Write(desc)
+	b.pad(4)
+}
@@ -0,0 +1,138 @@
+package memaccess
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDumpCore(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	var core bytes.Buffer
+	softerrors, err = DumpCore(proc, &core)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(core.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != elf.ET_CORE || f.Machine != coreMachine {
+		t.Fatalf("Unexpected type %v or machine %v", f.Type, f.Machine)
+	}
+
+	mappings, softerrors, err := ListMappings(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Progs) != len(mappings)+1 || f.Progs[0].Type != elf.PT_NOTE {
+		t.Fatalf("Expected a PT_NOTE and %d PT_LOAD segments and got %d segments", len(mappings), len(f.Progs))
+	}
+
+	found := false
+	for i, m := range mappings {
+		prog := f.Progs[i+1]
+		if prog.Type != elf.PT_LOAD || prog.Vaddr != uint64(m.Address) || prog.Memsz != uint64(m.Size) {
+			t.Errorf("Segment %+v doesn't correspond to %v", prog.ProgHeader, m)
+		}
+		if (prog.Flags&elf.PF_W != 0) != m.IsWritable() {
+			t.Errorf("Segment %+v has different permissions than %v", prog.ProgHeader, m)
+		}
+
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("Un dia vi una vaca vestida de uniforme")) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("The string of the test process wasn't found in the core file")
+	}
+
+	notes := parseNotes(t, f.Progs[0])
+	if len(notes[elf.NT_PRSTATUS]) == 0 || len(notes[elf.NT_PRPSINFO]) != 1 || len(notes[ntAuxv]) != 1 ||
+		len(notes[ntFile]) != 1 {
+		t.Fatalf("Missing notes, got %d NT_PRSTATUS, %d NT_PRPSINFO, %d NT_AUXV and %d NT_FILE",
+			len(notes[elf.NT_PRSTATUS]), len(notes[elf.NT_PRPSINFO]), len(notes[ntAuxv]), len(notes[ntFile]))
+	}
+
+	// The pid of the first thread is right after the signal information and masks, and the registers follow the ids
+	// and times.
+	status := notes[elf.NT_PRSTATUS][0]
+	pidOffset := 16 + 2*coreWordSize
+	if pid := binary.LittleEndian.Uint32(status[pidOffset:]); pid != uint32(cmd.Process.Pid) {
+		t.Errorf("Expected the main thread %d in the first NT_PRSTATUS note and got %d", cmd.Process.Pid, pid)
+	}
+	regs := status[pidOffset+16+8*coreWordSize:]
+	if bytes.Count(regs, []byte{0}) == len(regs) {
+		t.Error("The registers of the main thread are zeroed")
+	}
+
+	name, softerrors, err := proc.Name()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(notes[ntFile][0]), name+"\x00") {
+		t.Errorf("The NT_FILE note doesn't include the binary of the test process %s", name)
+	}
+
+	// The process keeps running after being dumped, so it can be read again.
+	info, softerrors, err := process.GetInfo(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(info.State, "T") || strings.HasPrefix(info.State, "t") {
+		t.Error("The test process is still stopped after being dumped:", info.State)
+	}
+}
+
+// parseNotes returns the descriptors of the notes of a PT_NOTE segment by their type.
+func parseNotes(t *testing.T, prog *elf.Prog) map[elf.NType][][]byte {
+	data, err := ioutil.ReadAll(prog.Open())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	align := func(n uint32) uint32 {
+		return (n + 3) &^ 3
+	}
+
+	notes := make(map[elf.NType][][]byte)
+	for len(data) >= 12 {
+		namesz := binary.LittleEndian.Uint32(data[0:])
+		descsz := binary.LittleEndian.Uint32(data[4:])
+		typ := elf.NType(binary.LittleEndian.Uint32(data[8:]))
+
+		descStart := 12 + align(namesz)
+		if string(data[12:12+namesz]) != "CORE\x00" || uint32(len(data)) < descStart+descsz {
+			t.Fatalf("Malformed note of type %v", typ)
+		}
+		notes[typ] = append(notes[typ], data[descStart:descStart+descsz])
+		data = data[descStart+align(descsz):]
+	}
+	return notes
+}
@@ -0,0 +1,82 @@
+package memaccess
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnreadable is the error of the *RegionReadError reported for memory regions that don't have read permissions.
+var ErrUnreadable = errors.New("Memory is not readable")
+
+// ErrUnmapped is matched by the errors returned when reading memory that isn't mapped, which can happen if the process
+// unmaps it while it's being read.
+var ErrUnmapped = errors.New("Memory is not mapped")
+
+// ErrNotWritable is the error of the *RegionWriteError reported when writing memory that doesn't have write
+// permissions.
+var ErrNotWritable = errors.New("Memory is not writable")
+
+// ErrNotOpenedForWriting is returned when writing the memory of a process that wasn't opened with
+// process.OpenFromPidForWriting.
+var ErrNotOpenedForWriting = errors.New("Process was not opened for writing")
+
+// RegionReadError is returned when a range of the memory of a process can't be read. Err is the underlying error,
+// usually a syscall.Errno.
+type RegionReadError struct {
+	Addr uintptr
+	Len  uint
+	Err  error
+}
+
+func (err *RegionReadError) Error() string {
+	return fmt.Sprintf("Error while reading %d bytes starting at %x: %v", err.Len, err.Addr, err.Err)
+}
+
+func (err *RegionReadError) Unwrap() error {
+	return err.Err
+}
+
+// Is makes errors.Is(err, ErrUnmapped) true if the underlying error means that the memory wasn't mapped.
+func (err *RegionReadError) Is(target error) bool {
+	// This function is implemented by the OS-specific isUnmappedError function.
+	return target == ErrUnmapped && isUnmappedError(err.Err)
+}
+
+// RegionWriteError is returned when a range of the memory of a process can't be written. Err is the underlying error,
+// ErrNotWritable, ErrUnmapped or a syscall.Errno.
+type RegionWriteError struct {
+	Addr uintptr
+	Len  uint
+	Err  error
+}
+
+func (err *RegionWriteError) Error() string {
+	return fmt.Sprintf("Error while writing %d bytes starting at %x: %v", err.Len, err.Addr, err.Err)
+}
+
+func (err *RegionWriteError) Unwrap() error {
+	return err.Err
+}
+
+// Is makes errors.Is(err, ErrUnmapped) true if the underlying error means that the memory wasn't mapped.
+func (err *RegionWriteError) Is(target error) bool {
+	return target == ErrUnmapped && isUnmappedError(err.Err)
+}
+
+// MapsParseError is returned when a line describing the memory mappings of a process can't be parsed. Err is the
+// reason, if there's a more specific one than the line being malformed.
+type MapsParseError struct {
+	Line string
+	Err  error
+}
+
+func (err *MapsParseError) Error() string {
+	if err.Err != nil {
+		return fmt.Sprintf("Unrecognised maps line: %s: %v", err.Line, err.Err)
+	}
+	return fmt.Sprintf("Unrecognised maps line: %s", err.Line)
+}
+
+func (err *MapsParseError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,64 @@
+package memaccess
+
+import (
+	"regexp"
+	"strings"
+)
+
+// RegionFilter type represents a predicate over the mappings of a process. The *Filtered functions only walk the
+// memory of the mappings for which it returns true.
+type RegionFilter func(m Mapping) bool
+
+// WritableRegions is a RegionFilter that accepts only writable mappings.
+func WritableRegions(m Mapping) bool {
+	return m.IsWritable()
+}
+
+// ExecutableRegions is a RegionFilter that accepts only executable mappings.
+func ExecutableRegions(m Mapping) bool {
+	return m.IsExecutable()
+}
+
+// AnonymousRegions is a RegionFilter that accepts only mappings not backed by a file.
+func AnonymousRegions(m Mapping) bool {
+	return m.IsAnonymous()
+}
+
+// PrivateRegions is a RegionFilter that accepts only private (copy-on-write) mappings.
+func PrivateRegions(m Mapping) bool {
+	return m.Permissions&PermPrivate != 0
+}
+
+// HeapAndStackRegions is a RegionFilter that accepts only the heap and the stacks of a process.
+func HeapAndStackRegions(m Mapping) bool {
+	return m.Path == "[heap]" || m.Path == "[stack]" || strings.HasPrefix(m.Path, "[stack:")
+}
+
+// RegionsWithPath returns a RegionFilter that accepts only the mappings whose path matches r.
+func RegionsWithPath(r *regexp.Regexp) RegionFilter {
+	return func(m Mapping) bool {
+		return r.MatchString(m.Path)
+	}
+}
+
+// RegionsInRange returns a RegionFilter that accepts only the mappings that overlap with [start, end).
+//
+// NOTE: Mappings are accepted or rejected as a whole, so the bytes of a mapping that lie outside the range are walked
+// too if the mapping overlaps with it.
+func RegionsInRange(start uintptr, end uintptr) RegionFilter {
+	return func(m Mapping) bool {
+		return m.Address < end && m.Address+uintptr(m.Size) > start
+	}
+}
+
+// AllFilters returns a RegionFilter that accepts only the mappings accepted by every one of filters.
+func AllFilters(filters ...RegionFilter) RegionFilter {
+	return func(m Mapping) bool {
+		for _, filter := range filters {
+			if !filter(m) {
+				return false
+			}
+		}
+		return true
+	}
+}
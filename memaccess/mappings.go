@@ -0,0 +1,275 @@
+package memaccess
+
+import (
+	"fmt"
+	"github.com/mozilla/masche/common"
+	"github.com/mozilla/masche/process"
+	"strconv"
+	"strings"
+)
+
+// Permissions represents the access permissions of a memory mapping, as a set of flags.
+type Permissions uint8
+
+// These are the flags that a Permissions value can have.
+const (
+	PermRead Permissions = 1 << iota
+	PermWrite
+	PermExecute
+	PermShared
+	PermPrivate
+)
+
+// String returns the permissions in the same format used in /proc/<pid>/maps, e.g. "r-xp".
+func (perms Permissions) String() string {
+	str := []byte("----")
+	if perms&PermRead != 0 {
+		str[0] = 'r'
+	}
+	if perms&PermWrite != 0 {
+		str[1] = 'w'
+	}
+	if perms&PermExecute != 0 {
+		str[2] = 'x'
+	}
+	if perms&PermShared != 0 {
+		str[3] = 's'
+	} else if perms&PermPrivate != 0 {
+		str[3] = 'p'
+	}
+	return string(str)
+}
+
+// Mapping represents a single memory mapping of a process, as the OS reports it.
+//
+// NOTE: Unlike MemoryRegion, contiguous mappings are not merged.
+type Mapping struct {
+	Address     uintptr
+	Size        uint
+	Permissions Permissions
+
+	// Offset is the offset of the mapping in the backing file.
+	Offset uint64
+
+	// DevMajor and DevMinor identify the device containing the backing file, and Inode the backing file in it. They
+	// are all zero for anonymous mappings.
+	DevMajor uint32
+	DevMinor uint32
+	Inode    uint64
+
+	// Path is the path of the backing file, a pseudo-path like "[heap]" or "[stack]", or empty for anonymous memory.
+	// If the backing file was deleted the " (deleted)" suffix the OS adds to it is removed, and Deleted is set.
+	Path    string
+	Pseudo  bool
+	Deleted bool
+
+	// IdentityUnknown is set for the mappings of files that are only known by their path, like those of core files,
+	// whose DevMajor, DevMinor and Inode are zero even if they aren't anonymous.
+	IdentityUnknown bool
+}
+
+// Region returns the memory region spanned by the mapping.
+func (m Mapping) Region() MemoryRegion {
+	return MemoryRegion{Address: m.Address, Size: m.Size}
+}
+
+// Contains returns true if address is inside the mapping.
+func (m Mapping) Contains(address uintptr) bool {
+	return address >= m.Address && address-m.Address < uintptr(m.Size)
+}
+
+// IsReadable returns true if the mapping can be read.
+func (m Mapping) IsReadable() bool {
+	return m.Permissions&PermRead != 0
+}
+
+// IsWritable returns true if the mapping can be written.
+func (m Mapping) IsWritable() bool {
+	return m.Permissions&PermWrite != 0
+}
+
+// IsExecutable returns true if the mapping can be executed.
+func (m Mapping) IsExecutable() bool {
+	return m.Permissions&PermExecute != 0
+}
+
+// IsShared returns true if the mapping is shared with other processes.
+func (m Mapping) IsShared() bool {
+	return m.Permissions&PermShared != 0
+}
+
+// IsAnonymous returns true if the mapping isn't backed by a file. Note that pseudo-paths like "[heap]" are anonymous.
+func (m Mapping) IsAnonymous() bool {
+	return m.Inode == 0 && !m.IdentityUnknown
+}
+
+func (m Mapping) String() string {
+	path := m.Path
+	if m.Deleted {
+		path += " (deleted)"
+	}
+	return fmt.Sprintf("Mapping[%x-%x) %s %08x %02x:%02x %d %s", m.Address, m.Address+uintptr(m.Size),
+		m.Permissions, m.Offset, m.DevMajor, m.DevMinor, m.Inode, path)
+}
+
+// ListMappings returns every memory mapping of a process, sorted by address.
+func ListMappings(p process.Process) (mappings []Mapping, softerrors []error, harderror error) {
+	if s, ok := p.(MemorySource); ok {
+		return s.Mappings()
+	}
+	return listMappings(p)
+}
+
+// ParseMapping parses a line of a /proc/<pid>/maps file of Linux, returning a *MapsParseError if it's malformed. It
+// can be used in any OS, e.g. to analyze the mappings of a process that were saved with its memory.
+func ParseMapping(line string) (m Mapping, err error) {
+	return parseMapping(line)
+}
+
+// parseMapping parses a line of a /proc/<pid>/maps file.
+func parseMapping(line string) (m Mapping, err error) {
+	items := common.SplitMapsFileEntry(line)
+	if len(items) != 6 || len(items[1]) != 4 {
+		return m, &MapsParseError{Line: line}
+	}
+
+	start, end, err := common.ParseMapsFileMemoryLimits(items[0])
+	if err != nil {
+		return m, &MapsParseError{Line: line, Err: err}
+	}
+	m.Address = start
+	m.Size = uint(end - start)
+
+	for i, flag := range []Permissions{PermRead, PermWrite, PermExecute} {
+		if items[1][i] != '-' {
+			m.Permissions |= flag
+		}
+	}
+	switch items[1][3] {
+	case 's':
+		m.Permissions |= PermShared
+	case 'p':
+		m.Permissions |= PermPrivate
+	}
+
+	if m.Offset, err = strconv.ParseUint(items[2], 16, 64); err != nil {
+		return m, &MapsParseError{Line: line, Err: fmt.Errorf("Invalid offset: %w", err)}
+	}
+
+	dev := strings.Split(items[3], ":")
+	if len(dev) != 2 {
+		return m, &MapsParseError{Line: line, Err: fmt.Errorf("Invalid device %s", items[3])}
+	}
+	major, err := strconv.ParseUint(dev[0], 16, 32)
+	if err != nil {
+		return m, &MapsParseError{Line: line, Err: fmt.Errorf("Invalid device: %w", err)}
+	}
+	minor, err := strconv.ParseUint(dev[1], 16, 32)
+	if err != nil {
+		return m, &MapsParseError{Line: line, Err: fmt.Errorf("Invalid device: %w", err)}
+	}
+	m.DevMajor = uint32(major)
+	m.DevMinor = uint32(minor)
+
+	if m.Inode, err = strconv.ParseUint(items[4], 10, 64); err != nil {
+		return m, &MapsParseError{Line: line, Err: fmt.Errorf("Invalid inode: %w", err)}
+	}
+
+	m.Path = items[5]
+	if strings.HasSuffix(m.Path, deletedSuffix) {
+		m.Path = strings.TrimSuffix(m.Path, deletedSuffix)
+		m.Deleted = true
+	}
+	m.Pseudo = strings.HasPrefix(m.Path, "[") && strings.HasSuffix(m.Path, "]")
+
+	return m, nil
+}
+
+// deletedSuffix is appended by the kernel to the path of mappings whose backing file has been deleted.
+const deletedSuffix = " (deleted)"
+
+// checkWritableRange returns a *RegionWriteError if the size bytes starting at address aren't entirely covered by
+// mappings, or if any of them isn't writable and force is false.
+func checkWritableRange(mappings []Mapping, address uintptr, size uint, force bool) error {
+	end := address + uintptr(size)
+	next := address
+	for _, m := range mappings {
+		if next >= end {
+			break
+		}
+		if !m.Contains(next) {
+			continue
+		}
+
+		if !force && !m.IsWritable() {
+			return &RegionWriteError{Addr: address, Len: size, Err: ErrNotWritable}
+		}
+		next = m.Address + uintptr(m.Size)
+	}
+
+	if next < end {
+		return &RegionWriteError{Addr: address, Len: size, Err: ErrUnmapped}
+	}
+	return nil
+}
+
+// nextRegionFromMappings returns the first readable memory region containing address, or after it, that can be built
+// by merging contiguous mappings accepted by filter. A nil filter accepts every mapping.
+func nextRegionFromMappings(mappings []Mapping, address uintptr, filter RegionFilter) (region MemoryRegion,
+	softerrors []error) {
+
+	region = MemoryRegion{}
+	for _, m := range mappings {
+		if m.Address+uintptr(m.Size) <= address {
+			continue
+		}
+
+		// Skip vsyscall as it can't be read. It's a special page mapped by the kernel to accelerate some syscalls.
+		if m.Path == "[vsyscall]" {
+			continue
+		}
+
+		// Mappings rejected by the filter finish the current region, as unreadable ones do, but they aren't reported.
+		if filter != nil && !filter(m) {
+			if region.Address != 0 {
+				return region, softerrors
+			}
+			continue
+		}
+
+		// Check if memory is unreadable
+		if !m.IsReadable() {
+
+			// If we were already reading a region this will just finish it. We only report the softerror when we
+			// were actually trying to read it.
+			if region.Address != 0 {
+				return region, softerrors
+			}
+
+			softerrors = append(softerrors, &RegionReadError{Addr: m.Address, Len: m.Size, Err: ErrUnreadable})
+			continue
+		}
+
+		// Begenning of a region
+		if region.Address == 0 {
+			region = m.Region()
+			continue
+		}
+
+		// Continuation of a region
+		if region.Address+uintptr(region.Size) == m.Address {
+			region.Size += m.Size
+			continue
+		}
+
+		// This map is outside the current region, so we are ready
+		return region, softerrors
+	}
+
+	// The last map was a valid region, so it was not closed by an invalid/non-contiguous one and we have to return it
+	if region.Address > 0 {
+		return region, softerrors
+	}
+
+	return NoRegionAvailable, softerrors
+}
@@ -2,8 +2,10 @@
 package memaccess
 
 import (
+	"context"
 	"fmt"
 	"github.com/mozilla/masche/process"
+	"io"
 )
 
 // MemoryRegion represents a region of readable contiguos memory of a process.
@@ -27,16 +29,105 @@ var NoRegionAvailable MemoryRegion
 // is returned.
 func NextReadableMemoryRegion(p process.Process, address uintptr) (region MemoryRegion, softerrors []error,
 	harderror error) {
+
+	if s, ok := p.(MemorySource); ok {
+		return sourceNextReadableMemoryRegion(s, address)
+	}
 	return nextReadableMemoryRegion(p, address)
 }
 
+// NextFilteredMemoryRegion works as NextReadableMemoryRegion, but it only takes into account the mappings accepted by
+// filter: the region returned is built only from them. If filter is nil it's equivalent to NextReadableMemoryRegion.
+func NextFilteredMemoryRegion(p process.Process, address uintptr, filter RegionFilter) (region MemoryRegion,
+	softerrors []error, harderror error) {
+
+	if filter == nil {
+		return NextReadableMemoryRegion(p, address)
+	}
+
+	mappings, softerrors, harderror := ListMappings(p)
+	if harderror != nil {
+		return NoRegionAvailable, softerrors, harderror
+	}
+
+	region, serrs := nextRegionFromMappings(mappings, address, filter)
+	return region, append(softerrors, serrs...), nil
+}
+
 // CopyMemory fills the entire buffer with memory from the process starting in address (in the process address space).
 // If there is not enough memory to read it returns a hard error. Note that this is not the only hard error it may
 // return though.
 func CopyMemory(p process.Process, address uintptr, buffer []byte) (softerrors []error, harderror error) {
+	if s, ok := p.(MemorySource); ok {
+		return nil, s.ReadMemory(address, buffer)
+	}
 	return copyMemory(p, address, buffer)
 }
 
+// CopyMemoryRanges fills each of the buffers with memory from the process starting in the corresponding address of
+// addresses, so it must have one address per buffer. It works as calling CopyMemory with each of them, returning a hard
+// error as soon as one of them cannot be entirely read, but it may be implemented more efficiently: on Linux it reads
+// all the ranges with as few syscalls as possible.
+func CopyMemoryRanges(p process.Process, addresses []uintptr, buffers [][]byte) (softerrors []error,
+	harderror error) {
+
+	if len(addresses) != len(buffers) {
+		return nil, fmt.Errorf("CopyMemoryRanges needs an address per buffer, got %d addresses and %d buffers",
+			len(addresses), len(buffers))
+	}
+
+	if s, ok := p.(MemorySource); ok {
+		return sourceCopyMemoryRanges(s, addresses, buffers)
+	}
+	return copyMemoryRanges(p, addresses, buffers)
+}
+
+// WriteMemory writes data into the memory of the process starting in address (in the process address space). The
+// process must have been opened with process.OpenFromPidForWriting, or ErrNotOpenedForWriting is returned. That's
+// always the case for MemorySources.
+//
+// The whole range must be mapped with write permissions, otherwise nothing is written and a *RegionWriteError whose
+// error is ErrNotWritable or ErrUnmapped is returned. Note that the mappings can change between checking them and
+// writing, in which case the write may fail after writing part of data.
+//
+// NOTE: It's only supported on Linux.
+func WriteMemory(p process.Process, address uintptr, data []byte) (softerrors []error, harderror error) {
+	if _, ok := p.(MemorySource); ok {
+		return nil, ErrNotOpenedForWriting
+	}
+	// This function is implemented by the OS-specific writeMemory function.
+	return writeMemory(p, address, data, false)
+}
+
+// ForceWriteMemory works as WriteMemory, but it also writes memory that is mapped without write permissions, such as
+// the code of the process. On Linux it attaches to every thread of the process with ptrace(2), so they are all stopped
+// while it's written, and it fails if any of them can't be attached (e.g. because a debugger is already attached).
+func ForceWriteMemory(p process.Process, address uintptr, data []byte) (softerrors []error, harderror error) {
+	if _, ok := p.(MemorySource); ok {
+		return nil, ErrNotOpenedForWriting
+	}
+	return writeMemory(p, address, data, true)
+}
+
+// DumpCore writes an ELF core file with the memory of the process to w, which can be opened later with gdb or other
+// tools that read core files. Every mapping is a PT_LOAD segment, and the notes hold the registers of each thread
+// (NT_PRSTATUS), information about the process (NT_PRPSINFO), its auxiliary vector (NT_AUXV) and its file-backed
+// mappings (NT_FILE).
+//
+// The process is stopped while it's dumped. Reading the registers requires attaching to its threads with ptrace(2), so
+// if it isn't permitted they are left zeroed and a soft error is returned. The segments of mappings that can't be read
+// are empty, with a soft error for each of them, and any chunk of memory that can't be read once the dump has started
+// is written as zeros.
+//
+// NOTE: It's only supported on Linux, and only for running processes, not for MemorySources.
+func DumpCore(p process.Process, w io.Writer) (softerrors []error, harderror error) {
+	if _, ok := p.(MemorySource); ok {
+		return nil, fmt.Errorf("Only running processes can be dumped")
+	}
+	// This function is implemented by the OS-specific dumpCore function.
+	return dumpCore(p, w)
+}
+
 // WalkFunc type represents a function used for walking through the memory, see WalkMemory for more details.
 type WalkFunc func(address uintptr, buf []byte) (keepSearching bool)
 
@@ -46,9 +137,16 @@ type WalkFunc func(address uintptr, buf []byte) (keepSearching bool)
 //
 // NOTE: It can call to walkFn with a smaller buffer when reading the last part of a memory region.
 func WalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn WalkFunc) (softerrors []error, harderror error) {
+	return WalkMemoryFiltered(p, startAddress, bufSize, nil, walkFn)
+}
+
+// WalkMemoryFiltered works as WalkMemory, but it only reads the memory of the mappings accepted by filter. If filter
+// is nil every readable mapping is read.
+func WalkMemoryFiltered(p process.Process, startAddress uintptr, bufSize uint, filter RegionFilter,
+	walkFn WalkFunc) (softerrors []error, harderror error) {
 
 	var region MemoryRegion
-	region, softerrors, harderror = NextReadableMemoryRegion(p, startAddress)
+	region, softerrors, harderror = NextFilteredMemoryRegion(p, startAddress, filter)
 	if harderror != nil {
 		return
 	}
@@ -78,7 +176,7 @@ func WalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn Wa
 		if err != nil && retries > 0 {
 			// An error occurred: retry using the nearest region to the address that failed.
 			retries--
-			region, serrs, harderror = NextReadableMemoryRegion(p, addr)
+			region, serrs, harderror = NextFilteredMemoryRegion(p, addr, filter)
 			softerrors = append(softerrors, serrs...)
 			if harderror != nil {
 				return
@@ -92,13 +190,13 @@ func WalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn Wa
 			continue
 		} else if err != nil {
 			// we have exceeded our retries, mark the error as soft error and keep going.
-			softerrors = append(softerrors, fmt.Errorf("Retries exceeded on reading %d bytes starting at %x: %s",
-				len(buf), addr, err.Error()))
+			softerrors = append(softerrors, fmt.Errorf("Retries exceeded on reading %d bytes starting at %x: %w",
+				len(buf), addr, err))
 		} else if !keepWalking {
 			return
 		}
 
-		region, serrs, harderror = NextReadableMemoryRegion(p, region.Address+uintptr(region.Size))
+		region, serrs, harderror = NextFilteredMemoryRegion(p, region.Address+uintptr(region.Size), filter)
 		softerrors = append(softerrors, serrs...)
 		if harderror != nil {
 			return
@@ -116,17 +214,22 @@ func WalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn Wa
 //
 // If any of the calls to walkFn returns false, this function inmediatly returns, with keepWalking set to false and no
 // hard error.
+//
+// The memory is read with CopyMemoryRanges, which on Linux reads it with process_vm_readv(2) instead of through the
+// memory file when it can, as that is faster for big buffers.
 func walkRegion(p process.Process, region MemoryRegion, buf []byte, walkFn WalkFunc) (keepWalking bool,
 	errorAddress uintptr, softerrors []error, harderror error) {
 	softerrors = make([]error, 0)
 	keepWalking = true
+	addresses, buffers := make([]uintptr, 1), make([][]byte, 1)
 	remainingBytes := uintptr(region.Size)
 	for addr := region.Address; remainingBytes > 0; {
 		if remainingBytes < uintptr(len(buf)) {
 			buf = buf[:remainingBytes]
 		}
 
-		serrs, err := CopyMemory(p, addr, buf)
+		addresses[0], buffers[0] = addr, buf
+		serrs, err := CopyMemoryRanges(p, addresses, buffers)
 		softerrors = append(softerrors, serrs...)
 
 		if err != nil {
@@ -153,6 +256,13 @@ func walkRegion(p process.Process, region MemoryRegion, buf []byte, walkFn WalkF
 // NOTE: It doesn't work with odd bufSize.
 func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, walkFn WalkFunc) (
 	softerrors []error, harderror error) {
+	return SlidingWalkMemoryFiltered(p, startAddress, bufSize, nil, walkFn)
+}
+
+// SlidingWalkMemoryFiltered works as SlidingWalkMemory, but it only reads the memory of the mappings accepted by
+// filter. If filter is nil every readable mapping is read.
+func SlidingWalkMemoryFiltered(p process.Process, startAddress uintptr, bufSize uint, filter RegionFilter,
+	walkFn WalkFunc) (softerrors []error, harderror error) {
 
 	if bufSize%2 != 0 {
 		return softerrors, fmt.Errorf("SlidingWalkMemory doesn't support odd bufferSizes")
@@ -162,7 +272,8 @@ func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, wa
 	halfBufferSize := bufSize / 2
 	currentBufferStartsAt := uintptr(0)
 	bufferedBytes := uint(0)
-	softerrors, harderror = WalkMemory(p, startAddress, halfBufferSize,
+	stopped := false
+	softerrors, harderror = WalkMemoryFiltered(p, startAddress, halfBufferSize, filter,
 		func(address uintptr, currentBuffer []byte) (keepSearching bool) {
 
 			fromAnotherRegion := currentBufferStartsAt+uintptr(bufferedBytes) < address && currentBufferStartsAt != 0
@@ -172,6 +283,7 @@ func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, wa
 					// Call walkFn with buffer because if was starting a region and as it's not complete it hasn't been
 					// sent to walkFn
 					if !walkFn(currentBufferStartsAt, buffer[:bufferedBytes]) {
+						stopped = true
 						return false
 					}
 				}
@@ -186,6 +298,7 @@ func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, wa
 				// If the currentBuffer is smaller the region has finished
 				if uint(len(currentBuffer)) != halfBufferSize {
 					if !walkFn(currentBufferStartsAt, buffer[:len(currentBuffer)]) {
+						stopped = true
 						return false
 					}
 				} else {
@@ -196,12 +309,13 @@ func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, wa
 			}
 
 			if bufferedBytes == bufSize {
-				copy(buffer, buffer[:halfBufferSize])
+				copy(buffer, buffer[halfBufferSize:])
 				currentBufferStartsAt += uintptr(halfBufferSize)
 			}
 
 			copy(buffer[halfBufferSize:], currentBuffer)
 			if !walkFn(currentBufferStartsAt, buffer[:halfBufferSize+uint(len(currentBuffer))]) {
+				stopped = true
 				return false
 			}
 
@@ -215,10 +329,67 @@ func SlidingWalkMemory(p process.Process, startAddress uintptr, bufSize uint, wa
 			return true
 		})
 
-	// If we only have half buffer filled we haven't called walkFn yet with it
-	if bufferedBytes == halfBufferSize {
+	// If we only have half buffer filled we haven't called walkFn yet with it, unless the walk was stopped
+	if bufferedBytes == halfBufferSize && !stopped {
 		walkFn(currentBufferStartsAt, buffer[:halfBufferSize])
 	}
 
 	return
 }
+
+// WalkMemoryContext works as WalkMemoryFiltered, but it stops before reading more memory once ctx is done, returning
+// ctx.Err() as the hard error. If filter is nil every readable mapping is read.
+//
+// lastAddress is the address following the last byte passed to walkFn, or startAddress if walkFn wasn't called. It
+// can be used to resume the walk later.
+func WalkMemoryContext(ctx context.Context, p process.Process, startAddress uintptr, bufSize uint,
+	filter RegionFilter, walkFn WalkFunc) (lastAddress uintptr, softerrors []error, harderror error) {
+
+	w := &contextWalk{ctx: ctx, walkFn: walkFn, lastAddress: startAddress}
+	if harderror = ctx.Err(); harderror != nil {
+		return startAddress, nil, harderror
+	}
+
+	softerrors, harderror = WalkMemoryFiltered(p, startAddress, bufSize, filter, w.walk)
+	return w.lastAddress, softerrors, w.err(harderror)
+}
+
+// SlidingWalkMemoryContext works as SlidingWalkMemoryFiltered, but it stops once ctx is done as WalkMemoryContext does.
+func SlidingWalkMemoryContext(ctx context.Context, p process.Process, startAddress uintptr, bufSize uint,
+	filter RegionFilter, walkFn WalkFunc) (lastAddress uintptr, softerrors []error, harderror error) {
+
+	w := &contextWalk{ctx: ctx, walkFn: walkFn, lastAddress: startAddress}
+	if harderror = ctx.Err(); harderror != nil {
+		return startAddress, nil, harderror
+	}
+
+	softerrors, harderror = SlidingWalkMemoryFiltered(p, startAddress, bufSize, filter, w.walk)
+	return w.lastAddress, softerrors, w.err(harderror)
+}
+
+// contextWalk wraps a WalkFunc to stop the walk once its context is done, keeping track of how far it got.
+type contextWalk struct {
+	ctx         context.Context
+	walkFn      WalkFunc
+	lastAddress uintptr
+	cancelled   bool
+}
+
+func (w *contextWalk) walk(address uintptr, buf []byte) (keepSearching bool) {
+	if w.ctx.Err() != nil {
+		w.cancelled = true
+		return false
+	}
+
+	keepSearching = w.walkFn(address, buf)
+	w.lastAddress = address + uintptr(len(buf))
+	return keepSearching
+}
+
+// err returns the hard error of a walk done with w.walk, which is the context's error if it stopped it.
+func (w *contextWalk) err(harderror error) error {
+	if harderror == nil && w.cancelled {
+		return w.ctx.Err()
+	}
+	return harderror
+}
@@ -7,9 +7,9 @@ package memaccess
 import "C"
 
 import (
-	"fmt"
 	"github.com/mozilla/masche/cresponse"
 	"github.com/mozilla/masche/process"
+	"io"
 	"unsafe"
 )
 
@@ -49,13 +49,27 @@ func copyMemory(p process.Process, address uintptr, buffer []byte) (softerrors [
 	C.response_free(resp)
 
 	if harderror != nil {
-		harderror = fmt.Errorf("Error while copying %d bytes starting at %x: %s", n, address, harderror.Error())
+		harderror = &RegionReadError{Addr: address, Len: uint(n), Err: harderror}
 		return
 	}
 
 	if len(buffer) != int(bytesRead) {
-		harderror = fmt.Errorf("Could not copy %d bytes starting at %x, copyed %d", len(buffer), address, bytesRead)
+		harderror = &RegionReadError{Addr: address, Len: uint(len(buffer)), Err: io.ErrUnexpectedEOF}
 	}
 
 	return
 }
+
+func copyMemoryRanges(p process.Process, addresses []uintptr, buffers [][]byte) (softerrors []error,
+	harderror error) {
+
+	for i, buffer := range buffers {
+		serrs, err := copyMemory(p, addresses[i], buffer)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			return softerrors, err
+		}
+	}
+
+	return softerrors, nil
+}
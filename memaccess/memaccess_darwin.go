@@ -0,0 +1,12 @@
+package memaccess
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isUnmappedError returns true if err is the error returned when reading unmapped memory. The Mach API reports it as
+// KERN_INVALID_ADDRESS, which the cresponse package unwraps to EFAULT.
+func isUnmappedError(err error) bool {
+	return errors.Is(err, syscall.EFAULT)
+}
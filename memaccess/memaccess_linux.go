@@ -3,107 +3,373 @@ package memaccess
 import (
 	"bufio"
 	"fmt"
-	"github.com/mozilla/masche/common"
 	"github.com/mozilla/masche/process"
-	"os"
+	"io"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
 )
 
 func nextReadableMemoryRegion(p process.Process, address uintptr) (region MemoryRegion, softerrors []error,
 	harderror error) {
 
-	mapsFile, harderror := os.Open(common.MapsFilePathFromPid(p.Pid()))
+	mappings, softerrors, harderror := listMappings(p)
+	if harderror != nil {
+		return
+	}
+
+	region, serrs := nextRegionFromMappings(mappings, address, nil)
+	return region, append(softerrors, serrs...), nil
+}
+
+func listMappings(p process.Process) (mappings []Mapping, softerrors []error, harderror error) {
+	mapsFile, harderror := process.OpenProcFile(p, "maps")
 	if harderror != nil {
 		return
 	}
 	defer mapsFile.Close()
 
-	region = MemoryRegion{}
 	scanner := bufio.NewScanner(mapsFile)
-
 	for scanner.Scan() {
-		line := scanner.Text()
-		items := common.SplitMapsFileEntry(line)
+		m, err := parseMapping(scanner.Text())
+		if err != nil {
+			return nil, softerrors, err
+		}
+		mappings = append(mappings, m)
+	}
+
+	if harderror = scanner.Err(); harderror != nil {
+		return nil, softerrors, harderror
+	}
 
-		if len(items) != 6 {
-			return region, softerrors, fmt.Errorf("Unrecognised maps line: %s", line)
+	return mappings, softerrors, nil
+}
+
+// memoryFd returns the file descriptor of the memory file of p, which the processes opened by the process package keep
+// open. The memory of other implementations can't be read safely, so process.ErrNotLive is returned for them.
+func memoryFd(p process.Process) (fd int, err error) {
+	if fd, ok := process.MemoryFd(p); ok {
+		return int(fd), nil
+	}
+	return -1, process.ErrNotLive
+}
+
+func copyMemory(p process.Process, address uintptr, buffer []byte) (softerrors []error, harderror error) {
+	fd, err := memoryFd(p)
+	if err != nil {
+		return nil, err
+	}
+
+	for bytesRead := 0; bytesRead < len(buffer); {
+		n, err := syscall.Pread(fd, buffer[bytesRead:], int64(address)+int64(bytesRead))
+		if err == syscall.EINTR {
+			continue
 		}
 
-		start, end, err := common.ParseMapsFileMemoryLimits(items[0])
+		// The memory file can't be read once the process has exited, so in that case we report it instead.
 		if err != nil {
-			return region, softerrors, err
+			if goneErr := process.CheckAlive(p); goneErr != nil {
+				return softerrors, goneErr
+			}
+			return softerrors, &RegionReadError{Addr: address, Len: uint(len(buffer)), Err: err}
 		}
 
-		if end <= address {
-			continue
+		if n == 0 {
+			if goneErr := process.CheckAlive(p); goneErr != nil {
+				return softerrors, goneErr
+			}
+			return softerrors, &RegionReadError{Addr: address, Len: uint(len(buffer)), Err: io.ErrUnexpectedEOF}
+		}
+
+		bytesRead += n
+	}
+
+	return softerrors, nil
+}
+
+func writeMemory(p process.Process, address uintptr, data []byte, force bool) (softerrors []error, harderror error) {
+	if !process.OpenedForWriting(p) {
+		return nil, ErrNotOpenedForWriting
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	mappings, softerrors, harderror := listMappings(p)
+	if harderror != nil {
+		return softerrors, harderror
+	}
+	if err := checkWritableRange(mappings, address, uint(len(data)), force); err != nil {
+		return softerrors, err
+	}
+
+	var err error
+	if force {
+		err = ptraceWrite(p, address, data)
+	} else {
+		// Processes opened for writing are always opened by the process package, so they keep their memory file.
+		fd, _ := process.MemoryFd(p)
+		err = pwriteAll(int(fd), address, data)
+	}
+
+	if err != nil {
+		if goneErr := process.CheckAlive(p); goneErr != nil {
+			return softerrors, goneErr
 		}
+		return softerrors, &RegionWriteError{Addr: address, Len: uint(len(data)), Err: err}
+	}
+	return softerrors, nil
+}
 
-		// Skip vsyscall as it can't be read. It's a special page mapped by the kernel to accelerate some syscalls.
-		if items[5] == "[vsyscall]" {
+// pwriteAll writes data at address through fd, which is the memory file of a process opened for writing.
+func pwriteAll(fd int, address uintptr, data []byte) error {
+	for written := 0; written < len(data); {
+		n, err := syscall.Pwrite(fd, data[written:], int64(address)+int64(written))
+		if err == syscall.EINTR {
 			continue
 		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		written += n
+	}
+	return nil
+}
+
+// ptraceWrite writes data at address with PTRACE_POKEDATA, which ignores the permissions of the memory. Every thread
+// of the process is attached and stopped while it's written, and the write fails if any of them can't be. ptrace(2)
+// requests must come from the thread that attached, so the goroutine is locked to it until the process is detached.
+func ptraceWrite(p process.Process, address uintptr, data []byte) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	threads, softerrors, err := attachAllThreads(p)
+	defer detachThreads(threads)
+	if err != nil {
+		return err
+	}
+	if len(softerrors) > 0 {
+		return softerrors[0]
+	}
+
+	_, err = syscall.PtracePokeData(int(p.Pid()), address, data)
+	return err
+}
 
-		// Check if memory is unreadable
-		if items[1][0] == '-' {
+// ptraceThread is a thread of a process that attachAllThreads tried to attach to.
+type ptraceThread struct {
+	tid int
 
-			// If we were already reading a region this will just finish it. We only report the softerror when we
-			// were actually trying to read it.
-			if region.Address != 0 {
-				return region, softerrors, nil
+	// attached is true if the thread was attached with ptrace(2).
+	attached bool
+}
+
+// attachAllThreads attaches to every thread of the process. The threads are listed until no new ones appear, so the
+// ones created while attaching are stopped too. The threads that can't be attached are returned anyway, with a soft
+// error.
+//
+// The calling goroutine must be locked to its thread, and the threads must be detached with detachThreads, even if a
+// hard error is returned.
+func attachAllThreads(p process.Process) (threads []ptraceThread, softerrors []error, harderror error) {
+	seen := make(map[int]bool)
+	for {
+		tids, err := listThreads(p)
+		if err != nil {
+			return threads, softerrors, err
+		}
+
+		added := false
+		for _, tid := range tids {
+			if seen[tid] {
+				continue
 			}
+			seen[tid] = true
+			added = true
 
-			softerrors = append(softerrors, fmt.Errorf("Unreadable memory %s", items[0]))
-			continue
+			t := ptraceThread{tid: tid}
+			if err := ptraceAttach(tid); err != nil {
+				softerrors = append(softerrors, fmt.Errorf("Could not attach to thread %d: %w", tid, err))
+			} else {
+				t.attached = true
+			}
+			threads = append(threads, t)
 		}
 
-		size := uint(end - start)
+		if !added {
+			break
+		}
+	}
 
-		// Begenning of a region
-		if region.Address == 0 {
-			region = MemoryRegion{Address: start, Size: size}
-			continue
+	// PTRACE_ATTACH takes thread ids, which could have been reused if the process exited.
+	if err := process.CheckAlive(p); err != nil {
+		return threads, softerrors, err
+	}
+	return threads, softerrors, nil
+}
+
+// listThreads returns the ids of the threads of the process.
+func listThreads(p process.Process) (tids []int, err error) {
+	dir, err := process.OpenProcFile(p, "task")
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if tid, err := strconv.Atoi(name); err == nil {
+			tids = append(tids, tid)
 		}
+	}
+	return tids, nil
+}
 
-		// Continuation of a region
-		if region.Address+uintptr(region.Size) == start {
-			region.Size += size
-			continue
+func detachThreads(threads []ptraceThread) {
+	for _, t := range threads {
+		if t.attached {
+			syscall.PtraceDetach(t.tid)
 		}
+	}
+}
 
-		// This map is outside the current region, so we are ready
-		return region, softerrors, nil
+// ptraceAttach attaches to the thread tid with PTRACE_ATTACH and waits until it's stopped. The calling goroutine must be
+// locked to its thread, and the thread must be detached with syscall.PtraceDetach once it's not needed.
+func ptraceAttach(tid int) error {
+	if err := syscall.PtraceAttach(tid); err != nil {
+		return err
+	}
+	if err := waitForAttachStop(tid); err != nil {
+		syscall.PtraceDetach(tid)
+		return err
 	}
+	return nil
+}
+
+// waitForAttachStop waits until the process, which has just been attached, stops with the SIGSTOP sent by
+// PTRACE_ATTACH. Any other signal it stops with before that one is delivered to it.
+func waitForAttachStop(pid int) error {
+	for {
+		var status syscall.WaitStatus
+		_, err := syscall.Wait4(pid, &status, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-	// No region left
-	if err := scanner.Err(); err != nil {
-		return NoRegionAvailable, softerrors, err
+		if !status.Stopped() {
+			return syscall.ESRCH
+		}
+		if status.StopSignal() == syscall.SIGSTOP {
+			return nil
+		}
+		if err := syscall.PtraceCont(pid, int(status.StopSignal())); err != nil {
+			return err
+		}
 	}
+}
 
-	// The last map was a valid region, so it was not closed by an invalid/non-contiguous one and we have to return it
-	if region.Address > 0 {
-		return region, softerrors, harderror
+// isUnmappedError returns true if err is the error returned when reading unmapped memory: EIO from the memory file and
+// EFAULT from process_vm_readv(2).
+func isUnmappedError(err error) bool {
+	return err == syscall.EIO || err == syscall.EFAULT
+}
+
+// iovMax is the maximum number of iovecs that can be passed to a single process_vm_readv(2) call.
+const iovMax = 1024
+
+// processVMReadvUnavailable is set when the kernel doesn't support process_vm_readv(2).
+var processVMReadvUnavailable int32
+
+func copyMemoryRanges(p process.Process, addresses []uintptr, buffers [][]byte) (softerrors []error,
+	harderror error) {
+
+	for start := 0; start < len(buffers); start += iovMax {
+		end := start + iovMax
+		if end > len(buffers) {
+			end = len(buffers)
+		}
+
+		bytesRead := 0
+		if atomic.LoadInt32(&processVMReadvUnavailable) == 0 {
+			var err error
+			bytesRead, err = processVMReadv(p.Pid(), addresses[start:end], buffers[start:end])
+			if err == syscall.ENOSYS {
+				atomic.StoreInt32(&processVMReadvUnavailable, 1)
+			}
+
+			// process_vm_readv(2) takes a pid, which could have been reused by another process. If the process we
+			// opened is still alive after reading it wasn't, and we have read the right memory.
+			if goneErr := process.CheckAlive(p); goneErr != nil {
+				return softerrors, goneErr
+			}
+		}
+
+		// If process_vm_readv(2) is not available or it couldn't read every range we read the remaining ones with
+		// copyMemory, which also reports precisely which one failed.
+		for i := start; i < end; i++ {
+			if bytesRead >= len(buffers[i]) {
+				bytesRead -= len(buffers[i])
+				continue
+			}
+
+			serrs, err := copyMemory(p, addresses[i]+uintptr(bytesRead), buffers[i][bytesRead:])
+			softerrors = append(softerrors, serrs...)
+			if err != nil {
+				return softerrors, err
+			}
+			bytesRead = 0
+		}
 	}
 
-	return NoRegionAvailable, softerrors, nil
+	return softerrors, nil
 }
 
-func copyMemory(p process.Process, address uintptr, buffer []byte) (softerrors []error, harderror error) {
-	mem, harderror := os.Open(common.MemFilePathFromPid(p.Pid()))
+// remoteIovec has the same layout of an iovec, but it holds an address of another process. It can't be represented
+// with a syscall.Iovec because Go doesn't allow us to have pointers to memory that is not mapped in this process.
+type remoteIovec struct {
+	base   uintptr
+	length uintptr
+}
 
-	if harderror != nil {
-		harderror := fmt.Errorf("Error while reading %d bytes starting at %x: %s", len(buffer), address, harderror)
-		return softerrors, harderror
+// processVMReadv reads the memory of the process into buffers, starting at the corresponding address of addresses,
+// with a single process_vm_readv(2) call. Its syscall number is defined in a file per architecture, as the syscall
+// package lacks it in some of them. It returns the number of bytes read, which are read into the buffers in
+// order, and may be less than the requested ones.
+func processVMReadv(pid uint, addresses []uintptr, buffers [][]byte) (bytesRead int, err error) {
+	local := make([]syscall.Iovec, 0, len(buffers))
+	remote := make([]remoteIovec, 0, len(buffers))
+	for i, buf := range buffers {
+		if len(buf) == 0 {
+			continue
+		}
+
+		iov := syscall.Iovec{Base: &buf[0]}
+		iov.SetLen(len(buf))
+		local = append(local, iov)
+		remote = append(remote, remoteIovec{base: addresses[i], length: uintptr(len(buf))})
 	}
-	defer mem.Close()
 
-	bytesRead, harderror := mem.ReadAt(buffer, int64(address))
-	if harderror != nil {
-		harderror := fmt.Errorf("Error while reading %d bytes starting at %x: %s", len(buffer), address, harderror)
-		return softerrors, harderror
+	if len(local) == 0 {
+		return 0, nil
 	}
 
-	if bytesRead != len(buffer) {
-		return softerrors, fmt.Errorf("Could not read the entire buffer")
+	n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(pid),
+		uintptr(unsafe.Pointer(&local[0])), uintptr(len(local)),
+		uintptr(unsafe.Pointer(&remote[0])), uintptr(len(remote)), 0)
+	runtime.KeepAlive(buffers)
+	if errno != 0 {
+		return 0, errno
 	}
 
-	return softerrors, nil
+	return int(n), nil
 }
@@ -0,0 +1,12 @@
+package memaccess
+
+import "debug/elf"
+
+// sysProcessVMReadv is the number of the process_vm_readv(2) syscall on 386.
+const sysProcessVMReadv = 347
+
+// coreMachine is the machine of the ELF core files written by DumpCore on 386.
+const coreMachine = elf.EM_386
+
+// coreUIDSize is the size of the user and group ids in the NT_PRPSINFO notes of core files on 386.
+const coreUIDSize = 2
@@ -0,0 +1,12 @@
+package memaccess
+
+import "debug/elf"
+
+// sysProcessVMReadv is the number of the process_vm_readv(2) syscall on arm64.
+const sysProcessVMReadv = 270
+
+// coreMachine is the machine of the ELF core files written by DumpCore on arm64.
+const coreMachine = elf.EM_AARCH64
+
+// coreUIDSize is the size of the user and group ids in the NT_PRPSINFO notes of core files on arm64.
+const coreUIDSize = 4
@@ -0,0 +1,16 @@
+//go:build linux && !amd64 && !386 && !arm64 && !arm
+// +build linux,!amd64,!386,!arm64,!arm
+
+package memaccess
+
+import (
+	"debug/elf"
+	"syscall"
+)
+
+const sysProcessVMReadv = syscall.SYS_PROCESS_VM_READV
+
+// coreMachine is EM_NONE on the architectures where DumpCore isn't supported.
+const coreMachine = elf.EM_NONE
+
+const coreUIDSize = 4
@@ -0,0 +1,423 @@
+package memaccess
+
+import (
+	"errors"
+	"github.com/mozilla/masche/common"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"os"
+	"regexp"
+	"syscall"
+	"testing"
+)
+
+func TestParseMapping(t *testing.T) {
+	// The addresses fit in 32 bits, so the test also builds for 32-bit architectures.
+	var entries = []string{
+		"f7f65000-f7f66000 r-xp 00023000 08:01 922969                     /lib/x86_64-linux-gnu/ld-2.19.so",
+		"f7f65000-f7f66000 rw-s 00000000 fe:1a 12                         /dev/shm/with spaces (deleted)",
+		"f7f66000-f7f67000 rwxp 00000000 00:00 0",
+		"ffda6000-ffdc7000 rw-p 00000000 00:00 0                          [stack]",
+	}
+
+	var results = []Mapping{
+		{Address: 0xf7f65000, Size: 0x1000, Permissions: PermRead | PermExecute | PermPrivate, Offset: 0x23000,
+			DevMajor: 8, DevMinor: 1, Inode: 922969, Path: "/lib/x86_64-linux-gnu/ld-2.19.so"},
+		{Address: 0xf7f65000, Size: 0x1000, Permissions: PermRead | PermWrite | PermShared,
+			DevMajor: 0xfe, DevMinor: 0x1a, Inode: 12, Path: "/dev/shm/with spaces", Deleted: true},
+		{Address: 0xf7f66000, Size: 0x1000, Permissions: PermRead | PermWrite | PermExecute | PermPrivate},
+		{Address: 0xffda6000, Size: 0x21000, Permissions: PermRead | PermWrite | PermPrivate, Path: "[stack]",
+			Pseudo: true},
+	}
+
+	for i, entry := range entries {
+		m, err := parseMapping(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m != results[i] {
+			t.Error("Error parsing map entry", entry, " - Expected:", results[i], " - Got: ", m)
+		}
+	}
+
+	var invalidEntries = []string{
+		"",
+		"f7f65000-f7f66000 r-xp",
+		"f7f65000-f7f66000 r-x 00023000 08:01 922969",
+		"f7f65000-f7f66000 r-xp 0002300g 08:01 922969",
+		"f7f65000-f7f66000 r-xp 00023000 0801 922969",
+		"f7f65000-f7f66000 r-xp 00023000 08:01 abc",
+	}
+
+	for _, entry := range invalidEntries {
+		_, err := parseMapping(entry)
+		var parseErr *MapsParseError
+		if !errors.As(err, &parseErr) || parseErr.Line != entry {
+			t.Error("a *MapsParseError should have been returned when parsing", entry, "and got", err)
+		}
+	}
+}
+
+func TestListMappings(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	proc, softerrors, err := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	mappings, softerrors, err := ListMappings(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foundBinary, foundStack := false, false
+	for i, m := range mappings {
+		if i > 0 && m.Address < mappings[i-1].Address+uintptr(mappings[i-1].Size) {
+			t.Errorf("%v is not after %v", m, mappings[i-1])
+		}
+
+		if m.Path == test.GetTestCasePath() {
+			foundBinary = true
+			if m.IsAnonymous() || m.Pseudo {
+				t.Errorf("%v should be file-backed", m)
+			}
+		}
+
+		if m.Path == "[stack]" {
+			foundStack = true
+			if !m.IsAnonymous() || !m.Pseudo || !m.IsWritable() {
+				t.Errorf("%v should be an anonymous writable pseudo-mapping", m)
+			}
+		}
+	}
+
+	if !foundBinary {
+		t.Error("The test case binary is not mapped")
+	}
+
+	if !foundStack {
+		t.Error("The stack is not mapped")
+	}
+}
+
+func TestWalkMemoryFiltered(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	proc, softerrors, err := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	mappings, softerrors, err := ListMappings(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := map[string]RegionFilter{
+		"writable":       WritableRegions,
+		"anonymous":      AnonymousRegions,
+		"heap and stack": HeapAndStackRegions,
+		"private anonymous writable": AllFilters(PrivateRegions, AnonymousRegions,
+			WritableRegions),
+		"test binary": RegionsWithPath(regexp.MustCompile(regexp.QuoteMeta(test.GetTestCasePath()))),
+		"range":       RegionsInRange(mappings[0].Address, mappings[0].Address+1),
+	}
+
+	for name, filter := range filters {
+		walked := uint(0)
+		softerrors, err = WalkMemoryFiltered(proc, 0, 1024, filter, func(address uintptr, buf []byte) (
+			keepSearching bool) {
+			for _, m := range mappings {
+				if m.Contains(address) && !filter(m) {
+					t.Errorf("Walked %x in %v, which is rejected by the %s filter", address, m, name)
+					return false
+				}
+			}
+
+			walked += uint(len(buf))
+			return true
+		})
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if walked == 0 {
+			t.Errorf("Nothing was walked with the %s filter", name)
+		}
+	}
+}
+
+// BenchmarkCopyMemoryReopening reads the same chunks as BenchmarkCopyMemory, but opening the memory file of the process
+// on every read, to measure what keeping it open saves.
+func BenchmarkCopyMemoryReopening(b *testing.B) {
+	cmd, proc, region := openBiggestRegion(b)
+	defer cmd.Process.Kill()
+	defer proc.Close()
+
+	addresses, buffers := scatteredChunks(region)
+	b.SetBytes(int64(len(buffers) * scatteredChunkSize))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := range buffers {
+			mem, err := os.Open(common.MemFilePathFromPid(proc.Pid()))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			_, err = mem.ReadAt(buffers[j], int64(addresses[j]))
+			mem.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkWalkMemoryReopening walks the same memory as BenchmarkWalkMemory, but reading it as WalkMemory used to do:
+// through the memory file of the process, opening it on every read.
+func BenchmarkWalkMemoryReopening(b *testing.B) {
+	cmd, proc, _ := openBiggestRegion(b)
+	defer cmd.Process.Kill()
+	defer proc.Close()
+
+	buf := make([]byte, 4096)
+	for i := 0; i < b.N; i++ {
+		walked := int64(0)
+		region, _, err := NextReadableMemoryRegion(proc, 0)
+		for err == nil && region != NoRegionAvailable {
+			end := region.Address + uintptr(region.Size)
+			for addr := region.Address; addr < end; addr += uintptr(len(buf)) {
+				chunk := buf
+				if end-addr < uintptr(len(chunk)) {
+					chunk = chunk[:end-addr]
+				}
+
+				mem, err := os.Open(common.MemFilePathFromPid(proc.Pid()))
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, _ := mem.ReadAt(chunk, int64(addr))
+				mem.Close()
+				walked += int64(n)
+			}
+			region, _, err = NextReadableMemoryRegion(proc, end)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(walked)
+	}
+}
+
+func TestReadGoneProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	region, softerrors, err := NextReadableMemoryRegion(proc, 0)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	buffer := make([]byte, 16)
+	if _, err = CopyMemory(proc, region.Address, buffer); err != process.ErrProcessGone {
+		t.Error("Expected ErrProcessGone from CopyMemory and got", err)
+	}
+
+	if _, err = CopyMemoryRanges(proc, []uintptr{region.Address}, [][]byte{buffer}); err != process.ErrProcessGone {
+		t.Error("Expected ErrProcessGone from CopyMemoryRanges and got", err)
+	}
+
+	if _, _, err = ListMappings(proc); err != process.ErrProcessGone {
+		t.Error("Expected ErrProcessGone from ListMappings and got", err)
+	}
+}
+
+func TestReadUnmappedMemory(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// The first page is never mapped.
+	buffer := make([]byte, 16)
+	_, err = CopyMemory(proc, 0, buffer)
+
+	var readErr *RegionReadError
+	if !errors.As(err, &readErr) {
+		t.Fatal("Expected a *RegionReadError and got", err)
+	}
+	if readErr.Addr != 0 || readErr.Len != uint(len(buffer)) {
+		t.Error("Unexpected address", readErr.Addr, "or length", readErr.Len)
+	}
+
+	if !errors.Is(err, ErrUnmapped) || !errors.Is(err, syscall.EIO) {
+		t.Error("Expected the error to match ErrUnmapped and EIO, got", err)
+	}
+
+	if _, err = CopyMemoryRanges(proc, []uintptr{0}, [][]byte{buffer}); !errors.Is(err, ErrUnmapped) {
+		t.Error("Expected an error matching ErrUnmapped from CopyMemoryRanges and got", err)
+	}
+}
+
+func TestWriteMemory(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	readOnly, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+
+	proc, softerrors, err := process.OpenFromPidForWriting(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	mappings, softerrors, err := ListMappings(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var writable, notWritable *Mapping
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Path == "[vsyscall]" || !m.IsReadable() {
+			continue
+		}
+		if m.IsWritable() && writable == nil {
+			writable = m
+		}
+		if !m.IsWritable() && notWritable == nil {
+			notWritable = m
+		}
+	}
+	if writable == nil || notWritable == nil {
+		t.Fatal("The test process should have writable and non-writable mappings:", mappings)
+	}
+
+	// writeAndRestore writes data at address, checks that it can be read back, and writes the original memory back.
+	writeAndRestore := func(write func(process.Process, uintptr, []byte) ([]error, error), address uintptr) {
+		data := []byte("masche was here")
+		original := make([]byte, len(data))
+		softerrors, err := CopyMemory(proc, address, original)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		softerrors, err = write(proc, address, data)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buffer := make([]byte, len(data))
+		softerrors, err = CopyMemory(proc, address, buffer)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buffer) != string(data) {
+			t.Errorf("Expected %q at %x and read %q", data, address, buffer)
+		}
+
+		softerrors, err = write(proc, address, original)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeAndRestore(WriteMemory, writable.Address)
+
+	var writeErr *RegionWriteError
+	_, err = WriteMemory(proc, notWritable.Address, []byte{0})
+	if !errors.As(err, &writeErr) || !errors.Is(err, ErrNotWritable) {
+		t.Error("Expected a *RegionWriteError matching ErrNotWritable and got", err)
+	}
+
+	// A range that ends after the writable mapping is only valid if the following one is writable too.
+	end := writable.Address + uintptr(writable.Size)
+	_, err = WriteMemory(proc, end-1, []byte{0, 0})
+	next := findMappingAt(mappings, end)
+	if next == nil && !errors.Is(err, ErrUnmapped) {
+		t.Error("Expected an error matching ErrUnmapped and got", err)
+	}
+	if next != nil && !next.IsWritable() && !errors.Is(err, ErrNotWritable) {
+		t.Error("Expected an error matching ErrNotWritable and got", err)
+	}
+
+	// The first page is never mapped.
+	if _, err = WriteMemory(proc, 0, []byte{0}); !errors.Is(err, ErrUnmapped) {
+		t.Error("Expected an error matching ErrUnmapped and got", err)
+	}
+	if _, err = ForceWriteMemory(proc, 0, []byte{0}); !errors.Is(err, ErrUnmapped) {
+		t.Error("Expected an error matching ErrUnmapped from ForceWriteMemory and got", err)
+	}
+
+	if _, err = WriteMemory(readOnly, writable.Address, []byte{0}); err != ErrNotOpenedForWriting {
+		t.Error("Expected ErrNotOpenedForWriting and got", err)
+	}
+
+	writeAndRestore(ForceWriteMemory, notWritable.Address+1)
+}
+
+// findMappingAt returns the mapping that contains address, or nil if there's none.
+func findMappingAt(mappings []Mapping, address uintptr) *Mapping {
+	for i := range mappings {
+		if mappings[i].Contains(address) {
+			return &mappings[i]
+		}
+	}
+	return nil
+}
@@ -1,9 +1,12 @@
 package memaccess
 
 import (
+	"bytes"
+	"context"
 	"github.com/mozilla/masche/process"
 	"github.com/mozilla/masche/test"
 	"os"
+	"os/exec"
 	"testing"
 )
 
@@ -266,6 +269,13 @@ func TestSlidingWalkMemory(t *testing.T) {
 		softerrors, err = SlidingWalkMemory(proc, 0, size, func(address uintptr, buffer []byte) (keepSearching bool) {
 			currentRegion := MemoryRegion{Address: address, Size: uint(len(buffer))}
 
+			expected := make([]byte, len(buffer))
+			if _, err := CopyMemory(proc, address, expected); err == nil && !bytes.Equal(expected, buffer) {
+				t.Errorf("Buffer contents don't match the memory at its address. "+
+					"buffer size %d - currentRegion %v", size, currentRegion)
+				return false
+			}
+
 			if lastRegion.Address == 0 {
 				lastRegion = currentRegion
 				return true
@@ -313,3 +323,206 @@ func TestSlidingWalkMemory(t *testing.T) {
 		}
 	}
 }
+
+// scatteredChunkSize is the size of each of the chunks read by the CopyMemory benchmarks, which are read from the
+// start of every page of a region.
+const scatteredChunkSize = 512
+
+// openBiggestRegion opens the test case and returns its biggest readable memory region. The returned command must be
+// killed once the benchmark is done.
+func openBiggestRegion(b *testing.B) (cmd *exec.Cmd, proc process.Process, biggest MemoryRegion) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	proc, _, err = process.OpenFromPid(uint(cmd.Process.Pid))
+	if err != nil {
+		cmd.Process.Kill()
+		b.Fatal(err)
+	}
+
+	region, _, err := NextReadableMemoryRegion(proc, 0)
+	for err == nil && region != NoRegionAvailable {
+		if region.Size > biggest.Size {
+			biggest = region
+		}
+		region, _, err = NextReadableMemoryRegion(proc, region.Address+uintptr(region.Size))
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		b.Fatal(err)
+	}
+
+	return cmd, proc, biggest
+}
+
+// scatteredChunks returns the addresses and buffers needed to read a chunk from the start of every page of region.
+func scatteredChunks(region MemoryRegion) (addresses []uintptr, buffers [][]byte) {
+	pageSize := uintptr(os.Getpagesize())
+	for addr := region.Address; addr < region.Address+uintptr(region.Size); addr += pageSize {
+		addresses = append(addresses, addr)
+		buffers = append(buffers, make([]byte, scatteredChunkSize))
+	}
+	return
+}
+
+func BenchmarkCopyMemory(b *testing.B) {
+	cmd, proc, region := openBiggestRegion(b)
+	defer cmd.Process.Kill()
+	defer proc.Close()
+
+	addresses, buffers := scatteredChunks(region)
+	b.SetBytes(int64(len(buffers) * scatteredChunkSize))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := range buffers {
+			if _, err := CopyMemory(proc, addresses[j], buffers[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkCopyMemoryRanges(b *testing.B) {
+	cmd, proc, region := openBiggestRegion(b)
+	defer cmd.Process.Kill()
+	defer proc.Close()
+
+	addresses, buffers := scatteredChunks(region)
+	b.SetBytes(int64(len(buffers) * scatteredChunkSize))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := CopyMemoryRanges(proc, addresses, buffers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWalkMemory(b *testing.B) {
+	cmd, proc, _ := openBiggestRegion(b)
+	defer cmd.Process.Kill()
+	defer proc.Close()
+
+	for i := 0; i < b.N; i++ {
+		walked := int64(0)
+		_, err := WalkMemory(proc, 0, 4096, func(address uintptr, buf []byte) (keepSearching bool) {
+			walked += int64(len(buf))
+			return true
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(walked)
+	}
+}
+
+func TestCopyMemoryRanges(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	proc, softerrors, err := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	region, softerrors, err := NextReadableMemoryRegion(proc, 0)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addresses, buffers := scatteredChunks(region)
+	softerrors, err = CopyMemoryRanges(proc, addresses, buffers)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, buffer := range buffers {
+		expected := make([]byte, len(buffer))
+		softerrors, err = CopyMemory(proc, addresses[i], expected)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(expected, buffer) {
+			t.Errorf("CopyMemoryRanges read different bytes than CopyMemory at %x", addresses[i])
+		}
+	}
+
+	// The last range is outside the region
+	addresses = append(addresses, region.Address+uintptr(region.Size))
+	buffers = append(buffers, make([]byte, scatteredChunkSize))
+	softerrors, err = CopyMemoryRanges(proc, addresses, buffers)
+	test.PrintSoftErrors(softerrors)
+	if err == nil {
+		t.Error("CopyMemoryRanges read a range after the region")
+	}
+
+	if _, err = CopyMemoryRanges(proc, addresses[1:], buffers); err == nil {
+		t.Error("CopyMemoryRanges accepted a different number of addresses and buffers")
+	}
+}
+
+func TestWalkMemoryContext(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	for _, walk := range []func(context.Context, process.Process, uintptr, uint, RegionFilter, WalkFunc) (uintptr,
+		[]error, error){WalkMemoryContext, SlidingWalkMemoryContext} {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		var end uintptr
+		lastAddress, softerrors, err := walk(ctx, proc, 0, 1024, nil,
+			func(address uintptr, buf []byte) (keepSearching bool) {
+				calls++
+				end = address + uintptr(len(buf))
+				cancel()
+				return true
+			})
+		test.PrintSoftErrors(softerrors)
+
+		if err != context.Canceled {
+			t.Error("Expected context.Canceled and got", err)
+		}
+		if calls != 1 {
+			t.Error("Expected the walk to stop after the first call, but walkFn was called", calls, "times")
+		}
+		if lastAddress != end {
+			t.Errorf("Expected the walk to stop at %x and got %x", end, lastAddress)
+		}
+
+		// An expired context doesn't read anything.
+		ctx, cancel = context.WithTimeout(context.Background(), 0)
+		lastAddress, _, err = walk(ctx, proc, 0x1000, 1024, nil, func(address uintptr, buf []byte) bool {
+			t.Error("walkFn was called with an expired context")
+			return true
+		})
+		cancel()
+
+		if err != context.DeadlineExceeded || lastAddress != 0x1000 {
+			t.Errorf("Expected context.DeadlineExceeded at %x and got %v at %x", 0x1000, err, lastAddress)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package memaccess
+
+import (
+	"fmt"
+	"github.com/mozilla/masche/process"
+	"io"
+	"runtime"
+)
+
+func listMappings(p process.Process) (mappings []Mapping, softerrors []error, harderror error) {
+	return nil, nil, fmt.Errorf("Listing memory mappings is not supported on %s", runtime.GOOS)
+}
+
+func writeMemory(p process.Process, address uintptr, data []byte, force bool) (softerrors []error, harderror error) {
+	return nil, fmt.Errorf("Writing memory is not supported on %s", runtime.GOOS)
+}
+
+func dumpCore(p process.Process, w io.Writer) (softerrors []error, harderror error) {
+	return nil, fmt.Errorf("Dumping core files is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,17 @@
+package memaccess
+
+import (
+	"errors"
+	"syscall"
+)
+
+// These are the system error codes ReadProcessMemory fails with when reading unmapped memory.
+const (
+	errorPartialCopy syscall.Errno = 299
+	errorNoAccess    syscall.Errno = 998
+)
+
+// isUnmappedError returns true if err is the error returned when reading unmapped memory.
+func isUnmappedError(err error) bool {
+	return errors.Is(err, errorPartialCopy) || errors.Is(err, errorNoAccess)
+}
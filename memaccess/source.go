@@ -0,0 +1,42 @@
+package memaccess
+
+import (
+	"github.com/mozilla/masche/process"
+)
+
+// MemorySource is implemented by the Processes whose memory isn't read from a running process, like the ones opened
+// from dumps by the offline package. The functions of this package use its methods instead of accessing the process
+// when it implements them, so everything built on them works the same with running processes and with dumps.
+type MemorySource interface {
+	process.Process
+
+	// Mappings returns every memory mapping of the process, sorted by address. The mappings, or parts of them, whose
+	// contents aren't available must not have the PermRead permission.
+	Mappings() (mappings []Mapping, softerrors []error, harderror error)
+
+	// ReadMemory fills the entire buffer with memory starting in address, returning a *RegionReadError if it can't.
+	ReadMemory(address uintptr, buffer []byte) error
+}
+
+func sourceNextReadableMemoryRegion(s MemorySource, address uintptr) (region MemoryRegion, softerrors []error,
+	harderror error) {
+
+	mappings, softerrors, harderror := s.Mappings()
+	if harderror != nil {
+		return NoRegionAvailable, softerrors, harderror
+	}
+
+	region, serrs := nextRegionFromMappings(mappings, address, nil)
+	return region, append(softerrors, serrs...), nil
+}
+
+func sourceCopyMemoryRanges(s MemorySource, addresses []uintptr, buffers [][]byte) (softerrors []error,
+	harderror error) {
+
+	for i, buf := range buffers {
+		if err := s.ReadMemory(addresses[i], buf); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
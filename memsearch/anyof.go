@@ -0,0 +1,164 @@
+package memsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+)
+
+// PatternMatchFunc type represents a function called with each match found by FindAnyOf. If it returns false the
+// search is stopped.
+type PatternMatchFunc func(m PatternMatch) (keepSearching bool)
+
+// FindAnyOf finds every occurrence of any of the patterns in the Process starting at a given address (in the process
+// address space), reading its memory only once. matchFn is called with each of them and the index of the pattern in
+// patterns. Overlapping occurrences are all reported, even if they are of different patterns.
+//
+// Matches are reported in increasing order of their end address, so a match can be reported after another one that
+// starts after it. Matches of any length are found, even if they cross the boundaries of the buffers used to read the
+// memory, as long as they are in a single MemoryRegion.
+//
+// If maxMatches is greater than zero the search stops after finding that many matches.
+func FindAnyOf(p process.Process, address uintptr, patterns [][]byte, maxMatches int,
+	matchFn PatternMatchFunc) (softerrors []error, harderror error) {
+
+	_, softerrors, harderror = FindAnyOfContext(context.Background(), p, address, nil, patterns, maxMatches, matchFn)
+	return
+}
+
+// FindAnyOfContext works as FindAnyOf, but it only searches in the mappings accepted by filter, and it stops once ctx
+// is done, returning ctx.Err() as the hard error. If filter is nil every readable mapping is searched.
+//
+// lastAddress is the address following the last byte searched. Every match that ends before it has been reported, but
+// note that resuming the search from it won't find the matches that cross it.
+func FindAnyOfContext(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	patterns [][]byte, maxMatches int, matchFn PatternMatchFunc) (lastAddress uintptr, softerrors []error,
+	harderror error) {
+
+	ac, err := newAhoCorasick(patterns)
+	if err != nil {
+		return address, nil, err
+	}
+
+	var region memaccess.MemoryRegion
+	var regionHarderror error
+	var state int32
+	matches := 0
+	lastAddress = address
+
+	_, softerrors, harderror = memaccess.WalkMemoryContext(ctx, p, address, minWindowSize, filter,
+		func(address uintptr, buf []byte) (keepSearching bool) {
+			// Matches can't cross a gap in the memory, so we start again from the initial state after one.
+			if address != lastAddress {
+				state = 0
+			}
+
+			regionEnd := region.Address + uintptr(region.Size)
+			if address < region.Address || address >= regionEnd {
+				// The soft errors found looking for the region were already reported by the walk.
+				region, _, regionHarderror = memaccess.NextFilteredMemoryRegion(p, address, filter)
+				if regionHarderror != nil {
+					return false
+				}
+			}
+
+			for i, b := range buf {
+				state = ac.delta[state][b]
+				for _, pattern := range ac.out[state] {
+					end := address + uintptr(i) + 1
+					m := PatternMatch{Pattern: pattern, Match: Match{
+						Address: end - uintptr(len(patterns[pattern])),
+						Data:    append([]byte(nil), patterns[pattern]...),
+						Region:  region,
+					}}
+
+					matches++
+					lastAddress = end
+					if !matchFn(m) || (maxMatches > 0 && matches >= maxMatches) {
+						return false
+					}
+				}
+			}
+
+			lastAddress = address + uintptr(len(buf))
+			return true
+		})
+
+	if harderror == nil {
+		harderror = regionHarderror
+	}
+	return
+}
+
+// ahoCorasick is an Aho-Corasick automaton that finds the occurrences of many patterns in a single pass over the data.
+// It's represented as a DFA, with the failure transitions already resolved, so it takes a single lookup per byte.
+type ahoCorasick struct {
+	// delta holds the transitions of each state, where the state 0 is the initial one.
+	delta [][256]int32
+
+	// out holds the indexes of the patterns that end in each state, including the ones that are suffixes of others.
+	out [][]int
+}
+
+// newAhoCorasick builds the automaton for the given patterns, none of which can be empty.
+func newAhoCorasick(patterns [][]byte) (*ahoCorasick, error) {
+	ac := &ahoCorasick{}
+	newState := func() int32 {
+		var transitions [256]int32
+		for i := range transitions {
+			transitions[i] = -1
+		}
+		ac.delta = append(ac.delta, transitions)
+		ac.out = append(ac.out, nil)
+		return int32(len(ac.delta) - 1)
+	}
+	newState()
+
+	// First we build the trie of the patterns, with -1 representing the missing transitions.
+	for i, pattern := range patterns {
+		if len(pattern) == 0 {
+			return nil, fmt.Errorf("Pattern %d is empty", i)
+		}
+
+		state := int32(0)
+		for _, b := range pattern {
+			if ac.delta[state][b] == -1 {
+				next := newState()
+				ac.delta[state][b] = next
+			}
+			state = ac.delta[state][b]
+		}
+		ac.out[state] = append(ac.out[state], i)
+	}
+
+	// Then we visit it in breadth-first order, so the failure state of every state, which is always shallower, has
+	// already been resolved. The missing transitions of a state are the ones of its failure state.
+	fail := make([]int32, len(ac.delta))
+	queue := make([]int32, 0, len(ac.delta))
+	for b, next := range ac.delta[0] {
+		if next == -1 {
+			ac.delta[0][b] = 0
+		} else {
+			queue = append(queue, next)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for b, next := range ac.delta[state] {
+			if next == -1 {
+				ac.delta[state][b] = ac.delta[fail[state]][b]
+				continue
+			}
+
+			fail[next] = ac.delta[fail[state]][b]
+			ac.out[next] = append(ac.out[next], ac.out[fail[next]]...)
+			queue = append(queue, next)
+		}
+	}
+
+	return ac, nil
+}
@@ -0,0 +1,260 @@
+package memsearch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"strings"
+	"unicode/utf16"
+)
+
+// Encoding is a set of the encodings a string can be searched in. They can be combined, e.g. ASCII|UTF16LE searches
+// a string both as ASCII and as UTF-16LE.
+type Encoding uint8
+
+const (
+	// ASCII searches the bytes of the string as they are. Strictly speaking it's UTF-8, as characters out of the
+	// ASCII range are searched in that encoding.
+	ASCII Encoding = 1 << iota
+
+	// UTF16LE searches the string in little endian UTF-16, as Windows, Java and .NET programs usually keep it.
+	UTF16LE
+
+	// UTF16BE searches the string in big endian UTF-16.
+	UTF16BE
+
+	// UTF16 searches the string in both UTF-16 byte orders. Note that an ASCII string surrounded by zeros is found in
+	// both of them, one byte apart.
+	UTF16 = UTF16LE | UTF16BE
+
+	// AllEncodings searches the string in every encoding.
+	AllEncodings = ASCII | UTF16
+)
+
+// encodingNames holds the name of each single encoding, in the order they are searched.
+var encodingNames = []struct {
+	encoding Encoding
+	name     string
+}{
+	{ASCII, "ASCII"},
+	{UTF16LE, "UTF-16LE"},
+	{UTF16BE, "UTF-16BE"},
+}
+
+func (e Encoding) String() string {
+	var names []string
+	for _, n := range encodingNames {
+		if e&n.encoding != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("Encoding(%d)", e)
+	}
+	return strings.Join(names, "|")
+}
+
+// StringOptions configures a string search.
+type StringOptions struct {
+	// Encodings are the encodings the string is searched in, all of them in a single pass over the memory. If it's
+	// zero it's searched as ASCII.
+	Encodings Encoding
+
+	// IgnoreCase makes the ASCII letters match in any case. The case of other characters is never ignored.
+	IgnoreCase bool
+}
+
+// StringMatch represents an occurrence of a string found by FindAllStrings, and the encoding it was found in.
+type StringMatch struct {
+	Match
+	Encoding Encoding
+}
+
+// StringMatchFunc type represents a function called with each match found by FindAllStrings. If it returns false the
+// search is stopped.
+type StringMatchFunc func(m StringMatch) (keepSearching bool)
+
+// FindString finds the first occurrence of s in the Process starting at a given address (in the process address
+// space), in any of the encodings in opts. It works as FindBytesSequence, but it also returns the encoding the string
+// was found in.
+func FindString(p process.Process, address uintptr, s string, opts StringOptions) (found bool, foundAddress uintptr,
+	encoding Encoding, softerrors []error, harderror error) {
+
+	softerrors, harderror = FindAllStrings(p, address, s, opts, 1, func(m StringMatch) (keepSearching bool) {
+		found = true
+		foundAddress = m.Address
+		encoding = m.Encoding
+		return false
+	})
+	return
+}
+
+// FindAllStrings finds every occurrence of s in the Process starting at a given address (in the process address
+// space), in any of the encodings in opts, calling matchFn with each of them in increasing address order. The memory
+// is read only once, searching every encoding at the same time.
+//
+// If maxMatches is greater than zero the search stops after finding that many matches.
+func FindAllStrings(p process.Process, address uintptr, s string, opts StringOptions, maxMatches int,
+	matchFn StringMatchFunc) (softerrors []error, harderror error) {
+
+	_, softerrors, harderror = FindAllStringsContext(context.Background(), p, address, nil, s, opts, maxMatches,
+		matchFn)
+	return
+}
+
+// FindAllStringsContext works as FindAllStrings, but it only searches in the mappings accepted by filter, and it stops
+// once ctx is done as FindAllBytesSequencesContext does. If filter is nil every readable mapping is searched.
+func FindAllStringsContext(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	s string, opts StringOptions, maxMatches int, matchFn StringMatchFunc) (lastAddress uintptr, softerrors []error,
+	harderror error) {
+
+	patterns, encodings, err := StringMatchers(s, opts)
+	if err != nil {
+		return address, nil, err
+	}
+
+	return findAll(ctx, p, address, filter, patterns, maxMatches, 0, nil,
+		func(pattern int, m Match) (keepSearching bool) {
+			return matchFn(StringMatch{Match: m, Encoding: encodings[pattern]})
+		})
+}
+
+// StringMatchers returns the Matchers that find s in the encodings in opts, and the encoding of each of them. They can
+// be used to search strings with a Scanner.
+func StringMatchers(s string, opts StringOptions) (matchers []Matcher, encodings []Encoding, err error) {
+	if s == "" {
+		return nil, nil, fmt.Errorf("Empty string")
+	}
+
+	selected := opts.Encodings
+	if selected == 0 {
+		selected = ASCII
+	}
+	if selected&^AllEncodings != 0 {
+		return nil, nil, fmt.Errorf("Invalid encodings %v", selected)
+	}
+
+	for _, n := range encodingNames {
+		if selected&n.encoding == 0 {
+			continue
+		}
+
+		encoded, letters := encodeString(s, n.encoding)
+		if opts.IgnoreCase {
+			matchers = append(matchers, newFoldedLiteral(encoded, letters))
+		} else {
+			matchers = append(matchers, Literal(encoded))
+		}
+		encodings = append(encodings, n.encoding)
+	}
+	return matchers, encodings, nil
+}
+
+// encodeString returns s encoded with a single encoding, and which of its bytes encode ASCII letters.
+func encodeString(s string, encoding Encoding) (encoded []byte, letters []bool) {
+	if encoding == ASCII {
+		encoded = []byte(s)
+		for _, b := range encoded {
+			letters = append(letters, isASCIILetter(b))
+		}
+		return encoded, letters
+	}
+
+	for _, unit := range utf16.Encode([]rune(s)) {
+		low, high := byte(unit), byte(unit>>8)
+		letter := unit < 0x80 && isASCIILetter(low)
+		if encoding == UTF16LE {
+			encoded = append(encoded, low, high)
+			letters = append(letters, letter, false)
+		} else {
+			encoded = append(encoded, high, low)
+			letters = append(letters, false, letter)
+		}
+	}
+	return encoded, letters
+}
+
+func isASCIILetter(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+func toLowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// LiteralIgnoreCase returns a Matcher that works as Literal(l), but in which the ASCII letters match in any case.
+func LiteralIgnoreCase(l []byte) Matcher {
+	letters := make([]bool, len(l))
+	for i, b := range l {
+		letters[i] = isASCIILetter(b)
+	}
+	return newFoldedLiteral(l, letters)
+}
+
+// foldedLiteral is a Matcher for a literal bytes sequence in which some bytes, the ones that encode ASCII letters,
+// match in any case.
+type foldedLiteral struct {
+	// lower holds the literal with the letters in lower case.
+	lower   []byte
+	letters []bool
+}
+
+func newFoldedLiteral(l []byte, letters []bool) *foldedLiteral {
+	lower := make([]byte, len(l))
+	for i, b := range l {
+		if letters[i] {
+			b = toLowerASCII(b)
+		}
+		lower[i] = b
+	}
+	return &foldedLiteral{lower: lower, letters: letters}
+}
+
+// FindAllIndex returns the [start, end) indexes of up to n occurrences of l in b, including overlapping ones.
+func (l *foldedLiteral) FindAllIndex(b []byte, n int) [][]int {
+	if len(l.lower) == 0 {
+		return Literal(nil).FindAllIndex(b, n)
+	}
+
+	var locs [][]int
+	first := l.lower[0]
+	for start := 0; start <= len(b)-len(l.lower) && (n < 0 || len(locs) < n); start++ {
+		// If the first byte isn't a letter we can skip to its occurrences.
+		if !l.letters[0] {
+			i := bytes.IndexByte(b[start:len(b)-len(l.lower)+1], first)
+			if i == -1 {
+				break
+			}
+			start += i
+		}
+
+		if l.matchesAt(b[start:]) {
+			locs = append(locs, []int{start, start + len(l.lower)})
+		}
+	}
+	return locs
+}
+
+// matchesAt returns true if b starts with the literal.
+func (l *foldedLiteral) matchesAt(b []byte) bool {
+	for i, c := range l.lower {
+		if l.letters[i] {
+			if toLowerASCII(b[i]) != c {
+				return false
+			}
+		} else if b[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxLength returns the length of the literal.
+func (l *foldedLiteral) MaxLength() int {
+	return len(l.lower)
+}
@@ -0,0 +1,110 @@
+package memsearch
+
+import (
+	"context"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestStringMatchers(t *testing.T) {
+	// "Ab" in ASCII, "aB" in UTF-16LE, "AB" in UTF-16BE, and "Ł" (whose low byte is an "A") in UTF-16LE.
+	buf := []byte("Ab a\x00B\x00 \x00A\x00B \x41\x01")
+
+	cases := []struct {
+		s        string
+		opts     StringOptions
+		expected map[int]Encoding
+	}{
+		{"Ab", StringOptions{}, map[int]Encoding{0: ASCII}},
+		{"ab", StringOptions{}, map[int]Encoding{}},
+		{"ab", StringOptions{IgnoreCase: true}, map[int]Encoding{0: ASCII}},
+		{"ab", StringOptions{Encodings: AllEncodings}, map[int]Encoding{}},
+		{"aB", StringOptions{Encodings: AllEncodings}, map[int]Encoding{3: UTF16LE}},
+		{"ab", StringOptions{Encodings: AllEncodings, IgnoreCase: true},
+			map[int]Encoding{0: ASCII, 3: UTF16LE, 8: UTF16BE}},
+		{"ab", StringOptions{Encodings: UTF16, IgnoreCase: true}, map[int]Encoding{3: UTF16LE, 8: UTF16BE}},
+		{"Ł", StringOptions{Encodings: UTF16LE}, map[int]Encoding{13: UTF16LE}},
+		{"š", StringOptions{Encodings: UTF16LE, IgnoreCase: true}, map[int]Encoding{}},
+	}
+
+	for _, c := range cases {
+		matchers, encodings, err := StringMatchers(c.s, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := make(map[int]Encoding)
+		for i, m := range matchers {
+			for _, loc := range m.FindAllIndex(buf, -1) {
+				found[loc[0]] |= encodings[i]
+			}
+		}
+
+		if len(found) != len(c.expected) {
+			t.Errorf("String %q with %+v: expected matches %v and got %v", c.s, c.opts, c.expected, found)
+			continue
+		}
+		for start, encoding := range c.expected {
+			if found[start] != encoding {
+				t.Errorf("String %q with %+v: expected matches %v and got %v", c.s, c.opts, c.expected, found)
+				break
+			}
+		}
+	}
+
+	if _, _, err := StringMatchers("", StringOptions{}); err == nil {
+		t.Error("An empty string should return an error")
+	}
+	if _, _, err := StringMatchers("a", StringOptions{Encodings: 1 << 7}); err == nil {
+		t.Error("An invalid encoding should return an error")
+	}
+	if s := (ASCII | UTF16BE).String(); s != "ASCII|UTF-16BE" {
+		t.Error("Unexpected name of ASCII|UTF16BE:", s)
+	}
+}
+
+func TestFindAllStrings(t *testing.T) {
+	buf := searchableBuffer(16*1024, '.')
+	copy(buf[100:], "hOsTname123")
+	copy(buf[5000:], "H\x00O\x00S\x00T\x00N\x00A\x00M\x00E\x001\x002\x003\x00")
+	copy(buf[9000:], "\x00h\x00o\x00s\x00t\x00n\x00a\x00m\x00e\x001\x002\x003")
+
+	start := uintptr(unsafe.Pointer(&buf[0]))
+	end := start + uintptr(len(buf))
+
+	proc, softerrors, err := process.OpenFromPid(uint(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	found := make(map[uintptr]Encoding)
+	_, softerrors, err = FindAllStringsContext(context.Background(), proc, 0, memaccess.RegionsInRange(start, end),
+		"Hostname123", StringOptions{Encodings: AllEncodings, IgnoreCase: true}, 0,
+		func(m StringMatch) (keepSearching bool) {
+			// The string literals of this test can match too, so we only look at buf.
+			if m.Address >= start && m.Address < end {
+				found[m.Address-start] = m.Encoding
+			}
+			return true
+		})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[uintptr]Encoding{100: ASCII, 5000: UTF16LE, 9000: UTF16BE}
+	if len(found) != len(expected) {
+		t.Fatalf("Expected matches at %v and got %v", expected, found)
+	}
+	for offset, encoding := range expected {
+		if found[offset] != encoding {
+			t.Errorf("Expected matches at %v and got %v", expected, found)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package memsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"sort"
+)
+
+// DefaultMinStringLength is the minimum length of the strings extracted by ExtractStrings if none is given, as in
+// strings(1).
+const DefaultMinStringLength = 4
+
+// ExtractOptions configures the extraction of strings.
+type ExtractOptions struct {
+	// MinLength is the minimum number of characters of the strings extracted. If it's zero or negative
+	// DefaultMinStringLength is used.
+	MinLength int
+
+	// Encodings are the encodings of the strings extracted, all of them in a single pass over the memory. If it's zero
+	// only ASCII strings are extracted. UTF-16 strings must start at an even address.
+	Encodings Encoding
+
+	// Filter selects the mappings to extract strings from, if it's nil every readable mapping is used.
+	Filter memaccess.RegionFilter
+}
+
+// ExtractedString is a string found by ExtractStrings.
+type ExtractedString struct {
+	// Address is the address where the string starts.
+	Address uintptr
+
+	// Value is the string, decoded from its encoding.
+	Value string
+
+	Encoding Encoding
+
+	// Mapping is the mapping where the string starts.
+	Mapping memaccess.Mapping
+}
+
+// ExtractFunc type represents a function called with each string extracted by ExtractStrings. If it returns false the
+// extraction is stopped.
+type ExtractFunc func(s ExtractedString) (keepExtracting bool)
+
+// ExtractStrings extracts the printable strings in the memory of a process, as strings(1) does with files, calling
+// extractFn with each of them. The printable characters are the ASCII ones from the space to the tilde, and the tab.
+// For UTF-16 that means the code units of those characters, so strings with characters out of ASCII are cut at them.
+//
+// Strings are never split at the boundaries of the buffers the memory is read with, but they are cut when the memory
+// isn't contiguous. They are reported as soon as they end, so with a single encoding in increasing address order.
+func ExtractStrings(p process.Process, opts ExtractOptions, extractFn ExtractFunc) (softerrors []error,
+	harderror error) {
+	return ExtractStringsContext(context.Background(), p, opts, extractFn)
+}
+
+// ExtractStringsContext works as ExtractStrings, but it stops once ctx is done, returning ctx.Err() as the hard error.
+func ExtractStringsContext(ctx context.Context, p process.Process, opts ExtractOptions, extractFn ExtractFunc) (
+	softerrors []error, harderror error) {
+
+	minLength := opts.MinLength
+	if minLength <= 0 {
+		minLength = DefaultMinStringLength
+	}
+	encodings := opts.Encodings
+	if encodings == 0 {
+		encodings = ASCII
+	}
+	if encodings&^AllEncodings != 0 {
+		return nil, fmt.Errorf("Invalid encodings %v", encodings)
+	}
+
+	mappings, softerrors, harderror := memaccess.ListMappings(p)
+	if harderror != nil {
+		return softerrors, harderror
+	}
+
+	var extractors []*stringExtractor
+	for _, n := range encodingNames {
+		if encodings&n.encoding != 0 {
+			extractors = append(extractors, &stringExtractor{encoding: n.encoding})
+		}
+	}
+
+	stopped := false
+	emit := func(e *stringExtractor) {
+		if len(e.chars) >= minLength && !stopped {
+			s := ExtractedString{Address: e.start, Value: string(e.chars), Encoding: e.encoding}
+			if i := findMapping(mappings, e.start); i != -1 {
+				s.Mapping = mappings[i]
+			}
+			stopped = !extractFn(s)
+		}
+		e.chars = e.chars[:0]
+	}
+
+	_, serrs, harderror := memaccess.WalkMemoryContext(ctx, p, 0, minWindowSize, opts.Filter,
+		func(address uintptr, buf []byte) (keepSearching bool) {
+			for _, e := range extractors {
+				e.extract(address, buf, emit)
+				if stopped {
+					return false
+				}
+			}
+			return true
+		})
+	softerrors = append(softerrors, serrs...)
+
+	for _, e := range extractors {
+		emit(e)
+	}
+	return softerrors, harderror
+}
+
+// findMapping returns the index of the mapping that contains address in mappings, which are sorted by address, or -1 if
+// there's none.
+func findMapping(mappings []memaccess.Mapping, address uintptr) int {
+	i := sort.Search(len(mappings), func(i int) bool {
+		return mappings[i].Address+uintptr(mappings[i].Size) > address
+	})
+	if i == len(mappings) || !mappings[i].Contains(address) {
+		return -1
+	}
+	return i
+}
+
+// stringExtractor accumulates the printable characters of a single encoding that are found in contiguous memory.
+type stringExtractor struct {
+	encoding Encoding
+
+	// start is the address of the string being accumulated in chars, and next the address following it.
+	start uintptr
+	next  uintptr
+	chars []byte
+
+	// pending holds the first byte of a UTF-16 code unit, if it was at the end of the previous buffer.
+	pending        byte
+	pendingAddress uintptr
+	hasPending     bool
+}
+
+func isPrintable(c byte) bool {
+	return (' ' <= c && c <= '~') || c == '\t'
+}
+
+// extract processes the bytes of buf, that starts at address, calling emit with the extractor every time a string
+// ends. It's emit's responsibility to empty chars.
+func (e *stringExtractor) extract(address uintptr, buf []byte, emit func(e *stringExtractor)) {
+	if e.encoding == ASCII {
+		for i, c := range buf {
+			e.add(address+uintptr(i), 1, c, isPrintable(c), emit)
+		}
+		return
+	}
+
+	for i, b := range buf {
+		a := address + uintptr(i)
+		if a%2 == 0 {
+			e.pending, e.pendingAddress, e.hasPending = b, a, true
+			continue
+		}
+		if !e.hasPending || e.pendingAddress != a-1 {
+			continue
+		}
+		e.hasPending = false
+
+		low, high := e.pending, b
+		if e.encoding == UTF16BE {
+			low, high = high, low
+		}
+		e.add(a-1, 2, low, high == 0 && isPrintable(low), emit)
+	}
+}
+
+// add adds the character c, which is size bytes long and starts at address, to the current string if it's printable,
+// or ends the string otherwise.
+func (e *stringExtractor) add(address uintptr, size uintptr, c byte, printable bool, emit func(e *stringExtractor)) {
+	if !printable {
+		if len(e.chars) > 0 {
+			emit(e)
+		}
+		return
+	}
+
+	if len(e.chars) > 0 && address != e.next {
+		emit(e)
+	}
+	if len(e.chars) == 0 {
+		e.start = address
+	}
+	e.chars = append(e.chars, c)
+	e.next = address + size
+}
@@ -0,0 +1,82 @@
+package memsearch
+
+import (
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestExtractStrings(t *testing.T) {
+	buf := searchableBuffer(4*4096, 0)
+	start := uintptr(unsafe.Pointer(&buf[0]))
+	end := start + uintptr(len(buf))
+
+	// This one crosses the boundary between two of the buffers used to walk the memory, which are aligned to pages.
+	crossing := int(4096 - start%4096 + 4096 - 10)
+
+	expected := map[int]ExtractedString{
+		64:       {Value: "Hello, world!\tTabs are printable", Encoding: ASCII},
+		crossing: {Value: "This string crosses a boundary", Encoding: ASCII},
+		12000:    {Value: "Wide string", Encoding: UTF16LE},
+	}
+	copy(buf[16:], "abc")
+	for offset, s := range expected {
+		if s.Encoding == ASCII {
+			copy(buf[offset:], s.Value)
+			continue
+		}
+		for i, c := range []byte(s.Value) {
+			buf[offset+2*i] = c
+		}
+	}
+
+	proc, softerrors, err := process.OpenFromPid(uint(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	opts := ExtractOptions{Encodings: ASCII | UTF16LE, Filter: memaccess.RegionsInRange(start, end)}
+	found := make(map[int]ExtractedString)
+	softerrors, err = ExtractStrings(proc, opts, func(s ExtractedString) (keepExtracting bool) {
+		if s.Address >= start && s.Address < end {
+			found[int(s.Address-start)] = s
+			if !s.Mapping.Contains(s.Address) {
+				t.Errorf("String at %x is not in its mapping %v", s.Address, s.Mapping)
+			}
+		}
+		// The mapping is the heap, where the strings extracted are allocated, so it could keep growing ahead of us.
+		// Once a string starts a whole buffer past the end, every buffer with bytes of buf has been read.
+		return s.Address < end+uintptr(minWindowSize)
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != len(expected) {
+		t.Errorf("Expected %d strings and got %+v", len(expected), found)
+	}
+	for offset, s := range expected {
+		if found[offset].Value != s.Value || found[offset].Encoding != s.Encoding {
+			t.Errorf("Expected %q in %v at offset %d and got %+v", s.Value, s.Encoding, offset, found[offset])
+		}
+	}
+
+	extracted := 0
+	softerrors, err = ExtractStrings(proc, opts, func(s ExtractedString) (keepExtracting bool) {
+		extracted++
+		return false
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extracted != 1 {
+		t.Errorf("The extraction should stop after the first string, but %d were extracted", extracted)
+	}
+}
@@ -0,0 +1,507 @@
+package memsearch
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HexPattern is a Matcher for byte patterns written in hex, with wildcards, jumps and alternatives, e.g.
+// "4D 5A ?? ?? [4-16] 50 45 00 00 (6A|68)". The syntax is:
+//
+//   - A byte is written as two hex digits, and either of them can be replaced by a "?" wildcard that matches any
+//     nibble, e.g. "4D", "4?" or "??". Bytes can be separated by whitespace, but it isn't required.
+//   - A jump "[n-m]" matches from n to m bytes of any value, and "[n]" exactly n. A pattern can't start or end with a
+//     jump, and jumps can't be longer than MaxHexJump.
+//   - An alternation group "(a|b|...)" matches any of its alternatives, which can contain any of the above and other
+//     groups, but can't be empty.
+//
+// Jumps match as few bytes as possible, and alternatives are tried in order, so the first match found at an address is
+// reported. The search takes time proportional to the length of the pattern times the length of the memory searched,
+// however many jumps and groups the pattern has.
+type HexPattern struct {
+	source    string
+	nodes     []hexNode
+	program   []hexInstr
+	maxLength int
+}
+
+// MaxHexJump is the longest jump allowed in a HexPattern.
+const MaxHexJump = 1 << 16
+
+// HexPatternError is returned by ParseHexPattern when the pattern is not valid. Column is the 1-based position of the
+// character where the error was found.
+type HexPatternError struct {
+	Pattern string
+	Column  int
+	Msg     string
+}
+
+func (err *HexPatternError) Error() string {
+	return fmt.Sprintf("Invalid hex pattern at column %d: %s", err.Column, err.Msg)
+}
+
+// hexNode is one of hexByte, hexJump or hexAlternation.
+type hexNode interface{}
+
+// hexByte matches a byte b for which b&mask == value.
+type hexByte struct {
+	value byte
+	mask  byte
+}
+
+// hexJump matches from min to max bytes of any value.
+type hexJump struct {
+	min, max int
+}
+
+// hexAlternation matches any of its alternatives.
+type hexAlternation struct {
+	alternatives [][]hexNode
+}
+
+// ParseHexPattern parses a HexPattern. If it's not valid the error returned is a *HexPatternError.
+func ParseHexPattern(pattern string) (*HexPattern, error) {
+	parser := &hexParser{src: pattern}
+	nodes, err := parser.parseSequence(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, parser.errorAt(len(pattern), "Empty pattern")
+	}
+	if _, ok := nodes[0].(hexJump); ok {
+		return nil, parser.errorAt(parser.firstColumn, "A pattern can't start with a jump")
+	}
+	if _, ok := nodes[len(nodes)-1].(hexJump); ok {
+		return nil, parser.errorAt(parser.lastColumn, "A pattern can't end with a jump")
+	}
+
+	if minNodesLength(nodes) == 0 {
+		return nil, parser.errorAt(0, "The pattern can match an empty sequence of bytes")
+	}
+
+	program := compileHexNodes(nodes, nil)
+	program = append(program, hexInstr{op: hexOpMatch})
+	return &HexPattern{source: pattern, nodes: nodes, program: program, maxLength: maxNodesLength(nodes)}, nil
+}
+
+// MustParseHexPattern works as ParseHexPattern, but it panics if the pattern is not valid. It's meant to be used to
+// initialize global variables.
+func MustParseHexPattern(pattern string) *HexPattern {
+	h, err := ParseHexPattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// String returns the source of the pattern.
+func (h *HexPattern) String() string {
+	return h.source
+}
+
+// MaxLength returns the length of the longest sequence of bytes the pattern can match.
+func (h *HexPattern) MaxLength() int {
+	return h.maxLength
+}
+
+// FindAllIndex returns the [start, end) indexes of up to n matches of the pattern in b, sorted by their start index.
+// As with Literal, overlapping matches are reported too, but only one per start index. If n is negative all the matches
+// are returned.
+func (h *HexPattern) FindAllIndex(b []byte, n int) [][]int {
+	var locs [][]int
+	m := newHexMatcher(h.program, b)
+
+	// If the first byte is fully known we can skip to its occurrences.
+	first, fixedFirst := h.nodes[0].(hexByte)
+	fixedFirst = fixedFirst && first.mask == 0xff
+
+	for start := 0; start < len(b) && (n < 0 || len(locs) < n); start++ {
+		if fixedFirst {
+			i := bytes.IndexByte(b[start:], first.value)
+			if i == -1 {
+				break
+			}
+			start += i
+		}
+
+		if end := m.run(0, start); end >= 0 {
+			locs = append(locs, []int{start, end})
+		}
+	}
+
+	return locs
+}
+
+// hexOp is the operation of a hexInstr.
+type hexOp int
+
+const (
+	// hexOpByte matches a byte and continues with the next instruction.
+	hexOpByte hexOp = iota
+	// hexOpJump skips from min to max bytes and continues with the next instruction.
+	hexOpJump
+	// hexOpSplit tries each of its targets in order, one per alternative of a group.
+	hexOpSplit
+	// hexOpGoto continues at its target, the end of a group.
+	hexOpGoto
+	// hexOpMatch ends the match.
+	hexOpMatch
+)
+
+// hexInstr is an instruction of the program a HexPattern is compiled to.
+type hexInstr struct {
+	op      hexOp
+	byte    hexByte
+	jump    hexJump
+	targets []int
+}
+
+// compileHexNodes appends the instructions that match nodes to program. Instructions only go forward, so the program
+// always ends.
+func compileHexNodes(nodes []hexNode, program []hexInstr) []hexInstr {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case hexByte:
+			program = append(program, hexInstr{op: hexOpByte, byte: n})
+
+		case hexJump:
+			program = append(program, hexInstr{op: hexOpJump, jump: n})
+
+		case hexAlternation:
+			split := len(program)
+			program = append(program, hexInstr{op: hexOpSplit})
+			var gotos []int
+			for _, alternative := range n.alternatives {
+				program[split].targets = append(program[split].targets, len(program))
+				program = compileHexNodes(alternative, program)
+				gotos = append(gotos, len(program))
+				program = append(program, hexInstr{op: hexOpGoto})
+			}
+			for _, g := range gotos {
+				program[g].targets = []int{len(program)}
+			}
+		}
+	}
+	return program
+}
+
+// hexState is the position in b at which an instruction of the program is run.
+type hexState struct {
+	pc, pos int
+}
+
+// hexNext is the first position at or after the one of a state where the program matches from the state's instruction,
+// and the end of that match. If at is -1, there's no match up to checked.
+type hexNext struct {
+	at, end, checked int
+}
+
+// hexMatcher runs the program of a HexPattern on a buffer. How a match continues only depends on the instruction and
+// the position it's at, so the results of the states several paths lead to, the ones after a jump or a group, are
+// remembered. Each state is then run at most once per buffer, and the cost of a search is bounded by the length of the
+// program times the length of the buffer, however the jumps and groups are nested.
+type hexMatcher struct {
+	program []hexInstr
+	b       []byte
+	// ends has the end of the match from the states after a group, or -1 if there's none.
+	ends map[hexState]int
+	// next has, for the states after a jump, the first position from which the rest of the program matches.
+	next map[hexState]hexNext
+}
+
+func newHexMatcher(program []hexInstr, b []byte) *hexMatcher {
+	return &hexMatcher{program: program, b: b, ends: make(map[hexState]int), next: make(map[hexState]hexNext)}
+}
+
+// run returns the end of the match of the program from the instruction pc at pos, or -1 if there's none. As the
+// program is run in order, it's the first match found trying the shortest jumps and the alternatives in order.
+func (m *hexMatcher) run(pc, pos int) int {
+	for {
+		instr := &m.program[pc]
+		switch instr.op {
+		case hexOpByte:
+			if pos >= len(m.b) || m.b[pos]&instr.byte.mask != instr.byte.value {
+				return -1
+			}
+			pc++
+			pos++
+
+		case hexOpJump:
+			return m.firstMatch(pc+1, pos+instr.jump.min, pos+instr.jump.max)
+
+		case hexOpSplit:
+			for _, target := range instr.targets {
+				if end := m.run(target, pos); end >= 0 {
+					return end
+				}
+			}
+			return -1
+
+		case hexOpGoto:
+			s := hexState{instr.targets[0], pos}
+			end, ok := m.ends[s]
+			if !ok {
+				end = m.run(s.pc, s.pos)
+				m.ends[s] = end
+			}
+			return end
+
+		default:
+			return pos
+		}
+	}
+}
+
+// firstMatch returns the end of the match of the program from the instruction pc at the first position from from to
+// to where there's one, or -1 if there's none. The positions checked remember what was found, so the overlapping
+// ranges of the other starts don't check them again.
+func (m *hexMatcher) firstMatch(pc, from, to int) int {
+	if to > len(m.b) {
+		to = len(m.b)
+	}
+
+	var visited []int
+	found := hexNext{at: -1}
+	pos := from
+	for pos <= to {
+		if next, ok := m.next[hexState{pc, pos}]; ok {
+			visited = append(visited, pos)
+			if next.at >= 0 {
+				found = next
+				break
+			}
+			pos = next.checked + 1
+			continue
+		}
+
+		visited = append(visited, pos)
+		if end := m.run(pc, pos); end >= 0 {
+			found = hexNext{at: pos, end: end}
+			break
+		}
+		pos++
+	}
+	if found.at == -1 {
+		found.checked = pos - 1
+	}
+
+	for _, v := range visited {
+		m.next[hexState{pc, v}] = found
+	}
+	if found.at == -1 || found.at > to {
+		return -1
+	}
+	return found.end
+}
+
+// maxNodesLength returns the length of the longest sequence of bytes nodes can match.
+func maxNodesLength(nodes []hexNode) (length int) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case hexByte:
+			length++
+		case hexJump:
+			length += n.max
+		case hexAlternation:
+			longest := 0
+			for _, alternative := range n.alternatives {
+				if l := maxNodesLength(alternative); l > longest {
+					longest = l
+				}
+			}
+			length += longest
+		}
+	}
+	return length
+}
+
+// minNodesLength returns the length of the shortest sequence of bytes nodes can match.
+func minNodesLength(nodes []hexNode) (length int) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case hexByte:
+			length++
+		case hexJump:
+			length += n.min
+		case hexAlternation:
+			shortest := -1
+			for _, alternative := range n.alternatives {
+				if l := minNodesLength(alternative); shortest == -1 || l < shortest {
+					shortest = l
+				}
+			}
+			length += shortest
+		}
+	}
+	return length
+}
+
+// hexParser is a recursive descent parser for HexPatterns.
+type hexParser struct {
+	src string
+	pos int
+
+	// firstColumn and lastColumn are the columns of the first and last nodes of the last sequence parsed.
+	firstColumn int
+	lastColumn  int
+}
+
+func (p *hexParser) errorAt(pos int, format string, args ...interface{}) error {
+	return &HexPatternError{Pattern: p.src, Column: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *hexParser) skipSpaces() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// parseSequence parses nodes until the end of the pattern or, if inGroup is true, until a "|" or ")", which are not
+// consumed.
+func (p *hexParser) parseSequence(inGroup bool) ([]hexNode, error) {
+	nodes := make([]hexNode, 0)
+	firstColumn := -1
+	lastColumn := -1
+
+	for {
+		p.skipSpaces()
+		if p.pos == len(p.src) {
+			break
+		}
+
+		start := p.pos
+		var node hexNode
+		var err error
+
+		switch c := p.src[p.pos]; c {
+		case '|', ')':
+			if !inGroup {
+				return nil, p.errorAt(p.pos, "Unexpected %q outside of a group", c)
+			}
+			p.firstColumn, p.lastColumn = firstColumn, lastColumn
+			return nodes, nil
+		case '[':
+			node, err = p.parseJump()
+		case '(':
+			node, err = p.parseGroup()
+		default:
+			node, err = p.parseByte()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if firstColumn == -1 {
+			firstColumn = start
+		}
+		lastColumn = start
+	}
+
+	p.firstColumn, p.lastColumn = firstColumn, lastColumn
+	return nodes, nil
+}
+
+func (p *hexParser) parseByte() (hexNode, error) {
+	var b hexByte
+	for i := 0; i < 2; i++ {
+		if p.pos == len(p.src) {
+			return nil, p.errorAt(p.pos, "Incomplete byte, expected another hex digit or ?")
+		}
+
+		c := p.src[p.pos]
+		shift := uint(4 * (1 - i))
+		switch {
+		case c == '?':
+		case '0' <= c && c <= '9':
+			b.value |= (c - '0') << shift
+			b.mask |= 0xf << shift
+		case 'a' <= c && c <= 'f':
+			b.value |= (c - 'a' + 10) << shift
+			b.mask |= 0xf << shift
+		case 'A' <= c && c <= 'F':
+			b.value |= (c - 'A' + 10) << shift
+			b.mask |= 0xf << shift
+		default:
+			if i == 0 {
+				return nil, p.errorAt(p.pos, "Unexpected %q, expected a hex digit, ?, [ or (", c)
+			}
+			return nil, p.errorAt(p.pos, "Incomplete byte, expected another hex digit or ? and found %q", c)
+		}
+		p.pos++
+	}
+	return b, nil
+}
+
+func (p *hexParser) parseJump() (hexNode, error) {
+	open := p.pos
+	end := open + 1
+	for end < len(p.src) && p.src[end] != ']' {
+		end++
+	}
+	if end == len(p.src) {
+		return nil, p.errorAt(open, "Unclosed jump")
+	}
+
+	body := p.src[open+1 : end]
+	minStr, maxStr := body, body
+	if dash := strings.IndexByte(body, '-'); dash != -1 {
+		minStr, maxStr = body[:dash], body[dash+1:]
+	}
+
+	min, err := strconv.Atoi(minStr)
+	if err != nil || min < 0 {
+		return nil, p.errorAt(open+1, "Invalid jump %q, expected [n-m] or [n]", "["+body+"]")
+	}
+	max, err := strconv.Atoi(maxStr)
+	if err != nil || max < 0 {
+		return nil, p.errorAt(open+1, "Invalid jump %q, expected [n-m] or [n]", "["+body+"]")
+	}
+	if max < min {
+		return nil, p.errorAt(open+1, "Invalid jump %q, its maximum is smaller than its minimum", "["+body+"]")
+	}
+	if max > MaxHexJump {
+		return nil, p.errorAt(open+1, "Jump %q is longer than %d bytes", "["+body+"]", MaxHexJump)
+	}
+
+	p.pos = end + 1
+	return hexJump{min: min, max: max}, nil
+}
+
+func (p *hexParser) parseGroup() (hexNode, error) {
+	open := p.pos
+	p.pos++
+
+	var group hexAlternation
+	for {
+		altStart := p.pos
+		alternative, err := p.parseSequence(true)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.pos == len(p.src) {
+			return nil, p.errorAt(open, "Unclosed group")
+		}
+		if len(alternative) == 0 {
+			return nil, p.errorAt(altStart, "Empty alternative")
+		}
+		group.alternatives = append(group.alternatives, alternative)
+
+		// parseSequence stops at a "|" or a ")".
+		c := p.src[p.pos]
+		p.pos++
+		if c == ')' {
+			return group, nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package memsearch
+
+import (
+	"errors"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"testing"
+)
+
+func TestHexPatternFindAllIndex(t *testing.T) {
+	buf := []byte{0x4d, 0x5a, 0x90, 0x00, 0x01, 0x02, 0x03, 0x04, 0x50, 0x45, 0x00, 0x00, 0x68, 0x4d, 0x5a, 0x6a}
+
+	cases := []struct {
+		pattern  string
+		expected [][]int
+	}{
+		{"4D 5A", [][]int{{0, 2}, {13, 15}}},
+		{"4d5a", [][]int{{0, 2}, {13, 15}}},
+		{"4D 5A ?? ?? [4-16] 50 45 00 00 (6A|68)", [][]int{{0, 13}}},
+		{"4D 5A [0-2] (6A|68)", [][]int{{13, 16}}},
+		{"?D ?A", [][]int{{0, 2}, {13, 15}}},
+		{"5? 4?", [][]int{{8, 10}}},
+		{"(00 00|00 01) ??", [][]int{{3, 6}, {10, 13}}},
+		{"((6A|68)|4D) (5A|4D)", [][]int{{0, 2}, {12, 14}, {13, 15}}},
+		{"00 [1] 02", [][]int{{3, 6}}},
+		{"45 [0-1] 00", [][]int{{9, 11}}},
+		{"(4D|4D 5A) 90", [][]int{{0, 3}}},
+		{"(4D|00 [0-1]) (00|02) [0-4] 50", [][]int{{3, 9}}},
+		{"01 02 03 05", nil},
+	}
+
+	for _, c := range cases {
+		h, err := ParseHexPattern(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		locs := h.FindAllIndex(buf, -1)
+		if len(locs) != len(c.expected) {
+			t.Errorf("Pattern %q: expected matches %v and got %v", c.pattern, c.expected, locs)
+			continue
+		}
+		for i := range locs {
+			if locs[i][0] != c.expected[i][0] || locs[i][1] != c.expected[i][1] {
+				t.Errorf("Pattern %q: expected matches %v and got %v", c.pattern, c.expected, locs)
+				break
+			}
+		}
+	}
+
+	if h := MustParseHexPattern("4D 5A [2-4] (01|02 03)"); h.MaxLength() != 8 {
+		t.Error("Expected a maximum length of 8 and got", h.MaxLength())
+	}
+}
+
+func TestHexPatternLongJumps(t *testing.T) {
+	// Backtracking over every combination of the jumps would take about 2^32 steps per start.
+	h := MustParseHexPattern("AA [0-65536] BB [0-65536] CC")
+	buf := make([]byte, 1<<16)
+	for i := range buf {
+		buf[i] = 0xaa + byte(i%2)*0x11
+	}
+
+	if locs := h.FindAllIndex(buf, -1); len(locs) != 0 {
+		t.Errorf("Expected no matches and got %d", len(locs))
+	}
+
+	buf[len(buf)-1] = 0xcc
+	locs := h.FindAllIndex(buf, -1)
+	if len(locs) != len(buf)/2-1 {
+		t.Fatalf("Expected %d matches and got %d", len(buf)/2-1, len(locs))
+	}
+	for i, loc := range locs {
+		if loc[0] != 2*i || loc[1] != len(buf) {
+			t.Fatalf("Expected a match at [%d, %d) and got %v", 2*i, len(buf), loc)
+		}
+	}
+}
+
+func TestParseHexPatternErrors(t *testing.T) {
+	cases := []struct {
+		pattern string
+		column  int
+	}{
+		{"", 1},
+		{"   ", 4},
+		{"4D 5", 5},
+		{"4D 5Z", 5},
+		{"4D ZZ", 4},
+		{"[2] 4D", 1},
+		{"4D [2]", 4},
+		{"4D [2-1] 5A", 5},
+		{"4D [2-a] 5A", 5},
+		{"4D [2 5A", 4},
+		{"4D [100000] 5A", 5},
+		{"4D (5A|6A", 4},
+		{"4D (5A||6A)", 8},
+		{"4D () 5A", 5},
+		{"4D 5A)", 6},
+		{"4D | 5A", 4},
+		{"([0]|4D)", 1},
+	}
+
+	for _, c := range cases {
+		_, err := ParseHexPattern(c.pattern)
+
+		var patternErr *HexPatternError
+		if !errors.As(err, &patternErr) {
+			t.Errorf("Pattern %q: expected a *HexPatternError and got %v", c.pattern, err)
+			continue
+		}
+		if patternErr.Column != c.column {
+			t.Errorf("Pattern %q: expected an error at column %d and got %v", c.pattern, c.column, err)
+		}
+	}
+}
+
+func TestHexPatternSearchInOtherProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// Each pattern must be found where its known bytes sequence is.
+	cases := []struct {
+		pattern string
+		known   []byte
+	}{
+		{"0C 0A ?F 0E", buffersToFind[0]},
+		{"0D 0E [2-4] 0E 0F", buffersToFind[1]},
+		{"(0B|0C) 0E (FF|0B) 0E 0F [1] 00", buffersToFind[2]},
+		{"55 6E (20 64 69 61|20 6E 6F 63 68 65) [10-40] 75 6E 69 66 6F 72 6D 65", []byte(regexpToMatch[0])},
+	}
+
+	for _, c := range cases {
+		found, address, softerrors, err := FindMatch(proc, 0, MustParseHexPattern(c.pattern))
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, expected, softerrors, err := FindBytesSequence(proc, 0, c.known)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !found || address != expected {
+			t.Errorf("Pattern %q: expected a match at %x and got found=%v at %x", c.pattern, expected, found, address)
+		}
+	}
+
+	found, _, softerrors, err := FindMatch(proc, 0, MustParseHexPattern("0C 0A ?F [0-8] 0E 0F 0A 0B 0C"))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("Found a pattern that shouldn't be in the process")
+	}
+}
@@ -0,0 +1,58 @@
+package memsearch
+
+import (
+	"bytes"
+)
+
+// Matcher is the interface implemented by the patterns that can be searched in the memory of a process. A
+// *regexp.Regexp is a Matcher, and it's matched in the memory as is, not interpreting it as any charset in particular.
+type Matcher interface {
+	// FindAllIndex returns the [start, end) indexes of up to n successive matches in b, sorted by their start index. If
+	// n is negative all the matches are returned.
+	FindAllIndex(b []byte, n int) [][]int
+}
+
+// maxLengthMatcher is implemented by the Matchers that know the maximum length of their matches. Matches of up to half
+// of the search window size are always found, so it's used to make the window big enough for them.
+type maxLengthMatcher interface {
+	MaxLength() int
+}
+
+// minWindowSize is the minimum size of the sliding window used to search the memory.
+const minWindowSize = uint(4096)
+
+// windowSize returns the size of the sliding window needed to find the matches of patterns.
+func windowSize(patterns []Matcher) uint {
+	size := minWindowSize
+	for _, pattern := range patterns {
+		if m, ok := pattern.(maxLengthMatcher); ok && 2*uint(m.MaxLength()) > size {
+			size = 2 * uint(m.MaxLength())
+		}
+	}
+	return size
+}
+
+// Literal is a Matcher for a literal bytes sequence. Unlike a regexp it also reports the overlapping occurrences.
+type Literal []byte
+
+// FindAllIndex returns the [start, end) indexes of up to n occurrences of l in b, including overlapping ones.
+func (l Literal) FindAllIndex(b []byte, n int) [][]int {
+	var locs [][]int
+	for offset := 0; offset <= len(b)-len(l) && (n < 0 || len(locs) < n); {
+		i := bytes.Index(b[offset:], l)
+		if i == -1 {
+			break
+		}
+
+		start := offset + i
+		locs = append(locs, []int{start, start + len(l)})
+		offset = start + 1
+	}
+
+	return locs
+}
+
+// MaxLength returns the length of the literal.
+func (l Literal) MaxLength() int {
+	return len(l)
+}
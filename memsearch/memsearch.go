@@ -1,35 +1,57 @@
 package memsearch
 
 import (
-	"bytes"
+	"context"
 	"github.com/mozilla/masche/memaccess"
 	"github.com/mozilla/masche/process"
 	"regexp"
+	"sort"
 )
 
+// Match represents an occurrence of a searched pattern in the memory of a process.
+type Match struct {
+	// Address is the address (in the process address space) where the match starts.
+	Address uintptr
+
+	// Data is a copy of the matched bytes.
+	Data []byte
+
+	// Region is the readable memory region that contains the match.
+	Region memaccess.MemoryRegion
+}
+
+// MatchFunc type represents a function called with each match found by the FindAll* functions. If it returns false
+// the search is stopped.
+type MatchFunc func(m Match) (keepSearching bool)
+
 // FindBytesSequence finds for the first occurrence of needle in the Process starting at a given address (in the
 // process address space). If the needle is found the first argument will be true and the second one will contain it's
 // address.
 func FindBytesSequence(p process.Process, address uintptr, needle []byte) (found bool, foundAddress uintptr,
 	softerrors []error, harderror error) {
+	return FindBytesSequenceFiltered(p, address, nil, needle)
+}
 
-	const minBufferSize = uint(4096)
-	bufferSize := minBufferSize
-	if uint(len(needle)) > bufferSize {
-		bufferSize = uint(len(needle))
-	}
+// FindBytesSequenceFiltered works as FindBytesSequence, but it only searches in the mappings accepted by filter.
+func FindBytesSequenceFiltered(p process.Process, address uintptr, filter memaccess.RegionFilter, needle []byte) (
+	found bool, foundAddress uintptr, softerrors []error, harderror error) {
 
-	foundAddress = uintptr(0)
-	found = false
-	softerrors, harderror = memaccess.SlidingWalkMemory(p, address, bufferSize,
-		func(address uintptr, buf []byte) (keepSearching bool) {
-			i := bytes.Index(buf, needle)
-			if i == -1 {
-				return true
-			}
+	found, foundAddress, _, softerrors, harderror = FindBytesSequenceContext(context.Background(), p, address, filter,
+		needle)
+	return
+}
 
-			foundAddress = address + uintptr(i)
+// FindBytesSequenceContext works as FindBytesSequenceFiltered, but it stops once ctx is done, returning ctx.Err() as
+// the hard error. If filter is nil every readable mapping is searched.
+//
+// lastAddress is the address up to which the memory has been searched, see FindAllBytesSequencesContext.
+func FindBytesSequenceContext(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	needle []byte) (found bool, foundAddress uintptr, lastAddress uintptr, softerrors []error, harderror error) {
+
+	lastAddress, softerrors, harderror = FindAllBytesSequencesContext(ctx, p, address, filter, needle, 1,
+		func(m Match) (keepSearching bool) {
 			found = true
+			foundAddress = m.Address
 			return false
 		})
 	return
@@ -40,22 +62,277 @@ func FindBytesSequence(p process.Process, address uintptr, needle []byte) (found
 // as is, not interpreting it as any charset in particular.
 func FindRegexpMatch(p process.Process, address uintptr, r *regexp.Regexp) (found bool, foundAddress uintptr,
 	softerrors []error, harderror error) {
+	return FindRegexpMatchFiltered(p, address, nil, r)
+}
 
-	const bufferSize = uint(4096)
+// FindRegexpMatchFiltered works as FindRegexpMatch, but it only searches in the mappings accepted by filter.
+func FindRegexpMatchFiltered(p process.Process, address uintptr, filter memaccess.RegionFilter, r *regexp.Regexp) (
+	found bool, foundAddress uintptr, softerrors []error, harderror error) {
 
-	foundAddress = uintptr(0)
-	found = false
-	softerrors, harderror = memaccess.SlidingWalkMemory(p, address, bufferSize,
-		func(address uintptr, buf []byte) (keepSearching bool) {
-			loc := r.FindIndex(buf)
-			if loc == nil {
-				return true
-			}
+	found, foundAddress, _, softerrors, harderror = FindRegexpMatchContext(context.Background(), p, address, filter, r)
+	return
+}
 
-			foundAddress = address + uintptr(loc[0])
+// FindRegexpMatchContext works as FindRegexpMatchFiltered, but it stops once ctx is done as FindBytesSequenceContext
+// does.
+func FindRegexpMatchContext(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	r *regexp.Regexp) (found bool, foundAddress uintptr, lastAddress uintptr, softerrors []error, harderror error) {
+
+	lastAddress, softerrors, harderror = FindAllRegexpMatchesContext(ctx, p, address, filter, r, 1,
+		func(m Match) (keepSearching bool) {
 			found = true
+			foundAddress = m.Address
 			return false
 		})
+	return
+}
+
+// FindAllBytesSequences finds every occurrence of needle in the Process starting at a given address (in the process
+// address space), calling matchFn with each of them in increasing address order. Overlapping occurrences are all
+// reported, but each address is reported only once.
+//
+// If maxMatches is greater than zero the search stops after finding that many matches.
+func FindAllBytesSequences(p process.Process, address uintptr, needle []byte, maxMatches int,
+	matchFn MatchFunc) (softerrors []error, harderror error) {
+	return FindAllBytesSequencesFiltered(p, address, nil, needle, maxMatches, matchFn)
+}
 
+// FindAllBytesSequencesFiltered works as FindAllBytesSequences, but it only searches in the mappings accepted by
+// filter.
+func FindAllBytesSequencesFiltered(p process.Process, address uintptr, filter memaccess.RegionFilter, needle []byte,
+	maxMatches int, matchFn MatchFunc) (softerrors []error, harderror error) {
+
+	_, softerrors, harderror = FindAllBytesSequencesContext(context.Background(), p, address, filter, needle,
+		maxMatches, matchFn)
+	return
+}
+
+// FindAllBytesSequencesContext works as FindAllBytesSequencesFiltered, but it stops once ctx is done, returning
+// ctx.Err() as the hard error. If filter is nil every readable mapping is searched.
+//
+// lastAddress is the address up to which the memory has been searched: every match starting before it has been
+// reported, so a search resumed from it won't miss or repeat any match.
+func FindAllBytesSequencesContext(ctx context.Context, p process.Process, address uintptr,
+	filter memaccess.RegionFilter, needle []byte, maxMatches int, matchFn MatchFunc) (lastAddress uintptr,
+	softerrors []error, harderror error) {
+
+	return findAll(ctx, p, address, filter, []Matcher{Literal(needle)}, maxMatches, 0, nil, singlePattern(matchFn))
+}
+
+// FindAllRegexpMatches finds every match of r in the process memory starting at a given address, calling matchFn with
+// each of them in increasing address order. It works as FindAllBytesSequences but instead of searching for a literal
+// bytes sequence it uses a regexp, that is matched in the memory as is, as FindRegexpMatch does.
+//
+// Every match of up to DefaultMaxMatchLength bytes is reported exactly once, as FindAllRegexpMatchesOptions explains.
+// Use it to find longer matches.
+//
+// If maxMatches is greater than zero the search stops after finding that many matches.
+func FindAllRegexpMatches(p process.Process, address uintptr, r *regexp.Regexp, maxMatches int,
+	matchFn MatchFunc) (softerrors []error, harderror error) {
+	return FindAllRegexpMatchesFiltered(p, address, nil, r, maxMatches, matchFn)
+}
+
+// FindAllRegexpMatchesFiltered works as FindAllRegexpMatches, but it only searches in the mappings accepted by filter.
+func FindAllRegexpMatchesFiltered(p process.Process, address uintptr, filter memaccess.RegionFilter, r *regexp.Regexp,
+	maxMatches int, matchFn MatchFunc) (softerrors []error, harderror error) {
+
+	_, softerrors, harderror = FindAllRegexpMatchesContext(context.Background(), p, address, filter, r, maxMatches,
+		matchFn)
 	return
 }
+
+// FindAllRegexpMatchesContext works as FindAllRegexpMatchesFiltered, but it stops once ctx is done as
+// FindAllBytesSequencesContext does.
+func FindAllRegexpMatchesContext(ctx context.Context, p process.Process, address uintptr,
+	filter memaccess.RegionFilter, r *regexp.Regexp, maxMatches int, matchFn MatchFunc) (lastAddress uintptr,
+	softerrors []error, harderror error) {
+
+	return findAll(ctx, p, address, filter, []Matcher{r}, maxMatches, 0, nil, singlePattern(matchFn))
+}
+
+// FindMatch finds the first match of m in the process memory starting at a given address. It works as
+// FindBytesSequence, but with any Matcher, like a HexPattern.
+func FindMatch(p process.Process, address uintptr, m Matcher) (found bool, foundAddress uintptr, softerrors []error,
+	harderror error) {
+
+	softerrors, harderror = FindAllMatches(p, address, m, 1, func(match Match) (keepSearching bool) {
+		found = true
+		foundAddress = match.Address
+		return false
+	})
+	return
+}
+
+// FindAllMatches finds every match of m in the process memory starting at a given address, calling matchFn with each
+// of them in increasing address order. It works as FindAllBytesSequences, but with any Matcher.
+//
+// If maxMatches is greater than zero the search stops after finding that many matches.
+func FindAllMatches(p process.Process, address uintptr, m Matcher, maxMatches int, matchFn MatchFunc) (
+	softerrors []error, harderror error) {
+
+	_, softerrors, harderror = FindAllMatchesContext(context.Background(), p, address, nil, m, maxMatches, matchFn)
+	return
+}
+
+// FindAllMatchesContext works as FindAllMatches, but it only searches in the mappings accepted by filter, and it stops
+// once ctx is done as FindAllBytesSequencesContext does. If filter is nil every readable mapping is searched.
+func FindAllMatchesContext(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	m Matcher, maxMatches int, matchFn MatchFunc) (lastAddress uintptr, softerrors []error, harderror error) {
+
+	return findAll(ctx, p, address, filter, []Matcher{m}, maxMatches, 0, nil, singlePattern(matchFn))
+}
+
+// patternMatchFunc type represents a function called by findAll with each match found and the index of the pattern
+// that matched. If it returns false the search is stopped.
+type patternMatchFunc func(pattern int, m Match) (keepSearching bool)
+
+// singlePattern adapts a MatchFunc to be used by findAll with a single pattern.
+func singlePattern(matchFn MatchFunc) patternMatchFunc {
+	return func(pattern int, m Match) (keepSearching bool) {
+		return matchFn(m)
+	}
+}
+
+// findAll walks the memory of the mappings accepted by filter with a sliding window, searching every pattern on each
+// window and calling matchFn with every match found, in increasing address order. The window size is given by
+// windowSize.
+//
+// As the windows overlap by half of their size every match would be found twice. To avoid that only the matches that
+// start in the first half of a window are reported, except for the last window of a region, which doesn't have a
+// following one. This means that every match of up to half the window size is reported exactly once.
+//
+// Other Matchers can report overlapping matches, but regexps don't, so after a match they are resumed at its end. This
+// way their matches are the same they would be if the whole region was searched at once.
+//
+// If maxPerPattern is greater than zero, each pattern stops being searched once it has that many matches, and a
+// *TooManyMatchesError is returned as a soft error when it has more. Those matches aren't counted in maxMatches, so
+// they don't stop the search of the other patterns. If limiter isn't nil it's used to throttle the memory reads.
+//
+// lastAddress is the address up to which every match has been reported.
+func findAll(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	patterns []Matcher, maxMatches int, maxPerPattern int, limiter *rateLimiter, matchFn patternMatchFunc) (
+	lastAddress uintptr, softerrors []error, harderror error) {
+
+	bufferSize := windowSize(patterns)
+	var region memaccess.MemoryRegion
+	var walkHarderror error
+	matches := 0
+	lastAddress = address
+	readUntil := address
+
+	// resumeAt holds the address from which each pattern must be searched, so regexps don't report matches that
+	// overlap the ones reported in the previous window.
+	resumeAt := make([]uintptr, len(patterns))
+
+	// patternMatches counts the matches of each pattern, and exhausted the patterns that have too many.
+	patternMatches := make([]int, len(patterns))
+	exhausted := 0
+	var tooMany []error
+
+	_, softerrors, harderror = memaccess.SlidingWalkMemoryContext(ctx, p, address, bufferSize, filter,
+		func(address uintptr, buf []byte) (keepSearching bool) {
+			// The windows overlap, so we only account for the bytes that weren't in the previous one.
+			end := address + uintptr(len(buf))
+			if readUntil < address {
+				readUntil = address
+			}
+			if walkHarderror = limiter.wait(ctx, int(end-readUntil)); walkHarderror != nil {
+				return false
+			}
+			readUntil = end
+
+			regionEnd := region.Address + uintptr(region.Size)
+			if address < region.Address || address >= regionEnd {
+				// The soft errors found looking for the region were already reported by the walk.
+				region, _, walkHarderror = memaccess.NextFilteredMemoryRegion(p, address, filter)
+				if walkHarderror != nil {
+					return false
+				}
+				regionEnd = region.Address + uintptr(region.Size)
+			}
+
+			limit := len(buf)
+			if uint(len(buf)) == bufferSize && address+uintptr(len(buf)) < regionEnd {
+				limit = int(bufferSize / 2)
+			}
+
+			for _, loc := range findInWindow(patterns, buf, limit, address, resumeAt) {
+				if resumeAt[loc.pattern] == noResume {
+					continue
+				}
+				if maxPerPattern > 0 && patternMatches[loc.pattern] == maxPerPattern {
+					resumeAt[loc.pattern] = noResume
+					tooMany = append(tooMany, &TooManyMatchesError{Pattern: loc.pattern, Max: maxPerPattern})
+					if exhausted++; exhausted == len(patterns) {
+						return false
+					}
+					continue
+				}
+				patternMatches[loc.pattern]++
+
+				m := Match{
+					Address: address + uintptr(loc.start),
+					Data:    append([]byte(nil), buf[loc.start:loc.end]...),
+					Region:  region,
+				}
+				resumeAt[loc.pattern] = m.Address + 1
+				if isRegexp(patterns[loc.pattern]) && loc.end > loc.start {
+					resumeAt[loc.pattern] = m.Address + uintptr(len(m.Data))
+				}
+
+				matches++
+				lastAddress = m.Address + 1
+				if !matchFn(loc.pattern, m) || (maxMatches > 0 && matches >= maxMatches) {
+					return false
+				}
+			}
+
+			lastAddress = address + uintptr(limit)
+			return true
+		})
+
+	if harderror == nil {
+		harderror = walkHarderror
+	}
+	softerrors = append(softerrors, tooMany...)
+	return
+}
+
+// noResume is the resume address of the patterns that mustn't be searched anymore.
+const noResume = ^uintptr(0)
+
+// windowLocation is the location of a match of a pattern in a window.
+type windowLocation struct {
+	pattern    int
+	start, end int
+}
+
+// findInWindow returns the locations of the matches of every pattern in buf that start before limit, sorted by their
+// start index and then by pattern. address is the address of buf, and each pattern is only searched from its resumeAt
+// address on.
+func findInWindow(patterns []Matcher, buf []byte, limit int, address uintptr, resumeAt []uintptr) []windowLocation {
+	var locs []windowLocation
+	for i, pattern := range patterns {
+		from := 0
+		if resumeAt[i] > address {
+			if resumeAt[i]-address >= uintptr(limit) {
+				continue
+			}
+			from = int(resumeAt[i] - address)
+		}
+
+		for _, loc := range pattern.FindAllIndex(buf[from:], -1) {
+			if from+loc[0] >= limit {
+				break
+			}
+			locs = append(locs, windowLocation{pattern: i, start: from + loc[0], end: from + loc[1]})
+		}
+	}
+
+	if len(patterns) > 1 {
+		sort.SliceStable(locs, func(i, j int) bool {
+			return locs[i].start < locs[j].start
+		})
+	}
+	return locs
+}
@@ -0,0 +1,43 @@
+package memsearch
+
+import (
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"testing"
+)
+
+func TestFilteredSearchInOtherProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	proc, softerrors, err := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// The first buffer is in the data segment, while the others are in the stack and the heap.
+	found, _, softerrors, err := FindBytesSequenceFiltered(proc, 0, memaccess.HeapAndStackRegions, buffersToFind[0])
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	} else if found {
+		t.Error("A buffer from the data segment was found in the heap or the stack")
+	}
+
+	for i, buf := range buffersToFind[1:] {
+		found, _, softerrors, err := FindBytesSequenceFiltered(proc, 0, memaccess.HeapAndStackRegions, buf)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		} else if !found {
+			t.Fatalf("Filtered search failed for case %d, the following buffer should be found: %+v", i+1, buf)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package memsearch
 
 import (
+	"bytes"
+	"context"
+	"github.com/mozilla/masche/memaccess"
 	"github.com/mozilla/masche/process"
 	"github.com/mozilla/masche/test"
 	"regexp"
+	"sort"
 	"testing"
 )
 
@@ -110,3 +114,285 @@ func TestRegexpSearchInOtherProcess(t *testing.T) {
 		}
 	}
 }
+
+func TestFindAllInOtherProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	proc, softerrors, err := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	for i, buf := range buffersToFind {
+		_, firstAddress, softerrors, err := FindBytesSequence(proc, 0, buf)
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var matches []Match
+		softerrors, err = FindAllBytesSequences(proc, 0, buf, 0, func(m Match) (keepSearching bool) {
+			matches = append(matches, m)
+			return true
+		})
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(matches) == 0 {
+			t.Fatalf("FindAllBytesSequences failed for case %d, the following buffer should be found: %+v", i, buf)
+		}
+
+		if matches[0].Address != firstAddress {
+			t.Errorf("First match at %x, but FindBytesSequence found it at %x", matches[0].Address, firstAddress)
+		}
+
+		for j, m := range matches {
+			if !bytes.Equal(m.Data, buf) {
+				t.Errorf("Match %d for case %d has data %+v", j, i, m.Data)
+			}
+
+			if m.Address < m.Region.Address || m.Address+uintptr(len(buf)) > m.Region.Address+uintptr(m.Region.Size) {
+				t.Errorf("Match at %x is not contained in its region %v", m.Address, m.Region)
+			}
+
+			if j > 0 && m.Address <= matches[j-1].Address {
+				t.Errorf("Match at %x reported after the one at %x", m.Address, matches[j-1].Address)
+			}
+		}
+	}
+
+	// This must not be present
+	softerrors, err = FindAllBytesSequences(proc, 0, notPresent, 0, func(m Match) (keepSearching bool) {
+		t.Errorf("FindAllBytesSequences found a sequence of bytes that it shouldn't at %x", m.Address)
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single zero byte is everywhere, so the search must stop at maxMatches.
+	const maxMatches = 10
+	count := 0
+	softerrors, err = FindAllBytesSequences(proc, 0, []byte{0}, maxMatches, func(m Match) (keepSearching bool) {
+		count++
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != maxMatches {
+		t.Errorf("Expected %d matches and got %d", maxMatches, count)
+	}
+}
+
+func TestFindAllRegexpMatchesInOtherProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	proc, softerrors, err := process.OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	for i, str := range regexpToMatch {
+		r := regexp.MustCompile(str)
+
+		seen := make(map[uintptr]bool)
+		softerrors, err := FindAllRegexpMatches(proc, 0, r, 0, func(m Match) (keepSearching bool) {
+			if seen[m.Address] {
+				t.Errorf("Match at %x reported twice", m.Address)
+			}
+			seen[m.Address] = true
+
+			if !r.Match(m.Data) {
+				t.Errorf("Reported data %q doesn't match %s", m.Data, str)
+			}
+			return true
+		})
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(seen) == 0 {
+			t.Fatalf("FindAllRegexpMatches failed for case %d, the following regexp should be found: %s", i, str)
+		}
+	}
+}
+
+func TestResumeCancelledSearch(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// A single zero byte is everywhere, so we limit the search to a few matches.
+	const maxMatches = 10000
+	var all []uintptr
+	softerrors, err = FindAllBytesSequences(proc, 0, []byte{0}, maxMatches, func(m Match) (keepSearching bool) {
+		all = append(all, m.Address)
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Search again cancelling every 1000 matches and resuming from where it stopped.
+	var resumed []uintptr
+	address := uintptr(0)
+	for len(resumed) < maxMatches {
+		ctx, cancel := context.WithCancel(context.Background())
+		lastAddress, softerrors, err := FindAllBytesSequencesContext(ctx, proc, address, nil, []byte{0},
+			maxMatches-len(resumed), func(m Match) (keepSearching bool) {
+				resumed = append(resumed, m.Address)
+				if len(resumed)%1000 == 0 {
+					cancel()
+				}
+				return true
+			})
+		cancel()
+		test.PrintSoftErrors(softerrors)
+
+		if err != nil && err != context.Canceled {
+			t.Fatal(err)
+		}
+		if err == nil && len(resumed) < maxMatches {
+			t.Fatal("The search finished before finding", maxMatches, "matches")
+		}
+		address = lastAddress
+	}
+
+	if len(all) != len(resumed) {
+		t.Fatalf("Found %d matches without cancelling and %d resuming", len(all), len(resumed))
+	}
+	for i := range all {
+		if all[i] != resumed[i] {
+			t.Fatalf("Match %d found at %x without cancelling and at %x resuming", i, all[i], resumed[i])
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, _, err := FindRegexpMatchContext(ctx, proc, 0, nil, regexp.MustCompile("a")); err != context.Canceled {
+		t.Error("Expected context.Canceled and got", err)
+	}
+}
+
+func TestFindAnyOf(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// This pattern crosses the boundary between the first two buffers read by the walk in a region.
+	region, softerrors, err := memaccess.NextReadableMemoryRegion(proc, 0)
+	for err == nil && region != memaccess.NoRegionAvailable && region.Size < 8192 {
+		region, softerrors, err = memaccess.NextReadableMemoryRegion(proc, region.Address+uintptr(region.Size))
+	}
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region == memaccess.NoRegionAvailable {
+		t.Fatal("No region big enough found")
+	}
+	crossing := make([]byte, 16)
+	crossingAddress := region.Address + 4096 - 8
+	if _, err := memaccess.CopyMemory(proc, crossingAddress, crossing); err != nil {
+		t.Fatal(err)
+	}
+
+	patterns := append([][]byte{notPresent, crossing, buffersToFind[0][1:]}, buffersToFind...)
+
+	found := make(map[int][]uintptr)
+	softerrors, err = FindAnyOf(proc, 0, patterns, 0, func(m PatternMatch) (keepSearching bool) {
+		if !bytes.Equal(m.Data, patterns[m.Pattern]) {
+			t.Errorf("Match of pattern %d has data %+v", m.Pattern, m.Data)
+		}
+		if m.Address < m.Region.Address || m.Address+uintptr(len(m.Data)) > m.Region.Address+uintptr(m.Region.Size) {
+			t.Errorf("Match at %x is not contained in its region %v", m.Address, m.Region)
+		}
+		found[m.Pattern] = append(found[m.Pattern], m.Address)
+		return true
+	})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found[0]) != 0 {
+		t.Error("FindAnyOf found a sequence of bytes that it shouldn't at", found[0])
+	}
+
+	crossingFound := false
+	for _, address := range found[1] {
+		crossingFound = crossingFound || address == crossingAddress
+	}
+	if !crossingFound {
+		t.Errorf("The pattern at %x wasn't found, found it at %x", crossingAddress, found[1])
+	}
+
+	// Every pattern must be found at the same addresses FindAllBytesSequences finds it.
+	for i, pattern := range patterns[2:] {
+		var expected []uintptr
+		softerrors, err = FindAllBytesSequences(proc, 0, pattern, 0, func(m Match) (keepSearching bool) {
+			expected = append(expected, m.Address)
+			return true
+		})
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := found[i+2]
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if len(got) != len(expected) {
+			t.Fatalf("Pattern %d found %d times and FindAllBytesSequences found it %d times", i+2, len(got),
+				len(expected))
+		}
+		for j := range got {
+			if got[j] != expected[j] {
+				t.Errorf("Pattern %d found at %x and FindAllBytesSequences found it at %x", i+2, got[j], expected[j])
+			}
+		}
+	}
+
+	if _, err := FindAnyOf(proc, 0, [][]byte{needle, {}}, 0, func(m PatternMatch) bool { return true }); err == nil {
+		t.Error("An empty pattern should return an error")
+	}
+}
@@ -0,0 +1,182 @@
+package memsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"regexp"
+)
+
+// DefaultMaxMatchLength is the length of the longest regexp match that the search functions that don't take a
+// RegexpOptions are guaranteed to find.
+const DefaultMaxMatchLength = int(minWindowSize / 2)
+
+// Anchor restricts where the matches of a regexp search can start.
+type Anchor int
+
+const (
+	// Unanchored accepts matches that start anywhere.
+	Unanchored Anchor = iota
+
+	// AnchorRegionStart only accepts matches that start at the start of a memory region, as the ones returned by
+	// memaccess.NextFilteredMemoryRegion.
+	AnchorRegionStart
+
+	// AnchorMappingStart only accepts matches that start at the start of a mapping, e.g. at the headers of the mapped
+	// files.
+	AnchorMappingStart
+)
+
+// RegexpOptions configures a regexp search.
+type RegexpOptions struct {
+	// MaxMatchLength is the length of the longest match that is guaranteed to be found. Longer matches can be
+	// truncated or missed. The memory is searched with a window of twice this size, so the bigger it is the more
+	// memory is used. If it's zero DefaultMaxMatchLength is used.
+	MaxMatchLength int
+
+	// Anchor restricts where the matches can start.
+	Anchor Anchor
+}
+
+// Regexp is a Matcher for a regexp, whose matches of up to MaxMatchLength bytes are guaranteed to be found. A plain
+// *regexp.Regexp is searched as a Regexp with a MaxMatchLength of DefaultMaxMatchLength.
+type Regexp struct {
+	*regexp.Regexp
+	MaxMatchLength int
+}
+
+// MaxLength returns the MaxMatchLength of the regexp.
+func (r *Regexp) MaxLength() int {
+	return r.MaxMatchLength
+}
+
+// isRegexp returns true if m is a regexp, whose matches don't overlap.
+func isRegexp(m Matcher) bool {
+	switch m.(type) {
+	case *regexp.Regexp, *Regexp:
+		return true
+	}
+	return false
+}
+
+// FindRegexpMatchOptions finds the first match of r in the process memory starting at a given address, as
+// FindRegexpMatchContext does, but with the given options.
+func FindRegexpMatchOptions(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	r *regexp.Regexp, opts RegexpOptions) (found bool, foundAddress uintptr, lastAddress uintptr, softerrors []error,
+	harderror error) {
+
+	lastAddress, softerrors, harderror = FindAllRegexpMatchesOptions(ctx, p, address, filter, r, opts, 1,
+		func(m Match) (keepSearching bool) {
+			found = true
+			foundAddress = m.Address
+			return false
+		})
+	return
+}
+
+// FindAllRegexpMatchesOptions finds every match of r in the process memory starting at a given address, as
+// FindAllRegexpMatchesContext does, but with the given options.
+//
+// Every match of up to opts.MaxMatchLength bytes that's contained in a memory region is reported exactly once, and
+// matches are the same they would be if each region was searched as a whole: they don't overlap and each one starts
+// after the end of the previous one. The only exception are the assertions about the surrounding text, like ^, $ and
+// \b, which are evaluated on the windows the memory is searched with, so they also match at their boundaries. Use
+// opts.Anchor instead of ^ to search for matches at the start of a region.
+//
+// If maxMatches is greater than zero the search stops after finding that many matches.
+func FindAllRegexpMatchesOptions(ctx context.Context, p process.Process, address uintptr,
+	filter memaccess.RegionFilter, r *regexp.Regexp, opts RegexpOptions, maxMatches int, matchFn MatchFunc) (
+	lastAddress uintptr, softerrors []error, harderror error) {
+
+	maxMatchLength := opts.MaxMatchLength
+	if maxMatchLength == 0 {
+		maxMatchLength = DefaultMaxMatchLength
+	}
+	if maxMatchLength < 0 {
+		return address, nil, fmt.Errorf("Invalid MaxMatchLength %d", opts.MaxMatchLength)
+	}
+
+	switch opts.Anchor {
+	case Unanchored:
+		return findAll(ctx, p, address, filter, []Matcher{&Regexp{Regexp: r, MaxMatchLength: maxMatchLength}},
+			maxMatches, 0, nil, singlePattern(matchFn))
+	case AnchorRegionStart, AnchorMappingStart:
+		return findAnchored(ctx, p, address, filter, r, maxMatchLength, opts.Anchor, maxMatches, matchFn)
+	}
+	return address, nil, fmt.Errorf("Invalid Anchor %d", opts.Anchor)
+}
+
+// findAnchored searches the matches of r that start at the start of the regions or mappings accepted by filter, as
+// selected by anchor. Only the first maxMatchLength bytes from each of them are read.
+func findAnchored(ctx context.Context, p process.Process, address uintptr, filter memaccess.RegionFilter,
+	r *regexp.Regexp, maxMatchLength int, anchor Anchor, maxMatches int, matchFn MatchFunc) (lastAddress uintptr,
+	softerrors []error, harderror error) {
+
+	var mappings []memaccess.Mapping
+	if anchor == AnchorMappingStart {
+		if mappings, softerrors, harderror = memaccess.ListMappings(p); harderror != nil {
+			return address, softerrors, harderror
+		}
+	}
+
+	matches := 0
+	lastAddress = address
+	for {
+		region, serrs, err := memaccess.NextFilteredMemoryRegion(p, lastAddress, filter)
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			return lastAddress, softerrors, err
+		}
+		if region == memaccess.NoRegionAvailable {
+			return lastAddress, softerrors, nil
+		}
+		regionEnd := region.Address + uintptr(region.Size)
+
+		anchors := []uintptr{region.Address}
+		if anchor == AnchorMappingStart {
+			anchors = nil
+			for _, m := range mappings {
+				if m.Address >= region.Address && m.Address < regionEnd {
+					anchors = append(anchors, m.Address)
+				}
+			}
+		}
+
+		for _, start := range anchors {
+			if start < lastAddress {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return lastAddress, softerrors, err
+			}
+
+			buf := make([]byte, maxMatchLength)
+			if regionEnd-start < uintptr(len(buf)) {
+				buf = buf[:regionEnd-start]
+			}
+
+			serrs, err := memaccess.CopyMemory(p, start, buf)
+			softerrors = append(softerrors, serrs...)
+			lastAddress = start + 1
+			if err != nil {
+				softerrors = append(softerrors, err)
+				continue
+			}
+
+			// As regexps prefer the leftmost match, if there's one at the start of buf it's the one found.
+			loc := r.FindIndex(buf)
+			if loc == nil || loc[0] != 0 {
+				continue
+			}
+
+			matches++
+			m := Match{Address: start, Data: append([]byte(nil), buf[:loc[1]]...), Region: region}
+			if !matchFn(m) || (maxMatches > 0 && matches >= maxMatches) {
+				return lastAddress, softerrors, nil
+			}
+		}
+
+		lastAddress = regionEnd
+	}
+}
@@ -0,0 +1,173 @@
+package memsearch
+
+import (
+	"context"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// searchableBuffers keeps the buffers returned by searchableBuffer in the heap.
+var searchableBuffers [][]byte
+
+// searchableBuffer returns a buffer of this process filled with fill, for the tests that search a memory whose contents
+// they know. It's kept in the heap, as the stack can be moved while it's being searched.
+func searchableBuffer(size int, fill byte) []byte {
+	buf := make([]byte, size)
+	for i := range buf {
+		buf[i] = fill
+	}
+	searchableBuffers = append(searchableBuffers, buf)
+	return buf
+}
+
+func TestRegexpMaxMatchLength(t *testing.T) {
+	buf := searchableBuffer(64*1024, '.')
+	long := "<<" + strings.Repeat("x", 3996) + ">>"
+	copy(buf[1000:], long)
+	copy(buf[20000:], strings.Repeat("W", 5000))
+
+	start := uintptr(unsafe.Pointer(&buf[0]))
+	end := start + uintptr(len(buf))
+
+	proc, softerrors, err := process.OpenFromPid(uint(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// findInBuf returns the offsets in buf and the lengths of the matches of r.
+	findInBuf := func(r *regexp.Regexp, opts RegexpOptions) (offsets []int, lengths []int) {
+		_, softerrors, err := FindAllRegexpMatchesOptions(context.Background(), proc, 0,
+			memaccess.RegionsInRange(start, end), r, opts, 0, func(m Match) (keepSearching bool) {
+				// The memory of the regexps themselves can match too, so we only look at buf.
+				if m.Address >= start && m.Address < end {
+					offsets = append(offsets, int(m.Address-start))
+					lengths = append(lengths, len(m.Data))
+				}
+				return true
+			})
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	offsets, lengths := findInBuf(regexp.MustCompile("<<x+>>"), RegexpOptions{MaxMatchLength: len(long)})
+	if len(offsets) != 1 || offsets[0] != 1000 || lengths[0] != len(long) {
+		t.Errorf("Expected a match at offset 1000 of %d bytes, and got them at %v with lengths %v", len(long),
+			offsets, lengths)
+	}
+
+	// The windows used to search split the run of Ws in different places, but the matches must be the same ones
+	// found searching the whole buffer.
+	r := regexp.MustCompile("\\x57{1,900}")
+	expected := r.FindAllIndex(buf, -1)
+	for _, maxMatchLength := range []int{0, 900, 3000} {
+		offsets, lengths = findInBuf(r, RegexpOptions{MaxMatchLength: maxMatchLength})
+		if len(offsets) != len(expected) {
+			t.Errorf("MaxMatchLength %d: expected matches %v and got them at %v with lengths %v", maxMatchLength,
+				expected, offsets, lengths)
+			continue
+		}
+		for i := range offsets {
+			if offsets[i] != expected[i][0] || offsets[i]+lengths[i] != expected[i][1] {
+				t.Errorf("MaxMatchLength %d: expected matches %v and got them at %v with lengths %v",
+					maxMatchLength, expected, offsets, lengths)
+				break
+			}
+		}
+	}
+}
+
+func TestRegexpAnchors(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	mappings, softerrors, err := memaccess.ListMappings(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappingStarts := make(map[uintptr]bool)
+	for _, m := range mappings {
+		mappingStarts[m.Address] = true
+	}
+
+	// The executable of the test process is mapped from its ELF header.
+	elf := regexp.MustCompile("\\x7fELF")
+	found := 0
+	_, softerrors, err = FindAllRegexpMatchesOptions(context.Background(), proc, 0, nil, elf,
+		RegexpOptions{Anchor: AnchorMappingStart}, 0, func(m Match) (keepSearching bool) {
+			if !mappingStarts[m.Address] {
+				t.Errorf("Match at %x is not at the start of a mapping", m.Address)
+			}
+			found++
+			return true
+		})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == 0 {
+		t.Error("No ELF header found at the start of a mapping")
+	}
+
+	region, softerrors, err := memaccess.NextReadableMemoryRegion(proc, 0)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 4)
+	if _, err := memaccess.CopyMemory(proc, region.Address, header); err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "\x7fELF" {
+		t.Skip("The first region doesn't start with an ELF header")
+	}
+
+	cases := []struct {
+		r             string
+		opts          RegexpOptions
+		expected      bool
+		expectedAddr  uintptr
+		expectedError bool
+	}{
+		{"\\x7fELF", RegexpOptions{Anchor: AnchorRegionStart}, true, region.Address, false},
+		{"ELF", RegexpOptions{Anchor: AnchorRegionStart}, false, 0, false},
+		{"ELF", RegexpOptions{}, true, region.Address + 1, false},
+		{"ELF", RegexpOptions{MaxMatchLength: -1}, false, 0, true},
+		{"ELF", RegexpOptions{Anchor: Anchor(42)}, false, 0, true},
+	}
+
+	for _, c := range cases {
+		found, address, _, softerrors, err := FindRegexpMatchOptions(context.Background(), proc, region.Address,
+			nil, regexp.MustCompile(c.r), c.opts)
+		test.PrintSoftErrors(softerrors)
+		if (err != nil) != c.expectedError {
+			t.Errorf("Regexp %q with %+v: unexpected error %v", c.r, c.opts, err)
+			continue
+		}
+		if found != c.expected || (found && address != c.expectedAddr) {
+			t.Errorf("Regexp %q with %+v: expected found %v at %x and got found %v at %x", c.r, c.opts,
+				c.expected, c.expectedAddr, found, address)
+		}
+	}
+}
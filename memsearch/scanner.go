@@ -0,0 +1,237 @@
+package memsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// PatternMatch represents a match found by a Scanner, and the index of the pattern that matched in Scanner.Patterns.
+type PatternMatch struct {
+	Pattern int
+	Match
+}
+
+// ProcessResult holds the result of scanning a process with a Scanner.
+type ProcessResult struct {
+	Process process.Process
+
+	// Matches holds every match found, in increasing address order.
+	Matches []PatternMatch
+
+	Softerrors []error
+
+	// Harderror is the error that stopped the scan of the process, if any. If the process was stopped because of
+	// Scanner.ProcessTimeout it's context.DeadlineExceeded, and the matches found until then are still reported.
+	Harderror error
+
+	// LastAddress is the address up to which the memory of the process has been searched, as returned by
+	// FindAllBytesSequencesContext.
+	LastAddress uintptr
+}
+
+// ResultFunc type represents a function called by a Scanner with the result of each process.
+type ResultFunc func(result ProcessResult)
+
+// Scanner searches a set of patterns in the memory of many processes in parallel.
+//
+// Its fields must not be modified while it's scanning.
+type Scanner struct {
+	// Patterns are the patterns to search. The memory of each process is read once, searching all of them.
+	Patterns []Matcher
+
+	// Filter selects the mappings to search, if it's nil every readable mapping is searched.
+	Filter memaccess.RegionFilter
+
+	// Workers is the maximum number of processes scanned at the same time. If it's zero or negative runtime.NumCPU()
+	// is used.
+	Workers int
+
+	// BytesPerSecond limits the rate at which memory is read, in total between all the workers. If it's zero or
+	// negative there's no limit.
+	BytesPerSecond int64
+
+	// ProcessTimeout limits the time spent scanning each process. If it's zero or negative there's no limit.
+	ProcessTimeout time.Duration
+
+	// MaxMatches is the maximum number of matches reported for each process. If it's zero or negative every match is
+	// reported.
+	MaxMatches int
+
+	// MaxMatchesPerPattern is the maximum number of matches of each pattern reported for each process. Once a pattern
+	// has more it isn't searched anymore, and a *TooManyMatchesError is added to the soft errors of the process. The
+	// matches it drops don't count in MaxMatches. If it's zero or negative every match is reported.
+	MaxMatchesPerPattern int
+}
+
+// TooManyMatchesError is reported by a Scanner when a pattern has more matches in a process than
+// Scanner.MaxMatchesPerPattern. Pattern is its index in Scanner.Patterns.
+type TooManyMatchesError struct {
+	Pattern int
+	Max     int
+}
+
+func (err *TooManyMatchesError) Error() string {
+	return fmt.Sprintf("Pattern %d has more than %d matches, the rest are not reported", err.Pattern, err.Max)
+}
+
+// Scan searches the patterns in the memory of the processes ps, calling resultFn with the result of each of them as
+// soon as it's finished. The processes are scanned in parallel, so the results can come in any order, but resultFn is
+// never called concurrently.
+//
+// If ctx is done the scans in progress are stopped, reporting ctx.Err() as their hard error, the remaining processes
+// are not scanned, and ctx.Err() is returned.
+func (s *Scanner) Scan(ctx context.Context, ps []process.Process, resultFn ResultFunc) error {
+	return s.scan(ctx, len(ps), func(i int) process.Process {
+		return ps[i]
+	}, false, resultFn)
+}
+
+// ScanMatching works as Scan, but it scans the running processes that match selector. The workers open and select
+// each of them as process.OpenIfMatching does, so only the ones being scanned are open, and they are closed once they
+// are scanned.
+//
+// The soft errors returned are the ones found opening and selecting the processes, the ones found scanning them are
+// reported in their results.
+func (s *Scanner) ScanMatching(ctx context.Context, selector process.Selector, resultFn ResultFunc) (
+	softerrors []error, harderror error) {
+
+	pids, softerrors, harderror := process.GetAllPids()
+	if harderror != nil {
+		return softerrors, harderror
+	}
+
+	var softerrorsMutex sync.Mutex
+	harderror = s.scan(ctx, len(pids), func(i int) process.Process {
+		p, softs, err := process.OpenIfMatching(pids[i], selector)
+		if err != nil {
+			softs = append(softs, err)
+		}
+
+		softerrorsMutex.Lock()
+		softerrors = append(softerrors, softs...)
+		softerrorsMutex.Unlock()
+		return p
+	}, true, resultFn)
+	return softerrors, harderror
+}
+
+// scan scans the n processes returned by open, which is called by the workers with each index from 0 to n-1 and
+// returns nil for the processes that must be skipped. If closeProcesses is true they are closed once they are scanned.
+func (s *Scanner) scan(ctx context.Context, n int, open func(i int) process.Process, closeProcesses bool,
+	resultFn ResultFunc) error {
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var limiter *rateLimiter
+	if s.BytesPerSecond > 0 {
+		limiter = &rateLimiter{bytesPerSecond: float64(s.BytesPerSecond)}
+	}
+
+	pending := make(chan int)
+	var resultMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range pending {
+				if ctx.Err() != nil {
+					continue
+				}
+				p := open(index)
+				if p == nil {
+					continue
+				}
+
+				result := s.scanProcess(ctx, p, limiter)
+
+				resultMutex.Lock()
+				resultFn(result)
+				resultMutex.Unlock()
+
+				if closeProcesses {
+					p.Close()
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		// The workers may all be busy, so ctx can be done while waiting for one.
+		select {
+		case pending <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// scanProcess searches the patterns in the memory of a single process.
+func (s *Scanner) scanProcess(ctx context.Context, p process.Process, limiter *rateLimiter) ProcessResult {
+	if s.ProcessTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ProcessTimeout)
+		defer cancel()
+	}
+
+	result := ProcessResult{Process: p}
+	result.LastAddress, result.Softerrors, result.Harderror = findAll(ctx, p, 0, s.Filter, s.Patterns,
+		s.MaxMatches, s.MaxMatchesPerPattern, limiter, func(pattern int, m Match) (keepSearching bool) {
+			result.Matches = append(result.Matches, PatternMatch{Pattern: pattern, Match: m})
+			return true
+		})
+	return result
+}
+
+// rateLimiter limits the rate at which bytes are read between many goroutines. A nil *rateLimiter doesn't limit it.
+type rateLimiter struct {
+	bytesPerSecond float64
+
+	mutex sync.Mutex
+	// next is the time at which the bytes already accounted for will have been read at the allowed rate.
+	next time.Time
+}
+
+// wait accounts for n bytes, waiting until they can be read without exceeding the rate. It returns ctx.Err() if ctx is
+// done before that.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSecond * float64(time.Second)))
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,238 @@
+package memsearch
+
+import (
+	"context"
+	"errors"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func launchTestCases(t *testing.T, n int) (cmds []*exec.Cmd, procs []process.Process) {
+	for i := 0; i < n; i++ {
+		cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+
+		proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+		test.PrintSoftErrors(softerrors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		procs = append(procs, proc)
+	}
+	return cmds, procs
+}
+
+func killTestCases(cmds []*exec.Cmd, procs []process.Process) {
+	process.CloseAll(procs)
+	for _, cmd := range cmds {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+func TestScanner(t *testing.T) {
+	cmds, procs := launchTestCases(t, 3)
+	defer killTestCases(cmds, procs)
+
+	scanner := &Scanner{
+		Patterns: []Matcher{Literal(buffersToFind[0]), regexp.MustCompile(regexpToMatch[1]), Literal(notPresent)},
+		Workers:  2,
+	}
+
+	results := make(map[uint]ProcessResult)
+	err := scanner.Scan(context.Background(), procs, func(result ProcessResult) {
+		results[result.Process.Pid()] = result
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != len(procs) {
+		t.Fatalf("Expected %d results and got %d", len(procs), len(results))
+	}
+
+	for _, p := range procs {
+		result := results[p.Pid()]
+		test.PrintSoftErrors(result.Softerrors)
+		if result.Harderror != nil {
+			t.Fatal(result.Harderror)
+		}
+
+		found := make(map[int]bool)
+		for i, m := range result.Matches {
+			found[m.Pattern] = true
+			if i > 0 && m.Address < result.Matches[i-1].Address {
+				t.Errorf("Match at %x reported after the one at %x", m.Address, result.Matches[i-1].Address)
+			}
+		}
+
+		if !found[0] || !found[1] || found[2] {
+			t.Errorf("Unexpected patterns found in process %d: %v", p.Pid(), found)
+		}
+
+		_, first, _, err := FindBytesSequence(p, 0, buffersToFind[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range result.Matches {
+			if m.Pattern == 0 {
+				if m.Address != first {
+					t.Errorf("First match of the literal at %x, but FindBytesSequence found it at %x", m.Address,
+						first)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestScanMatching(t *testing.T) {
+	cmds, procs := launchTestCases(t, 2)
+	defer killTestCases(cmds, procs)
+
+	scanner := &Scanner{Patterns: []Matcher{Literal(buffersToFind[0])}}
+	found := make(map[uint]bool)
+	softerrors, err := scanner.ScanMatching(context.Background(), process.ByParent(uint(os.Getpid())),
+		func(result ProcessResult) {
+			found[result.Process.Pid()] = len(result.Matches) > 0
+		})
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != len(procs) {
+		t.Errorf("Expected %d processes to be scanned and got %v", len(procs), found)
+	}
+	for _, p := range procs {
+		if !found[p.Pid()] {
+			t.Errorf("The literal wasn't found in process %d", p.Pid())
+		}
+	}
+}
+
+func TestScannerLimits(t *testing.T) {
+	cmds, procs := launchTestCases(t, 2)
+	defer killTestCases(cmds, procs)
+
+	// At 64KB/s no process can be scanned in 200ms.
+	scanner := &Scanner{
+		Patterns:       []Matcher{Literal(notPresent)},
+		BytesPerSecond: 64 * 1024,
+		ProcessTimeout: 200 * time.Millisecond,
+	}
+
+	start := time.Now()
+	results := 0
+	err := scanner.Scan(context.Background(), procs, func(result ProcessResult) {
+		results++
+		if result.Harderror != context.DeadlineExceeded {
+			t.Error("Expected context.DeadlineExceeded and got", result.Harderror)
+		}
+		if result.LastAddress == 0 {
+			t.Error("Expected some memory to be searched before the timeout")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results != len(procs) {
+		t.Errorf("Expected %d results and got %d", len(procs), results)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Error("The timeout didn't stop the scan, it took", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := scanner.Scan(ctx, procs, func(result ProcessResult) {
+		t.Error("A process was scanned with a cancelled context")
+	}); err != context.Canceled {
+		t.Error("Expected context.Canceled and got", err)
+	}
+}
+
+func TestScannerMaxMatchesPerPattern(t *testing.T) {
+	cmds, procs := launchTestCases(t, 1)
+	defer killTestCases(cmds, procs)
+
+	// A zero byte is everywhere, but the literal is only reported once.
+	scanner := &Scanner{
+		Patterns:             []Matcher{Literal{0}, Literal(buffersToFind[0])},
+		MaxMatchesPerPattern: 100,
+	}
+
+	err := scanner.Scan(context.Background(), procs, func(result ProcessResult) {
+		if result.Harderror != nil {
+			t.Error(result.Harderror)
+			return
+		}
+
+		counts := make(map[int]int)
+		for _, m := range result.Matches {
+			counts[m.Pattern]++
+		}
+		if counts[0] != scanner.MaxMatchesPerPattern || counts[1] == 0 {
+			t.Errorf("Expected %d zero bytes and the literal to be reported, and got %v", scanner.MaxMatchesPerPattern,
+				counts)
+		}
+
+		tooMany := 0
+		for _, err := range result.Softerrors {
+			var tooManyErr *TooManyMatchesError
+			if errors.As(err, &tooManyErr) {
+				tooMany++
+				if tooManyErr.Pattern != 0 || tooManyErr.Max != scanner.MaxMatchesPerPattern {
+					t.Error("Unexpected error", err)
+				}
+			}
+		}
+		if tooMany != 1 {
+			t.Errorf("Expected one *TooManyMatchesError and got %d in %v", tooMany, result.Softerrors)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScannerMaxMatchesWithNoisyPattern(t *testing.T) {
+	cmds, procs := launchTestCases(t, 1)
+	defer killTestCases(cmds, procs)
+
+	// The zero bytes dropped after the first 5 must not use up the matches of the rare literal.
+	scanner := &Scanner{
+		Patterns:             []Matcher{Literal{0}, Literal(buffersToFind[0])},
+		MaxMatches:           10,
+		MaxMatchesPerPattern: 5,
+	}
+
+	err := scanner.Scan(context.Background(), procs, func(result ProcessResult) {
+		if result.Harderror != nil {
+			t.Error(result.Harderror)
+			return
+		}
+
+		counts := make(map[int]int)
+		for _, m := range result.Matches {
+			counts[m.Pattern]++
+		}
+		if counts[0] != scanner.MaxMatchesPerPattern || counts[1] == 0 {
+			t.Errorf("Expected %d zero bytes and the literal to be reported, and got %v", scanner.MaxMatchesPerPattern,
+				counts)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
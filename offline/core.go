@@ -0,0 +1,301 @@
+package offline
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// These are the types of the notes of core files used to describe the process, besides elf.NT_PRPSINFO.
+const (
+	ntAuxv elf.NType = 6
+	ntFile elf.NType = 0x46494c45
+)
+
+// These are the types of the entries of the auxiliary vector that hold the address of the program headers of the
+// executable, of 16 random bytes in the stack, and of the vDSO.
+const (
+	atPhdr        = 3
+	atRandom      = 25
+	atSysinfoEhdr = 33
+)
+
+// vsyscallAddress is the address of the [vsyscall] page in x86-64.
+const vsyscallAddress = 0xffffffffff600000
+
+// OpenCore opens an ELF core file. Its PT_LOAD segments are the mappings of the process, and its notes are used to get
+// their paths (NT_FILE), and the pid and the name of the process (NT_PRPSINFO and NT_AUXV). If any of the notes is
+// missing or malformed a soft error is returned, and what it describes is left unknown.
+//
+// The segments that weren't entirely dumped, as the kernel does with those of files that can be read from disk, are
+// split in two mappings: the dumped part, and the rest, which isn't readable.
+//
+// Core files don't have the device and inode of the mapped files, so their mappings have IdentityUnknown set, nor the
+// pseudo-paths of the mappings set up by the kernel, which are guessed as namePseudoMappings describes.
+func OpenCore(path string) (p process.Process, softerrors []error, harderror error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := elf.NewFile(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if file.Type != elf.ET_CORE {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s is not a core file", path)
+	}
+
+	c := coreFile{File: file, wordSize: 4}
+	if file.Class == elf.ELFCLASS64 {
+		c.wordSize = 8
+	}
+	notes, err := c.notes()
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+
+	files, err := c.fileNote(notes[ntFile])
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+
+	d := &dump{closer: f}
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+
+		m := memaccess.Mapping{Address: uintptr(prog.Vaddr), Size: uint(prog.Memsz)}
+		if prog.Flags&elf.PF_R != 0 {
+			m.Permissions |= memaccess.PermRead
+		}
+		if prog.Flags&elf.PF_W != 0 {
+			m.Permissions |= memaccess.PermWrite
+		}
+		if prog.Flags&elf.PF_X != 0 {
+			m.Permissions |= memaccess.PermExecute
+		}
+		if mf, ok := files[m.Address]; ok {
+			m.Path, m.Offset, m.Deleted = mf.Path, mf.Offset, mf.Deleted
+			m.IdentityUnknown = true
+		}
+
+		d.addMapping(m, f, int64(prog.Off), uint(prog.Filesz))
+	}
+
+	pid, comm, err := c.prpsinfo(notes[elf.NT_PRPSINFO])
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	d.pid = pid
+
+	phdr, err := c.auxvEntry(notes[ntAuxv], atPhdr)
+	if err != nil {
+		softerrors = append(softerrors, err)
+	}
+	for _, m := range d.mappings {
+		if phdr != 0 && m.Contains(uintptr(phdr)) {
+			d.name = m.Path
+		}
+	}
+	if d.name == "" {
+		d.name = d.firstFileMapping()
+	}
+	if d.name == "" {
+		d.name = comm
+	}
+
+	c.namePseudoMappings(d.mappings, notes[ntAuxv], d.name)
+
+	return d, softerrors, nil
+}
+
+// namePseudoMappings gives their pseudo-paths to the mappings set up by the kernel, which have no path in core files.
+// The vDSO and the stack are found by the addresses the auxiliary vector has of them, and [vvar] is what lies right
+// below the vDSO (newer kernels split it in [vvar] and [vvar_vclock], which are both named [vvar]). The heap is assumed
+// to be the first anonymous mapping after the executable, whose path is exe, that doesn't adjoin its mappings, as
+// those that do are its bss.
+func (c coreFile) namePseudoMappings(mappings []memaccess.Mapping, auxv []byte, exe string) {
+	vdso, _ := c.auxvEntry(auxv, atSysinfoEhdr)
+	random, _ := c.auxvEntry(auxv, atRandom)
+
+	name := func(m *memaccess.Mapping, path string) {
+		m.Path, m.Pseudo = path, true
+	}
+
+	var exeEnd uintptr
+	heapFound := false
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Path != "" {
+			if m.Path == exe {
+				exeEnd = m.Address + uintptr(m.Size)
+			} else if exeEnd != 0 {
+				heapFound = true
+			}
+			continue
+		}
+
+		switch {
+		case vdso != 0 && m.Contains(uintptr(vdso)):
+			name(m, "[vdso]")
+			for j := i - 1; j >= 0; j-- {
+				below := &mappings[j]
+				if below.Path != "" || below.IsWritable() || below.Address+uintptr(below.Size) != mappings[j+1].Address {
+					break
+				}
+				name(below, "[vvar]")
+			}
+		case random != 0 && m.Contains(uintptr(random)):
+			name(m, "[stack]")
+		case c.Machine == elf.EM_X86_64 && uint64(m.Address) == vsyscallAddress:
+			name(m, "[vsyscall]")
+		case !heapFound && exeEnd != 0 && m.Address != exeEnd && m.IsWritable():
+			name(m, "[heap]")
+			heapFound = true
+		case m.Address == exeEnd:
+			exeEnd += uintptr(m.Size)
+		}
+	}
+}
+
+// coreFile is an ELF core file whose notes are being parsed.
+type coreFile struct {
+	*elf.File
+	wordSize int
+}
+
+// notes returns the descriptors of the first note of each type in the PT_NOTE segments.
+func (c coreFile) notes() (map[elf.NType][]byte, error) {
+	notes := make(map[elf.NType][]byte)
+	for _, prog := range c.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return notes, fmt.Errorf("Could not read the notes of the core file: %w", err)
+		}
+
+		for len(data) > 0 {
+			if len(data) < 12 {
+				return notes, fmt.Errorf("Truncated note in the core file")
+			}
+			namesz := int(c.ByteOrder.Uint32(data[0:]))
+			descsz := int(c.ByteOrder.Uint32(data[4:]))
+			typ := elf.NType(c.ByteOrder.Uint32(data[8:]))
+
+			descStart := 12 + alignNote(namesz)
+			if namesz < 0 || descsz < 0 || descStart+descsz > len(data) {
+				return notes, fmt.Errorf("Truncated note in the core file")
+			}
+			if _, ok := notes[typ]; !ok {
+				notes[typ] = data[descStart : descStart+descsz]
+			}
+
+			next := descStart + alignNote(descsz)
+			if next > len(data) {
+				next = len(data)
+			}
+			data = data[next:]
+		}
+	}
+	return notes, nil
+}
+
+func alignNote(n int) int {
+	return (n + 3) &^ 3
+}
+
+func (c coreFile) word(b []byte) uint64 {
+	if c.wordSize == 8 {
+		return c.ByteOrder.Uint64(b)
+	}
+	return uint64(c.ByteOrder.Uint32(b))
+}
+
+// fileNote parses the NT_FILE note, returning the file-backed mappings it describes by their address.
+func (c coreFile) fileNote(desc []byte) (map[uintptr]memaccess.Mapping, error) {
+	files := make(map[uintptr]memaccess.Mapping)
+	if desc == nil {
+		return files, fmt.Errorf("The core file has no NT_FILE note, so the paths of the mappings are unknown")
+	}
+
+	malformed := fmt.Errorf("Malformed NT_FILE note in the core file")
+	if len(desc) < 2*c.wordSize {
+		return files, malformed
+	}
+	count := c.word(desc)
+	pageSize := c.word(desc[c.wordSize:])
+	entries := desc[2*c.wordSize:]
+	if count > uint64(len(entries)/(3*c.wordSize)) {
+		return files, malformed
+	}
+
+	paths := strings.Split(string(entries[int(count)*3*c.wordSize:]), "\x00")
+	if uint64(len(paths)) < count {
+		return files, malformed
+	}
+	for i := 0; i < int(count); i++ {
+		entry := entries[i*3*c.wordSize:]
+		m := memaccess.Mapping{Address: uintptr(c.word(entry)), Offset: c.word(entry[2*c.wordSize:]) * pageSize,
+			Path: paths[i]}
+		if strings.HasSuffix(m.Path, deletedSuffix) {
+			m.Path = strings.TrimSuffix(m.Path, deletedSuffix)
+			m.Deleted = true
+		}
+		files[m.Address] = m
+	}
+	return files, nil
+}
+
+// deletedSuffix is appended by the kernel to the paths of files that have been deleted.
+const deletedSuffix = " (deleted)"
+
+// prpsinfo parses the NT_PRPSINFO note, returning the pid and the command name of the process. The user and group ids
+// are 16 bits long in it on some 32 bits architectures.
+func (c coreFile) prpsinfo(desc []byte) (pid uint, comm string, err error) {
+	if desc == nil {
+		return 0, "", fmt.Errorf("The core file has no NT_PRPSINFO note, so the pid of the process is unknown")
+	}
+
+	uidSize := 4
+	if c.Class == elf.ELFCLASS32 && (c.Machine == elf.EM_386 || c.Machine == elf.EM_ARM) {
+		uidSize = 2
+	}
+	// pr_state, pr_sname, pr_zomb and pr_nice, pr_flag aligned as a long, pr_uid and pr_gid.
+	pidOffset := c.wordSize + c.wordSize + 2*uidSize
+	// pr_pid, pr_ppid, pr_pgrp and pr_sid, and pr_fname.
+	if len(desc) < pidOffset+16+16 {
+		return 0, "", fmt.Errorf("Malformed NT_PRPSINFO note in the core file")
+	}
+
+	pid = uint(c.ByteOrder.Uint32(desc[pidOffset:]))
+	fname := desc[pidOffset+16 : pidOffset+32]
+	if i := bytes.IndexByte(fname, 0); i != -1 {
+		fname = fname[:i]
+	}
+	return pid, string(fname), nil
+}
+
+// auxvEntry returns the value of the entry of the auxiliary vector in the NT_AUXV note with the given type, or 0 if
+// there's none.
+func (c coreFile) auxvEntry(desc []byte, typ uint64) (uint64, error) {
+	if desc == nil {
+		return 0, fmt.Errorf("The core file has no NT_AUXV note")
+	}
+	for ; len(desc) >= 2*c.wordSize; desc = desc[2*c.wordSize:] {
+		if c.word(desc) == typ {
+			return c.word(desc[c.wordSize:]), nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,53 @@
+package offline
+
+import (
+	"bufio"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MapsFileName is the name of the copy of the maps file of the process in the directories opened by OpenDumpDir.
+const MapsFileName = "maps"
+
+// OpenDumpDir opens a directory with a raw dump of the memory of a Linux process. It must contain a copy of the
+// process' /proc/<pid>/maps file, called MapsFileName, and a file with the contents of each mapping that was dumped,
+// called as its address range in the maps file followed by ".bin" (e.g. "7f0ae8565000-7f0ae8568000.bin"). The mappings
+// without a file aren't readable, and the ones whose file is shorter than them are split as OpenCore does.
+//
+// The pid of the process isn't in the dump so Pid returns 0, and Name returns the path of the first file-backed
+// mapping, which is the executable of the process.
+func OpenDumpDir(dir string) (p process.Process, softerrors []error, harderror error) {
+	mapsFile, err := os.Open(filepath.Join(dir, MapsFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer mapsFile.Close()
+
+	d := &dump{}
+	scanner := bufio.NewScanner(mapsFile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m, err := memaccess.ParseMapping(line)
+		if err != nil {
+			return nil, softerrors, err
+		}
+
+		path := filepath.Join(dir, strings.SplitN(line, " ", 2)[0]+".bin")
+		var captured uint
+		if info, err := os.Stat(path); err == nil {
+			captured = uint(info.Size())
+		} else if !os.IsNotExist(err) {
+			softerrors = append(softerrors, err)
+		}
+		d.addMapping(m, lazyFile(path), 0, captured)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, softerrors, err
+	}
+
+	d.name = d.firstFileMapping()
+	return d, softerrors, nil
+}
@@ -0,0 +1,155 @@
+// Package offline opens dumps of the memory of processes as process.Process values, so they can be analyzed with the
+// rest of masche as if they were still running: they implement memaccess.MemorySource, which memaccess uses to list
+// their mappings and read their memory, and everything built on it (memsearch, rules, listlibs) works with them.
+//
+// Two kinds of dumps are supported: ELF core files, like the ones written by memaccess.DumpCore, gcore or the kernel,
+// and directories with the maps file of a Linux process and a raw dump of each mapping (see OpenDumpDir). They can be
+// opened in any OS.
+package offline
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"io"
+	"os"
+	"sort"
+)
+
+// ErrNotCaptured is the error of the *memaccess.RegionReadError returned when reading memory that was mapped in the
+// process but whose contents aren't in the dump.
+var ErrNotCaptured = errors.New("Memory was not captured in the dump")
+
+// dump is a process opened from a dump. It implements process.Process and memaccess.MemorySource.
+type dump struct {
+	pid  uint
+	name string
+
+	// mappings holds the mappings of the process sorted by address, split in the parts that were captured and the
+	// ones that weren't, which aren't readable.
+	mappings []memaccess.Mapping
+
+	// segments holds where the contents of each of the captured parts of the mappings are, sorted by address.
+	segments []segment
+
+	// closer is closed with the dump, if it's not nil.
+	closer io.Closer
+}
+
+// segment is a captured range of memory, whose contents are in data starting at offset.
+type segment struct {
+	address uintptr
+	size    uint
+	data    io.ReaderAt
+	offset  int64
+}
+
+func (d *dump) Pid() uint {
+	return d.pid
+}
+
+func (d *dump) Name() (name string, softerrors []error, harderror error) {
+	if d.name == "" {
+		return "", nil, fmt.Errorf("The name of the process is not in the dump")
+	}
+	return d.name, nil, nil
+}
+
+func (d *dump) Close() (softerrors []error, harderror error) {
+	if d.closer == nil {
+		return nil, nil
+	}
+	return nil, d.closer.Close()
+}
+
+// Handle returns 0, as dumps don't have any handle.
+func (d *dump) Handle() uintptr {
+	return 0
+}
+
+func (d *dump) Mappings() (mappings []memaccess.Mapping, softerrors []error, harderror error) {
+	return append([]memaccess.Mapping(nil), d.mappings...), nil, nil
+}
+
+func (d *dump) ReadMemory(address uintptr, buffer []byte) error {
+	for done := 0; done < len(buffer); {
+		current := address + uintptr(done)
+		i := sort.Search(len(d.segments), func(i int) bool {
+			return d.segments[i].address+uintptr(d.segments[i].size) > current
+		})
+		if i == len(d.segments) || d.segments[i].address > current {
+			return &memaccess.RegionReadError{Addr: address, Len: uint(len(buffer)), Err: d.missingError(current)}
+		}
+
+		s := d.segments[i]
+		n := len(buffer) - done
+		if left := int(s.address + uintptr(s.size) - current); left < n {
+			n = left
+		}
+		if _, err := s.data.ReadAt(buffer[done:done+n], s.offset+int64(current-s.address)); err != nil {
+			return &memaccess.RegionReadError{Addr: address, Len: uint(len(buffer)), Err: err}
+		}
+		done += n
+	}
+	return nil
+}
+
+// missingError returns the error of reading address, which isn't in any segment: ErrNotCaptured if it was mapped, and
+// memaccess.ErrUnmapped otherwise.
+func (d *dump) missingError(address uintptr) error {
+	for _, m := range d.mappings {
+		if m.Contains(address) {
+			return ErrNotCaptured
+		}
+	}
+	return memaccess.ErrUnmapped
+}
+
+// addMapping adds a mapping of the process whose first captured bytes are in data starting at offset. If only a part
+// of it was captured it's split, and the rest isn't readable.
+func (d *dump) addMapping(m memaccess.Mapping, data io.ReaderAt, offset int64, captured uint) {
+	if captured > m.Size {
+		captured = m.Size
+	}
+
+	if captured > 0 {
+		part := m
+		part.Size = captured
+		d.mappings = append(d.mappings, part)
+		d.segments = append(d.segments, segment{address: m.Address, size: captured, data: data, offset: offset})
+	}
+
+	if captured < m.Size {
+		rest := m
+		rest.Address += uintptr(captured)
+		rest.Size -= captured
+		if rest.Path != "" {
+			rest.Offset += uint64(captured)
+		}
+		rest.Permissions &^= memaccess.PermRead
+		d.mappings = append(d.mappings, rest)
+	}
+}
+
+// firstFileMapping returns the path of the first mapping backed by a file, which is the executable of the process, as
+// it's mapped before anything else.
+func (d *dump) firstFileMapping() string {
+	for _, m := range d.mappings {
+		if m.Path != "" && !m.Pseudo {
+			return m.Path
+		}
+	}
+	return ""
+}
+
+// lazyFile is an io.ReaderAt that opens the file in path on every read, so dumps with many files don't keep them open.
+type lazyFile string
+
+func (path lazyFile) ReadAt(p []byte, off int64) (n int, err error) {
+	f, err := os.Open(string(path))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(p, off)
+}
@@ -0,0 +1,276 @@
+package offline
+
+import (
+	"bufio"
+	"errors"
+	"github.com/mozilla/masche/injection"
+	"github.com/mozilla/masche/listlibs"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/memsearch"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var testString = []byte("Un dia vi una vaca vestida de uniforme")
+
+// checkDump checks that the dump of the test process has the same readable memory and libraries than the process.
+func checkDump(t *testing.T, live process.Process, dumped process.Process) {
+	found, liveAddress, softerrors, err := memsearch.FindBytesSequence(live, 0, testString)
+	test.PrintSoftErrors(softerrors)
+	if err != nil || !found {
+		t.Fatal("The test string wasn't found in the test process", err)
+	}
+
+	found, dumpAddress, softerrors, err := memsearch.FindBytesSequence(dumped, 0, testString)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || dumpAddress != liveAddress {
+		t.Errorf("Expected the test string at %x in the dump and found it at %x", liveAddress, dumpAddress)
+	}
+
+	liveLibs, softerrors, err := listlibs.ListLoadedLibraries(live)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpLibs, softerrors, err := listlibs.ListLoadedLibraries(dumped)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(liveLibs)
+	sort.Strings(dumpLibs)
+	if !reflect.DeepEqual(liveLibs, dumpLibs) {
+		t.Errorf("Expected the libraries %v in the dump and got %v", liveLibs, dumpLibs)
+	}
+
+	liveName, softerrors, err := live.Name()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpName, softerrors, err := dumped.Name()
+	test.PrintSoftErrors(softerrors)
+	if err != nil || dumpName != liveName {
+		t.Errorf("Expected the name %s and got %s (%v)", liveName, dumpName, err)
+	}
+
+	// The first page is never mapped.
+	if _, err = memaccess.CopyMemory(dumped, 0, make([]byte, 16)); !errors.Is(err, memaccess.ErrUnmapped) {
+		t.Error("Expected an error matching ErrUnmapped and got", err)
+	}
+	if _, err = memaccess.WriteMemory(dumped, dumpAddress, []byte{0}); err != memaccess.ErrNotOpenedForWriting {
+		t.Error("Expected ErrNotOpenedForWriting and got", err)
+	}
+}
+
+func TestOpenCore(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	live, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	f, err := ioutil.TempFile("", "masche-core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	softerrors, err = memaccess.DumpCore(live, f)
+	test.PrintSoftErrors(softerrors)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, softerrors, err := OpenCore(f.Name())
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dumped.Close()
+
+	if dumped.Pid() != live.Pid() {
+		t.Errorf("Expected pid %d and got %d", live.Pid(), dumped.Pid())
+	}
+	checkDump(t, live, dumped)
+	checkCoreMappings(t, live, dumped)
+}
+
+// checkCoreMappings checks that the mappings and libraries of a core file of the test process aren't taken for something
+// they aren't, even if the core file doesn't tell which file they map, or whether they were set up by the kernel.
+func checkCoreMappings(t *testing.T, live process.Process, dumped process.Process) {
+	liveMappings, softerrors, err := memaccess.ListMappings(live)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpMappings, softerrors, err := memaccess.ListMappings(dumped)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lm := range liveMappings {
+		for _, dm := range dumpMappings {
+			if !lm.Contains(dm.Address) {
+				continue
+			}
+			if memaccess.AnonymousRegions(dm) != memaccess.AnonymousRegions(lm) {
+				t.Errorf("Mapping %v of the core is anonymous=%v, but %v is anonymous=%v", dm,
+					memaccess.AnonymousRegions(dm), lm, memaccess.AnonymousRegions(lm))
+			}
+			expected := lm.Path
+			if expected == "[vvar_vclock]" {
+				expected = "[vvar]"
+			}
+			if lm.Pseudo && (!dm.Pseudo || dm.Path != expected) {
+				t.Errorf("Expected the mapping %v of the core to be %s", dm, expected)
+			}
+		}
+	}
+
+	liveFindings, softerrors, err := injection.FindSuspiciousMappings(live)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpFindings, softerrors, err := injection.FindSuspiciousMappings(dumped)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, df := range dumpFindings {
+		found := false
+		for _, lf := range liveFindings {
+			found = found || lf.Contains(df.Address)
+		}
+		if !found {
+			t.Errorf("Mapping %v of the core was found suspicious (%v), but not in the process", df.Mapping, df.Reasons)
+		}
+	}
+
+	libraries, softerrors, err := listlibs.ListLibraries(dumped)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libraries) == 0 {
+		t.Error("No libraries found in the core")
+	}
+	for _, l := range libraries {
+		if !l.IdentityUnknown || l.Replaced {
+			t.Errorf("Expected the library %s of the core to have an unknown identity and not be replaced", l.Path)
+		}
+	}
+}
+
+func TestOpenDumpDir(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	live, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	dir, err := ioutil.TempDir("", "masche-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	maps, err := process.OpenProcFile(live, "maps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer maps.Close()
+	mapsCopy, err := os.Create(filepath.Join(dir, MapsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapsCopy.Close()
+
+	// Every readable mapping is dumped, except the second half of the first one.
+	var split memaccess.Mapping
+	scanner := bufio.NewScanner(maps)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, err := mapsCopy.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := memaccess.ParseMapping(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !m.IsReadable() || m.Path == "[vvar]" || m.Path == "[vvar_vclock]" || m.Path == "[vsyscall]" {
+			continue
+		}
+
+		size := m.Size
+		if split.Size == 0 {
+			split = m
+			size = m.Size / 2
+		}
+		buf := make([]byte, size)
+		if _, err := memaccess.CopyMemory(live, m.Address, buf); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, strings.SplitN(line, " ", 2)[0]+".bin")
+		if err := ioutil.WriteFile(name, buf, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, softerrors, err := OpenDumpDir(dir)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dumped.Close()
+
+	checkDump(t, live, dumped)
+
+	mappings, softerrors, err := memaccess.ListMappings(dumped)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := split.Address + uintptr(split.Size/2)
+	for _, m := range mappings {
+		if m.Contains(split.Address) && (m.Size != split.Size/2 || !m.IsReadable()) {
+			t.Errorf("Expected the dumped half of %v to be readable, got %v", split, m)
+		}
+		if m.Contains(half) && (m.Address != half || m.IsReadable()) {
+			t.Errorf("Expected the second half of %v not to be readable, got %v", split, m)
+		}
+	}
+	if _, err = memaccess.CopyMemory(dumped, half-8, make([]byte, 16)); !errors.Is(err, ErrNotCaptured) {
+		t.Error("Expected an error matching ErrNotCaptured and got", err)
+	}
+}
@@ -0,0 +1,75 @@
+package process
+
+import (
+	"fmt"
+	"time"
+)
+
+// Info holds metadata about a process, as returned by GetInfo.
+type Info struct {
+	// Argv holds the command line arguments of the process, including the program name.
+	Argv []string
+
+	// Environ holds the environment variables of the process, in the form "key=value".
+	Environ []string
+
+	RealUID      uint
+	EffectiveUID uint
+	RealGID      uint
+	EffectiveGID uint
+
+	// PPid is the pid of the parent process.
+	PPid uint
+
+	// State is the state of the process as reported by the OS, e.g. "S (sleeping)".
+	State string
+
+	// Threads is the number of threads of the process.
+	Threads int
+
+	StartTime time.Time
+
+	// Cwd is the current working directory of the process and Root its root directory.
+	Cwd  string
+	Root string
+
+	// ExeDevice and ExeInode identify the binary file of the process.
+	ExeDevice uint64
+	ExeInode  uint64
+
+	// Cgroups holds the paths of the control groups the process belongs to, in each hierarchy.
+	Cgroups []string
+}
+
+// InfoFieldError is the soft error returned by GetInfo for each field of Info that couldn't be read.
+type InfoFieldError struct {
+	Field string
+	Err   error
+}
+
+func (err *InfoFieldError) Error() string {
+	return fmt.Sprintf("Could not read %s: %v", err.Field, err.Err)
+}
+
+// infoField is a set of flags indicating which fields of Info must be read by getInfo.
+type infoField uint
+
+const (
+	infoArgv infoField = 1 << iota
+	infoEnviron
+	infoStatus
+	infoStartTime
+	infoCwd
+	infoRoot
+	infoExe
+	infoCgroups
+
+	allInfoFields = infoArgv | infoEnviron | infoStatus | infoStartTime | infoCwd | infoRoot | infoExe | infoCgroups
+)
+
+// GetInfo returns metadata about a process. Every field of the Info that can't be read is left with its zero value, and
+// a soft error of type *InfoFieldError is returned for it.
+func GetInfo(p Process) (info Info, softerrors []error, harderror error) {
+	// This function is implemented by the OS-specific getInfo function.
+	return getInfo(p, allInfoFields)
+}
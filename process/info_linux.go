@@ -0,0 +1,302 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTicksPerSecond is the unit of the times in /proc/<pid>/stat (USER_HZ). It's 100 in every architecture Linux
+// supports, and reading it with sysconf(3) would need cgo.
+const clockTicksPerSecond = 100
+
+func getInfo(p Process, fields infoField) (info Info, softerrors []error, harderror error) {
+	lp, ok := p.(*proc)
+	if !ok {
+		return Info{}, nil, ErrNotLive
+	}
+	if harderror = lp.checkAlive(); harderror != nil {
+		return
+	}
+
+	addError := func(field string, err error) {
+		softerrors = append(softerrors, &InfoFieldError{Field: field, Err: err})
+	}
+
+	if fields&infoArgv != 0 {
+		if argv, err := readNulSeparatedFile(p, "cmdline"); err != nil {
+			addError("argv", err)
+		} else {
+			info.Argv = argv
+		}
+	}
+
+	if fields&infoEnviron != 0 {
+		if environ, err := readNulSeparatedFile(p, "environ"); err != nil {
+			addError("environment", err)
+		} else {
+			// Empty entries aren't variables, but processes may leave them after modifying their environment.
+			info.Environ = make([]string, 0, len(environ))
+			for _, variable := range environ {
+				if variable != "" {
+					info.Environ = append(info.Environ, variable)
+				}
+			}
+		}
+	}
+
+	if fields&infoStatus != 0 {
+		softerrors = append(softerrors, readStatus(p, &info)...)
+	}
+
+	if fields&infoStartTime != 0 {
+		if startTime, err := readStartTime(lp); err != nil {
+			addError("start time", err)
+		} else {
+			info.StartTime = startTime
+		}
+	}
+
+	if fields&infoCwd != 0 {
+		if cwd, err := os.Readlink(lp.path("cwd")); err != nil {
+			addError("cwd", err)
+		} else {
+			info.Cwd = cwd
+		}
+	}
+
+	if fields&infoRoot != 0 {
+		if root, err := os.Readlink(lp.path("root")); err != nil {
+			addError("root", err)
+		} else {
+			info.Root = root
+		}
+	}
+
+	if fields&infoExe != 0 {
+		if fi, err := os.Stat(lp.path("exe")); err != nil {
+			addError("executable inode", err)
+		} else if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+			info.ExeDevice = uint64(stat.Dev)
+			info.ExeInode = uint64(stat.Ino)
+		}
+	}
+
+	if fields&infoCgroups != 0 {
+		if cgroups, err := readCgroups(p); err != nil {
+			addError("cgroups", err)
+		} else {
+			info.Cgroups = cgroups
+		}
+	}
+
+	// If the process exited while we were reading it some of the fields may come from another process that reused the
+	// pid, so we can't return any of them.
+	if harderror = lp.checkAlive(); harderror != nil {
+		return Info{}, nil, harderror
+	}
+
+	return info, softerrors, nil
+}
+
+// readNulSeparatedFile reads a file of the /proc/<pid> directory made of NUL-terminated strings, like cmdline or
+// environ.
+func readNulSeparatedFile(p Process, name string) ([]string, error) {
+	f, err := OpenProcFile(p, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the terminator of the last string is removed, as empty strings are valid (e.g. empty arguments).
+	strs := make([]string, 0)
+	if len(data) == 0 {
+		return strs, nil
+	}
+	for _, str := range bytes.Split(bytes.TrimSuffix(data, []byte{0}), []byte{0}) {
+		strs = append(strs, string(str))
+	}
+	return strs, nil
+}
+
+// readCgroups returns the paths of the control groups of the process, from /proc/<pid>/cgroup. Each line of the file
+// has the format "hierarchy-ID:controller-list:cgroup-path", and the path may contain colons too.
+func readCgroups(p Process) ([]string, error) {
+	f, err := OpenProcFile(p, "cgroup")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cgroups := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Invalid cgroup line: %s", scanner.Text())
+		}
+		cgroups = append(cgroups, fields[2])
+	}
+
+	return cgroups, scanner.Err()
+}
+
+// statusFields are the names of the fields of Info that come from /proc/<pid>/status, as reported in InfoFieldError.
+var statusFields = []string{"uid", "gid", "parent pid", "state", "threads"}
+
+// readStatus fills the fields of info that come from /proc/<pid>/status, returning an *InfoFieldError for each one that
+// couldn't be read.
+func readStatus(p Process, info *Info) (softerrors []error) {
+	f, err := OpenProcFile(p, "status")
+	if err != nil {
+		for _, field := range statusFields {
+			softerrors = append(softerrors, &InfoFieldError{Field: field, Err: err})
+		}
+		return
+	}
+	defer f.Close()
+
+	return parseStatus(f, info)
+}
+
+// parseStatus fills the fields of info from the contents of a /proc/<pid>/status file, returning an *InfoFieldError for
+// each one that couldn't be read. A field is only set if its whole line could be parsed.
+func parseStatus(r io.Reader, info *Info) (softerrors []error) {
+	// errs holds the error parsing each field found, which is nil if it was parsed.
+	errs := make(map[string]error)
+
+	// parseIDs parses the real and effective ids of a Uid or Gid line.
+	parseIDs := func(key string, values []string) (real uint, effective uint, err error) {
+		if len(values) < 2 {
+			return 0, 0, fmt.Errorf("Invalid %s line: %s", key, strings.Join(values, " "))
+		}
+		if real, err = parseUint(values[0]); err != nil {
+			return 0, 0, err
+		}
+		if effective, err = parseUint(values[1]); err != nil {
+			return 0, 0, err
+		}
+		return real, effective, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.SplitN(scanner.Text(), ":", 2)
+		if len(line) != 2 {
+			continue
+		}
+		key, value := line[0], strings.TrimSpace(line[1])
+		values := strings.Fields(value)
+
+		switch key {
+		case "Uid":
+			real, effective, err := parseIDs(key, values)
+			if err == nil {
+				info.RealUID, info.EffectiveUID = real, effective
+			}
+			errs["uid"] = err
+		case "Gid":
+			real, effective, err := parseIDs(key, values)
+			if err == nil {
+				info.RealGID, info.EffectiveGID = real, effective
+			}
+			errs["gid"] = err
+		case "PPid":
+			ppid, err := parseUint(value)
+			if err == nil {
+				info.PPid = ppid
+			}
+			errs["parent pid"] = err
+		case "State":
+			info.State = value
+			errs["state"] = nil
+		case "Threads":
+			threads, err := parseUint(value)
+			if err == nil {
+				info.Threads = int(threads)
+			}
+			errs["threads"] = err
+		}
+	}
+	scanErr := scanner.Err()
+
+	for _, field := range statusFields {
+		err, found := errs[field]
+		switch {
+		case found && err == nil:
+			continue
+		case found:
+		case scanErr != nil:
+			err = scanErr
+		default:
+			err = fmt.Errorf("Not found in status file")
+		}
+		softerrors = append(softerrors, &InfoFieldError{Field: field, Err: err})
+	}
+
+	return softerrors
+}
+
+// readStartTime returns the time the process started.
+func readStartTime(p *proc) (time.Time, error) {
+	stat, err := readStat(p.path("stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	bootTime, err := readBootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return bootTime.Add(ticksToDuration(stat.startTime)), nil
+}
+
+// ticksToDuration converts clock ticks to a time.Duration. The ticks are multiplied by the length of a tick, so they
+// don't overflow as they would multiplied by a second first, after about three years of uptime.
+func ticksToDuration(ticks uint64) time.Duration {
+	return time.Duration(ticks) * (time.Second / clockTicksPerSecond)
+}
+
+// readBootTime returns the time the system booted, from the btime line of /proc/stat.
+func readBootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			btime, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(btime, 0), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("No btime found in /proc/stat")
+}
+
+func parseUint(s string) (uint, error) {
+	n, err := strconv.ParseUint(s, 10, 0)
+	return uint(n), err
+}
@@ -4,10 +4,21 @@
 package process
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"regexp"
 )
 
+// ErrProcessGone is returned when the process a Process was opened for has exited, even if its pid was reused by
+// another process since then.
+var ErrProcessGone = errors.New("Process is gone")
+
+// ErrPermission is matched by the errors returned when the process can't be accessed because of a lack of permissions.
+// It's the same as os.ErrPermission, so it can be checked with errors.Is(err, ErrPermission) and errors.Is(err,
+// os.ErrPermission) alike.
+var ErrPermission = os.ErrPermission
+
 // Process type represents a running processes that can be used by other modules.
 // In order to get a Process on of the Open* functions must be called, and once it's not needed it must be closed.
 type Process interface {
@@ -23,6 +34,7 @@ type Process interface {
 	// Handle returns an opaque value which's meaning dependes on the OS-specific implementation of it.
 	// It works like an interface{} that you must cast, but we are using a uintptr because we need to return C values,
 	// and casting between them in different modules panics if you use interface{}.
+	// On Linux it's the pid of the process.
 	Handle() uintptr
 }
 
@@ -32,6 +44,16 @@ func OpenFromPid(pid uint) (p Process, softerrors []error, harderror error) {
 	return openFromPid(pid)
 }
 
+// OpenFromPidForWriting opens a process by its pid, as OpenFromPid does, but allowing its memory to be modified with
+// memaccess.WriteMemory. Processes opened by any other function can only be read, so code that is only meant to
+// inspect processes can't modify them by mistake.
+//
+// NOTE: It's only supported on Linux.
+func OpenFromPidForWriting(pid uint) (p Process, softerrors []error, harderror error) {
+	// This function is implemented by the OS-specific openFromPidForWriting function.
+	return openFromPidForWriting(pid)
+}
+
 // GetAllPids returns a slice with al the running processes' pids.
 func GetAllPids() (pids []uint, softerrors []error, harderror error) {
 	// This function is implemented by the OS-specific getAllPids function.
@@ -55,7 +77,7 @@ func OpenAll() (ps []Process, softerrors []error, harderror error) {
 	for _, pid := range pids {
 		p, softs, err := OpenFromPid(pid)
 		if err != nil {
-			softerrs = append(softerrs, fmt.Errorf("Pid: %d failed to Open. Error: %v", pid, err))
+			softerrs = append(softerrs, fmt.Errorf("Pid: %d failed to Open. Error: %w", pid, err))
 			continue
 		}
 		if softs != nil {
@@ -84,30 +106,41 @@ func CloseAll(ps []Process) (harderrors []error, softerrors []error) {
 	return harderrors, softerrors
 }
 
-// OpenByName receives a Regexp an returns a slice with all the Processes whose name matches it.
-func OpenByName(r *regexp.Regexp) (ps []Process, softerrors []error, harderror error) {
-	procs, softerrors, harderror := OpenAll()
-	if harderror != nil {
-		return nil, nil, harderror
-	}
+// Identity identifies a process in the results of the functions that check many processes.
+type Identity struct {
+	Pid  uint
+	Name string
+}
 
-	var matchs []Process
+// CheckFunc type represents a function that looks for something in a process, returning true if it found it.
+type CheckFunc func(p Process) (found bool, softerrors []error, harderror error)
 
-	for _, p := range procs {
-		name, softs, err := p.Name()
+// CheckAll calls check with each process of ps, and returns the identities of the ones it found something in, in the
+// same order. The errors of each process, including hard ones (e.g. because it exited), are returned as soft errors, so
+// they don't stop checking the rest.
+func CheckAll(ps []Process, check CheckFunc) (found []Identity, softerrors []error) {
+	for _, p := range ps {
+		ok, serrs, err := check(p)
+		softerrors = append(softerrors, serrs...)
 		if err != nil {
-			softerrors = append(softerrors, err)
+			softerrors = append(softerrors, fmt.Errorf("Could not check process %d: %w", p.Pid(), err))
+			continue
 		}
-		if softs != nil {
-			softerrors = append(softerrors, softs...)
+		if !ok {
+			continue
 		}
 
-		if r.MatchString(name) {
-			matchs = append(matchs, p)
-		} else {
-			p.Close()
+		name, serrs, err := p.Name()
+		softerrors = append(softerrors, serrs...)
+		if err != nil {
+			softerrors = append(softerrors, err)
 		}
+		found = append(found, Identity{Pid: p.Pid(), Name: name})
 	}
+	return found, softerrors
+}
 
-	return matchs, softerrors, nil
+// OpenByName receives a Regexp an returns a slice with all the Processes whose name matches it.
+func OpenByName(r *regexp.Regexp) (ps []Process, softerrors []error, harderror error) {
+	return OpenMatching(NameMatches(r))
 }
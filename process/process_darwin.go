@@ -19,7 +19,7 @@ func (p process) Name() (name string, softerrors []error, harderror error) {
 
 	_, err := C.proc_pidpath(C.int(p.pid), cname, C.PROC_PIDPATHINFO_MAXSIZE)
 	if err != nil {
-		harderr := fmt.Errorf("Error while reading name of process %d: %v", p.pid, err)
+		harderr := fmt.Errorf("Error while reading name of process %d: %w", p.pid, err)
 		return "", nil, harderr
 	}
 
@@ -2,36 +2,60 @@ package process
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"github.com/mozilla/masche/common"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"unsafe"
 )
 
-type proc uint
+type proc struct {
+	pid uint
 
-func (p proc) Pid() uint {
-	return uint(p)
+	// startTime is the time the process started, in clock ticks since boot, as read from /proc/<pid>/stat. Together
+	// with the pid it identifies the process.
+	startTime uint64
+
+	// pidfd is a pidfd_open(2) file descriptor referring to the process, or -1 if the kernel doesn't support them.
+	pidfd int
+
+	// dir is the process' /proc/<pid> directory. Files opened relative to it belong to the process it was opened for,
+	// even if its pid is reused by another one.
+	dir *os.File
+
+	// mem is the process' memory file, kept open for the lifetime of the proc to avoid reopening it on every read.
+	mem *os.File
+
+	// writable is true if mem was opened for writing, which is only done by OpenFromPidForWriting.
+	writable bool
 }
 
-func (p proc) Name() (name string, softerrors []error, harderror error) {
-	exePath := filepath.Join("/proc", fmt.Sprintf("%d", p.Pid()), "exe")
-	name, err := filepath.EvalSymlinks(exePath)
+func (p *proc) Pid() uint {
+	return p.pid
+}
+
+func (p *proc) Name() (name string, softerrors []error, harderror error) {
+	if harderror = p.checkAlive(); harderror != nil {
+		return
+	}
+
+	name, err := p.exePath()
 
 	if err != nil {
 		// If the exe link doesn't take us to the real path of the binary of the process maybe it's not present anymore
 		// or the process didn't started from a file. We mimic this ps(1) trick and take the name form
 		// /proc/<pid>/status in that case.
 
-		statusPath := filepath.Join("/proc", fmt.Sprintf("%d", p.Pid()), "status")
-		statusFile, err := os.Open(statusPath)
+		statusFile, err := p.openFile("status")
 		if err != nil {
 			return name, nil, err
 		}
+		defer statusFile.Close()
 
 		r := bufio.NewReader(statusFile)
 		for line, _, err := r.ReadLine(); err != io.EOF; line, _, err = r.ReadLine() {
@@ -51,15 +75,145 @@ func (p proc) Name() (name string, softerrors []error, harderror error) {
 		return name, nil, fmt.Errorf("No name found for pid %v", p.Pid())
 	}
 
-	return name, nil, err
+	return name, nil, p.checkAlive()
+}
+
+func (p *proc) Close() (softerrors []error, harderror error) {
+	if p.pidfd >= 0 {
+		if err := syscall.Close(p.pidfd); err != nil {
+			softerrors = append(softerrors, err)
+		}
+	}
+
+	if err := p.dir.Close(); err != nil {
+		softerrors = append(softerrors, err)
+	}
+
+	return softerrors, p.mem.Close()
+}
+
+// Handle returns the pid of the process.
+func (p *proc) Handle() uintptr {
+	return uintptr(p.pid)
+}
+
+// path returns a path to the file called name in the process' /proc/<pid> directory that is resolved through the open
+// directory, so it can't refer to a file of another process that reused the pid.
+func (p *proc) path(name string) string {
+	return filepath.Join("/proc/self/fd", strconv.Itoa(int(p.dir.Fd())), name)
+}
+
+// openFile opens the file called name in the process' /proc/<pid> directory, returning ErrProcessGone if the process
+// has exited.
+func (p *proc) openFile(name string) (*os.File, error) {
+	if err := p.checkAlive(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p.path(name))
+	if err != nil && isGoneError(err) {
+		return nil, ErrProcessGone
+	}
+	return f, err
+}
+
+// exePath returns the real path of the binary of the process.
+func (p *proc) exePath() (string, error) {
+	target, err := os.Readlink(p.path("exe"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(target)
+}
+
+// checkAlive returns ErrProcessGone if the process has exited. Zombie processes are considered gone too.
+func (p *proc) checkAlive() error {
+	if p.pidfd >= 0 {
+		exited, err := pidfdExited(p.pidfd)
+		if err == nil {
+			if exited {
+				return ErrProcessGone
+			}
+			return nil
+		}
+	}
+
+	// Without a pidfd we check that the process' stat file can still be read through the open /proc/<pid> directory,
+	// and that it's the same process we opened.
+	stat, err := readStat(p.path("stat"))
+	if err != nil {
+		if isGoneError(err) {
+			return ErrProcessGone
+		}
+		return err
+	}
+
+	if stat.startTime != p.startTime || stat.state == 'Z' || stat.state == 'X' {
+		return ErrProcessGone
+	}
+
+	return nil
+}
+
+// ErrNotLive is returned when the /proc files of a process are needed but it isn't a running process opened by this
+// package, e.g. because it was opened from a dump. Reading them by the pid could give the ones of another process.
+var ErrNotLive = errors.New("Not a running process opened by this package")
+
+// OpenProcFile opens the file called name in the /proc/<pid> directory of p. Unlike opening it by its path, the file
+// is guaranteed to belong to the process p was opened for, and ErrProcessGone is returned if it has exited.
+//
+// NOTE: If p wasn't opened by this package (i.e. it's another implementation of Process) ErrNotLive is returned.
+func OpenProcFile(p Process, name string) (*os.File, error) {
+	if p, ok := p.(*proc); ok {
+		return p.openFile(name)
+	}
+	return nil, ErrNotLive
+}
+
+// ProcFilePath returns a path to the file called name in the /proc/<pid> directory of p that is resolved through the
+// directory p holds open, so it can't refer to a file of another process that reused the pid. It's meant for the files
+// OpenProcFile can't be used with, like the links of map_files or the root directory, and it's only valid until p is
+// closed.
+//
+// NOTE: If p wasn't opened by this package (i.e. it's another implementation of Process) ErrNotLive is returned.
+func ProcFilePath(p Process, name string) (string, error) {
+	if p, ok := p.(*proc); ok {
+		return p.path(name), nil
+	}
+	return "", ErrNotLive
+}
+
+// CheckAlive returns ErrProcessGone if the process p was opened for has exited, even if its pid has been reused by
+// another process since then.
+//
+// NOTE: If p wasn't opened by this package (i.e. it's another implementation of Process) it always returns nil.
+func CheckAlive(p Process) error {
+	if p, ok := p.(*proc); ok {
+		return p.checkAlive()
+	}
+	return nil
 }
 
-func (p proc) Close() (softerrors []error, harderror error) {
-	return nil, nil
+// MemoryFd returns the file descriptor of the memory file of p, which is kept open until p is closed. The file is only
+// writable if p was opened with OpenFromPidForWriting.
+//
+// NOTE: If p wasn't opened by this package (i.e. it's another implementation of Process) ok is false.
+func MemoryFd(p Process) (fd uintptr, ok bool) {
+	if p, ok := p.(*proc); ok {
+		return p.mem.Fd(), true
+	}
+	return 0, false
 }
 
-func (p proc) Handle() uintptr {
-	return uintptr(p)
+// OpenedForWriting returns true if p was opened with OpenFromPidForWriting, so its memory can be written through its
+// memory file.
+//
+// NOTE: If p wasn't opened by this package (i.e. it's another implementation of Process) it always returns false.
+func OpenedForWriting(p Process) bool {
+	if p, ok := p.(*proc); ok {
+		return p.writable
+	}
+	return false
 }
 
 func getAllPids() (pids []uint, softerrors []error, harderror error) {
@@ -82,14 +236,145 @@ func getAllPids() (pids []uint, softerrors []error, harderror error) {
 }
 
 func openFromPid(pid uint) (p Process, softerrors []error, harderror error) {
-	// Check if we have permissions to read the process memory
-	memPath := common.MemFilePathFromPid(pid)
-	memFile, err := os.Open(memPath)
+	return openProc(pid, false)
+}
+
+func openFromPidForWriting(pid uint) (p Process, softerrors []error, harderror error) {
+	return openProc(pid, true)
+}
+
+// openProc opens the process with the given pid, opening its memory file for writing too if writable is true.
+func openProc(pid uint, writable bool) (p Process, softerrors []error, harderror error) {
+	dir, err := os.Open(filepath.Join("/proc", strconv.Itoa(int(pid))))
 	if err != nil {
-		harderror = fmt.Errorf("Permission denied to access memory of process %v", pid)
-		return
+		if isGoneError(err) {
+			return nil, nil, ErrProcessGone
+		}
+		return nil, nil, err
+	}
+
+	result := &proc{pid: pid, dir: dir, pidfd: -1, writable: writable}
+
+	result.pidfd, err = pidfdOpen(pid)
+	if err != nil && err != syscall.ENOSYS {
+		softerrors = append(softerrors, fmt.Errorf("Could not open a pidfd for process %d: %w", pid, err))
+	}
+
+	// The stat file is read through the directory opened before the pidfd, so if this succeeds the pid wasn't reused
+	// in between and both refer to the same process.
+	stat, err := readStat(result.path("stat"))
+	if err != nil {
+		result.Close()
+		if isGoneError(err) {
+			return nil, softerrors, ErrProcessGone
+		}
+		return nil, softerrors, err
 	}
-	defer memFile.Close()
+	result.startTime = stat.startTime
+
+	// Opening the memory file also checks that we have permissions to read (and write, if asked to) the process memory
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+	result.mem, err = os.OpenFile(result.path("mem"), flag, 0)
+	if err != nil {
+		result.Close()
+		if isGoneError(err) {
+			return nil, softerrors, ErrProcessGone
+		}
+		if errors.Is(err, ErrPermission) {
+			return nil, softerrors, fmt.Errorf("Permission denied to access memory of process %v: %w", pid, err)
+		}
+		return nil, softerrors, fmt.Errorf("Could not access memory of process %v: %w", pid, err)
+	}
+
+	return result, softerrors, nil
+}
+
+// procStat holds the fields we use from a /proc/<pid>/stat file.
+type procStat struct {
+	comm      string
+	state     byte
+	ppid      uint
+	startTime uint64
+}
 
-	return proc(pid), nil, nil
+// readStat reads and parses a /proc/<pid>/stat file.
+func readStat(path string) (stat procStat, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return stat, err
+	}
+
+	// The second field is the command name between parenthesis, and it may contain spaces and parenthesis too.
+	commStart := strings.Index(string(data), "(")
+	commEnd := strings.LastIndex(string(data), ")")
+	if commStart == -1 || commEnd < commStart {
+		return stat, fmt.Errorf("Invalid stat file %s", path)
+	}
+	stat.comm = string(data[commStart+1 : commEnd])
+
+	// fields[0] is the third field of the file, the process state.
+	fields := strings.Fields(string(data[commEnd+1:]))
+	if len(fields) < 20 {
+		return stat, fmt.Errorf("Invalid stat file %s", path)
+	}
+
+	stat.state = fields[0][0]
+	ppid, err := strconv.ParseUint(fields[1], 10, 0)
+	if err != nil {
+		return stat, fmt.Errorf("Invalid parent pid in stat file %s", path)
+	}
+	stat.ppid = uint(ppid)
+	if stat.startTime, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return stat, fmt.Errorf("Invalid start time in stat file %s", path)
+	}
+
+	return stat, nil
+}
+
+// isGoneError returns true if err means that the process we were trying to access doesn't exist anymore.
+func isGoneError(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == syscall.ENOENT || err == syscall.ESRCH
+}
+
+// sysPidfdOpen is the number of the pidfd_open(2) syscall, which is the same in every architecture.
+const sysPidfdOpen = 434
+
+// pidfdOpen returns a pidfd referring to the process with the given pid.
+func pidfdOpen(pid uint) (int, error) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	syscall.CloseOnExec(int(fd))
+	return int(fd), nil
+}
+
+// pidfdExited returns true if the process referred by pidfd has exited. A pidfd becomes readable when the process
+// exits, so we poll it without waiting.
+func pidfdExited(pidfd int) (bool, error) {
+	const pollIn = 0x1
+	fds := []struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{{fd: int32(pidfd), events: pollIn}}
+	timeout := syscall.Timespec{}
+
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), 1,
+			uintptr(unsafe.Pointer(&timeout)), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return false, errno
+		}
+		return n > 0 && fds[0].revents&pollIn != 0, nil
+	}
 }
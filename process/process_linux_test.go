@@ -0,0 +1,524 @@
+package process
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mozilla/masche/test"
+)
+
+func TestProcessGone(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	proc, softerrors, err := OpenFromPid(pid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	if err := CheckAlive(proc); err != nil {
+		t.Fatal("The process should be alive, got", err)
+	}
+
+	if proc.Handle() != uintptr(pid) {
+		t.Errorf("Expected the handle to be the pid %d and got %d", pid, proc.Handle())
+	}
+	if _, ok := MemoryFd(proc); !ok {
+		t.Error("The memory file of the process isn't open")
+	}
+
+	// Once the process is killed and reaped its pid could be reused by another one.
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	if err := CheckAlive(proc); err != ErrProcessGone {
+		t.Error("Expected ErrProcessGone from CheckAlive and got", err)
+	}
+
+	if _, _, err := proc.Name(); err != ErrProcessGone {
+		t.Error("Expected ErrProcessGone from Name and got", err)
+	}
+
+	if _, err := OpenProcFile(proc, "maps"); err != ErrProcessGone {
+		t.Error("Expected ErrProcessGone from OpenProcFile and got", err)
+	}
+
+	if _, _, err := OpenFromPid(pid); err != ErrProcessGone {
+		t.Error("Expected ErrProcessGone from OpenFromPid and got", err)
+	}
+}
+
+func TestCheckAliveWithoutPidfd(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	p, softerrors, err := OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// Simulate a kernel without pidfds, so the start time is checked instead.
+	proc := p.(*proc)
+	pidfd := proc.pidfd
+	proc.pidfd = -1
+	defer func() { proc.pidfd = pidfd }()
+
+	if err := CheckAlive(proc); err != nil {
+		t.Fatal("The process should be alive, got", err)
+	}
+
+	proc.startTime++
+	if err := CheckAlive(proc); err != ErrProcessGone {
+		t.Error("A process with another start time should be gone, got", err)
+	}
+	proc.startTime--
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	if err := CheckAlive(proc); err != ErrProcessGone {
+		t.Error("Expected ErrProcessGone and got", err)
+	}
+}
+
+func TestGetInfo(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	launched := time.Now()
+	proc, softerrors, err := OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	info, softerrors, err := GetInfo(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(softerrors) != 0 {
+		t.Error("Some fields couldn't be read")
+	}
+
+	if len(info.Argv) != 1 || info.Argv[0] != test.GetTestCasePath() {
+		t.Error("Expected argv", []string{test.GetTestCasePath()}, "and got", info.Argv)
+	}
+
+	if len(info.Environ) != len(os.Environ()) {
+		t.Error("Expected", len(os.Environ()), "environment variables and got", len(info.Environ))
+	}
+
+	if info.RealUID != uint(os.Getuid()) || info.EffectiveUID != uint(os.Geteuid()) {
+		t.Error("Expected uids", os.Getuid(), os.Geteuid(), "and got", info.RealUID, info.EffectiveUID)
+	}
+
+	if info.RealGID != uint(os.Getgid()) || info.EffectiveGID != uint(os.Getegid()) {
+		t.Error("Expected gids", os.Getgid(), os.Getegid(), "and got", info.RealGID, info.EffectiveGID)
+	}
+
+	if info.PPid != uint(os.Getpid()) {
+		t.Error("Expected parent pid", os.Getpid(), "and got", info.PPid)
+	}
+
+	if info.State == "" || info.Threads != 1 {
+		t.Error("Unexpected state", info.State, "or number of threads", info.Threads)
+	}
+
+	// The start time is measured in clock ticks and the boot time in seconds.
+	if d := info.StartTime.Sub(launched); d < -2*time.Second || d > 2*time.Second {
+		t.Error("Expected start time around", launched, "and got", info.StartTime)
+	}
+
+	wd, _ := os.Getwd()
+	if wd, _ = filepath.EvalSymlinks(wd); info.Cwd != wd {
+		t.Error("Expected cwd", wd, "and got", info.Cwd)
+	}
+
+	if info.Root != "/" {
+		t.Error("Expected root / and got", info.Root)
+	}
+
+	fi, err := os.Stat(test.GetTestCasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := fi.Sys().(*syscall.Stat_t); info.ExeInode != uint64(stat.Ino) || info.ExeDevice != uint64(stat.Dev) {
+		t.Error("Expected executable inode", stat.Ino, "and got", info.ExeInode)
+	}
+
+	if len(info.Cgroups) == 0 {
+		t.Error("Expected the process to belong to some cgroup")
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	if _, _, err := GetInfo(proc); err != ErrProcessGone {
+		t.Error("Expected ErrProcessGone and got", err)
+	}
+}
+
+func TestGetTree(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	tree, softerrors, err := GetTree()
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, ppid := uint(cmd.Process.Pid), uint(os.Getpid())
+	node := tree.Node(pid)
+	if node == nil {
+		t.Fatal("The test case is not in the tree")
+	}
+
+	if node.Parent == nil || node.Parent.Pid != ppid {
+		t.Fatal("Expected the test case to be a child of", ppid)
+	}
+
+	if ancestors := tree.Ancestors(pid); ancestors[0].Pid != ppid || ancestors[len(ancestors)-1].Parent != nil {
+		t.Error("Unexpected ancestors", ancestors)
+	}
+
+	procs, softerrors, err := tree.OpenSubtree(ppid)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseAll(procs)
+
+	found := false
+	for _, p := range procs {
+		found = found || p.Pid() == pid
+	}
+	if !found {
+		t.Error("The test case wasn't opened with its parent's subtree")
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	if _, softerrors, _ := tree.OpenSubtree(pid); len(softerrors) == 0 {
+		t.Error("Expected a soft error opening the subtree of an exited process")
+	}
+}
+
+func TestOpenMatching(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	pid := uint(cmd.Process.Pid)
+	name := regexp.MustCompile(regexp.QuoteMeta(test.GetTestCasePath()))
+	selector := And(
+		ByParent(uint(os.Getpid())),
+		ByUser(uint(os.Geteuid())),
+		CommandLineMatches(name),
+		Not(YoungerThan(-time.Hour)),
+		Or(OlderThan(time.Hour), CgroupMatches(regexp.MustCompile("^/"))),
+	)
+
+	procs, softerrors, err := OpenMatching(selector)
+	if err != nil {
+		test.PrintSoftErrors(softerrors)
+		t.Fatal(err)
+	}
+	defer CloseAll(procs)
+
+	if len(procs) != 1 || procs[0].Pid() != pid {
+		t.Fatal("Expected only the test case to match and got", procs)
+	}
+
+	others, _, err := OpenMatching(And(ByParent(uint(os.Getpid())), Not(CommandLineMatches(name))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseAll(others)
+
+	for _, p := range others {
+		if p.Pid() == pid {
+			t.Error("The test case matched a selector that excludes it")
+		}
+	}
+}
+
+// foreignProcess is a Process that wasn't opened by this package, with a pid that doesn't exist.
+type foreignProcess struct{}
+
+func (foreignProcess) Pid() uint {
+	// It's bigger than the maximum pid_max.
+	return 1 << 30
+}
+
+func (foreignProcess) Name() (name string, softerrors []error, harderror error) {
+	return "", nil, ErrProcessGone
+}
+
+func (foreignProcess) Close() (softerrors []error, harderror error) {
+	return nil, nil
+}
+
+func (foreignProcess) Handle() uintptr {
+	return 0
+}
+
+// openUnreadable returns this process with a /proc/<pid> directory that only has its stat file, so it's alive but
+// none of its information can be read.
+func openUnreadable(t *testing.T) (p *proc, cleanup func()) {
+	dir, err := ioutil.TempDir("", "masche-process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeDir := func() {
+		os.RemoveAll(dir)
+	}
+
+	stat, err := ioutil.ReadFile("/proc/self/stat")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "stat"), stat, 0600)
+	}
+	var parsed procStat
+	if err == nil {
+		parsed, err = readStat(filepath.Join(dir, "stat"))
+	}
+	p = &proc{pid: uint(os.Getpid()), startTime: parsed.startTime, pidfd: -1}
+	if err == nil {
+		p.dir, err = os.Open(dir)
+	}
+	if err == nil {
+		p.mem, err = os.Open(dir)
+	}
+	if err != nil {
+		removeDir()
+		t.Fatal(err)
+	}
+	return p, func() {
+		p.Close()
+		removeDir()
+	}
+}
+
+func TestNegatedSelectorOnUnreadableInfo(t *testing.T) {
+	p, cleanup := openUnreadable(t)
+	defer cleanup()
+
+	for _, selector := range []Selector{ByUser(0), Not(ByUser(0)), Not(CgroupMatches(regexp.MustCompile("^/")))} {
+		matches, softerrors, err := selector.Match(p)
+		var fieldErr *InfoFieldError
+		if matches || !errors.As(err, &fieldErr) || len(softerrors) == 0 {
+			t.Errorf("Expected a process with unreadable info not to match, got %v, %v and %v", matches, softerrors,
+				err)
+		}
+	}
+}
+
+func TestProcFilePath(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	path, err := ProcFilePath(proc, "exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exe, err := os.Readlink(path); err != nil || exe != test.GetTestCasePath() {
+		t.Errorf("Expected the exe link to be %s and got %s and %v", test.GetTestCasePath(), exe, err)
+	}
+
+	// Once the process exits the path doesn't lead to the files of the one that may reuse its pid.
+	cmd.Process.Kill()
+	cmd.Wait()
+	if _, err := os.Readlink(path); err == nil {
+		t.Error("The exe link can still be read after the process exited")
+	}
+}
+
+func TestForeignProcessFiles(t *testing.T) {
+	// The files of the process that reuses the pid could be read otherwise.
+	if _, _, err := GetInfo(foreignProcess{}); err != ErrNotLive {
+		t.Error("Expected ErrNotLive getting the info and got", err)
+	}
+	if _, err := OpenProcFile(foreignProcess{}, "status"); err != ErrNotLive {
+		t.Error("Expected ErrNotLive opening a file and got", err)
+	}
+	if _, err := ProcFilePath(foreignProcess{}, "root"); err != ErrNotLive {
+		t.Error("Expected ErrNotLive getting the path of a file and got", err)
+	}
+	if matches, _, err := Not(ByUser(0)).Match(foreignProcess{}); matches || err != ErrNotLive {
+		t.Errorf("Expected ErrNotLive from a negated selector and got %v and %v", matches, err)
+	}
+}
+
+func TestOpenByNameExited(t *testing.T) {
+	cmd, err := test.LaunchTestCase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	r := regexp.MustCompile("test[/\\\\]tools[/\\\\]test")
+	procs, softerrors, err := OpenByName(r)
+	defer CloseAll(procs)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	// The processes opened by name keep telling when they exit.
+	found := false
+	for _, proc := range procs {
+		if proc.Pid() != uint(cmd.Process.Pid) {
+			continue
+		}
+		found = true
+		if _, _, err := proc.Name(); err != ErrProcessGone {
+			t.Error("Expected ErrProcessGone and got", err)
+		}
+	}
+	if !found {
+		t.Error("The test case was launched and not opened.")
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	self, softerrors, err := OpenFromPid(uint(os.Getpid()))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+
+	// The unreadable process fails and the second self isn't found, so only the first one is reported.
+	checked := 0
+	found, softerrors := CheckAll([]Process{self, foreignProcess{}, self}, func(p Process) (bool, []error, error) {
+		checked++
+		if _, ok := p.(foreignProcess); ok {
+			return false, nil, ErrProcessGone
+		}
+		return checked == 1, nil, nil
+	})
+
+	name, _, _ := self.Name()
+	if len(found) != 1 || found[0].Pid != self.Pid() || found[0].Name != name {
+		t.Errorf("Expected only process %d (%s) to be found and got %v", self.Pid(), name, found)
+	}
+	if len(softerrors) != 1 || !errors.Is(softerrors[0], ErrProcessGone) {
+		t.Error("Expected the error of the unreadable process and got", softerrors)
+	}
+}
+
+func TestGetInfoKeepsEmptyArguments(t *testing.T) {
+	cmd := exec.Command(test.GetTestCasePath(), "", "x", "")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// The test case closes its stdout once it's initialized.
+	if _, err := ioutil.ReadAll(stdout); err != nil {
+		t.Fatal(err)
+	}
+
+	proc, softerrors, err := OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	info, softerrors, err := GetInfo(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{test.GetTestCasePath(), "", "x", ""}
+	if !reflect.DeepEqual(info.Argv, expected) {
+		t.Errorf("Expected argv %q and got %q", expected, info.Argv)
+	}
+}
+
+func TestTicksToDuration(t *testing.T) {
+	// 10^10 ticks are almost 3.2 years, which overflowed when multiplied by a second.
+	if d := ticksToDuration(10000000000); d != 100000000*time.Second {
+		t.Error("Expected 10^8 seconds and got", d)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	status := "Name:\ttest\nState:\tS (sleeping)\nPPid:\tnot-a-pid\nUid:\t1000\tabc\t1000\t1000\n" +
+		"Gid:\t100\t200\t100\t100\nThreads:\t3\n"
+
+	info := Info{RealUID: 7, EffectiveUID: 7}
+	softerrors := parseStatus(strings.NewReader(status), &info)
+
+	failed := make(map[string]error)
+	for _, err := range softerrors {
+		var fieldErr *InfoFieldError
+		if !errors.As(err, &fieldErr) {
+			t.Fatal("Unexpected soft error", err)
+		}
+		failed[fieldErr.Field] = fieldErr.Err
+	}
+
+	// The parse errors are reported, instead of the fields not being found.
+	if len(failed) != 2 || failed["uid"] == nil || failed["parent pid"] == nil ||
+		!strings.Contains(failed["parent pid"].Error(), "not-a-pid") || !strings.Contains(failed["uid"].Error(), "abc") {
+		t.Errorf("Expected errors parsing the uid and the parent pid and got %v", softerrors)
+	}
+
+	// The uids are left untouched, as their line couldn't be parsed entirely.
+	if info.RealUID != 7 || info.EffectiveUID != 7 || info.RealGID != 100 || info.EffectiveGID != 200 ||
+		info.State != "S (sleeping)" || info.Threads != 3 {
+		t.Errorf("Unexpected info %+v", info)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package process
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func openFromPidForWriting(pid uint) (p Process, softerrors []error, harderror error) {
+	return nil, nil, fmt.Errorf("Opening processes for writing is not supported on %s", runtime.GOOS)
+}
+
+func getInfo(p Process, fields infoField) (info Info, softerrors []error, harderror error) {
+	return info, nil, fmt.Errorf("Getting process information is not supported on %s", runtime.GOOS)
+}
+
+func getTreeEntries() (entries []treeEntry, softerrors []error, harderror error) {
+	return nil, nil, fmt.Errorf("Building the process tree is not supported on %s", runtime.GOOS)
+}
+
+func isTreeNodeProcess(p Process, node *TreeNode) bool {
+	return true
+}
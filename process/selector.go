@@ -0,0 +1,268 @@
+package process
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Selector type represents a condition that the processes selected by OpenMatching must meet.
+type Selector interface {
+	// Match returns true if the process meets the condition.
+	Match(p Process) (matches bool, softerrors []error, harderror error)
+
+	// Cost returns an estimation of how expensive Match is, used by the combinators to evaluate the cheaper selectors
+	// first. The Cost* constants can be used as a reference.
+	Cost() int
+}
+
+// These are reference costs for the selectors.
+const (
+	// CostCheap is the cost of a selector that reads a small file or does a single syscall.
+	CostCheap = 1
+
+	// CostModerate is the cost of a selector that reads a few files, or one that can be big.
+	CostModerate = 10
+
+	// CostExpensive is the cost of a selector that inspects the process memory or its loaded libraries.
+	CostExpensive = 100
+)
+
+// SelectorFunc type represents a function that can be used as a Selector by NewSelector.
+type SelectorFunc func(p Process) (matches bool, softerrors []error, harderror error)
+
+type funcSelector struct {
+	match SelectorFunc
+	cost  int
+}
+
+func (s funcSelector) Match(p Process) (matches bool, softerrors []error, harderror error) {
+	return s.match(p)
+}
+
+func (s funcSelector) Cost() int {
+	return s.cost
+}
+
+// NewSelector returns a Selector with the given cost whose Match method calls match.
+func NewSelector(cost int, match SelectorFunc) Selector {
+	return funcSelector{match: match, cost: cost}
+}
+
+// infoSelector returns a Selector that reads the given Info fields of the process and calls match with them. If any of
+// the fields can't be read the process can't be evaluated, so a hard error wrapping the first *InfoFieldError is
+// returned, along with all of them as soft errors. This way the process doesn't match when the selector is negated
+// either.
+func infoSelector(cost int, fields infoField, match func(info Info) bool) Selector {
+	return NewSelector(cost, func(p Process) (matches bool, softerrors []error, harderror error) {
+		info, softerrors, harderror := getInfo(p, fields)
+		if harderror != nil {
+			return false, softerrors, harderror
+		}
+		if len(softerrors) != 0 {
+			return false, softerrors, fmt.Errorf("Could not evaluate process %d: %w", p.Pid(), softerrors[0])
+		}
+		return match(info), nil, nil
+	})
+}
+
+// NameMatches returns a Selector for the processes whose name, as returned by Process.Name, matches r.
+func NameMatches(r *regexp.Regexp) Selector {
+	return NewSelector(CostCheap, func(p Process) (matches bool, softerrors []error, harderror error) {
+		name, softerrors, harderror := p.Name()
+		if harderror != nil {
+			return false, softerrors, harderror
+		}
+		return r.MatchString(name), softerrors, nil
+	})
+}
+
+// CommandLineMatches returns a Selector for the processes whose command line matches r. The command line is built by
+// joining the arguments of the process with spaces, e.g. "java -jar app.jar".
+func CommandLineMatches(r *regexp.Regexp) Selector {
+	return infoSelector(CostModerate, infoArgv, func(info Info) bool {
+		return r.MatchString(strings.Join(info.Argv, " "))
+	})
+}
+
+// ByUser returns a Selector for the processes whose effective user id is uid.
+func ByUser(uid uint) Selector {
+	return infoSelector(CostCheap, infoStatus, func(info Info) bool {
+		return info.EffectiveUID == uid
+	})
+}
+
+// ByParent returns a Selector for the processes whose parent pid is ppid.
+func ByParent(ppid uint) Selector {
+	return infoSelector(CostCheap, infoStatus, func(info Info) bool {
+		return info.PPid == ppid
+	})
+}
+
+// CgroupMatches returns a Selector for the processes that belong to a control group whose path matches r. This can be
+// used to select the processes of a container.
+func CgroupMatches(r *regexp.Regexp) Selector {
+	return infoSelector(CostCheap, infoCgroups, func(info Info) bool {
+		for _, cgroup := range info.Cgroups {
+			if r.MatchString(cgroup) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// OlderThan returns a Selector for the processes that have been running for longer than d.
+func OlderThan(d time.Duration) Selector {
+	return infoSelector(CostCheap, infoStartTime, func(info Info) bool {
+		return time.Since(info.StartTime) > d
+	})
+}
+
+// YoungerThan returns a Selector for the processes that have been running for less than d.
+func YoungerThan(d time.Duration) Selector {
+	return infoSelector(CostCheap, infoStartTime, func(info Info) bool {
+		return time.Since(info.StartTime) < d
+	})
+}
+
+type andSelector []Selector
+
+// And returns a Selector for the processes that match all the given selectors. They are evaluated in increasing cost
+// order, and the evaluation stops with the first one that doesn't match.
+func And(selectors ...Selector) Selector {
+	return andSelector(sortByCost(selectors))
+}
+
+func (s andSelector) Match(p Process) (matches bool, softerrors []error, harderror error) {
+	for _, selector := range s {
+		matches, softs, err := selector.Match(p)
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			return false, softerrors, err
+		}
+		if !matches {
+			return false, softerrors, nil
+		}
+	}
+	return true, softerrors, nil
+}
+
+func (s andSelector) Cost() int {
+	return totalCost(s)
+}
+
+type orSelector []Selector
+
+// Or returns a Selector for the processes that match any of the given selectors. They are evaluated in increasing cost
+// order, and the evaluation stops with the first one that matches.
+func Or(selectors ...Selector) Selector {
+	return orSelector(sortByCost(selectors))
+}
+
+func (s orSelector) Match(p Process) (matches bool, softerrors []error, harderror error) {
+	for _, selector := range s {
+		matches, softs, err := selector.Match(p)
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			return false, softerrors, err
+		}
+		if matches {
+			return true, softerrors, nil
+		}
+	}
+	return false, softerrors, nil
+}
+
+func (s orSelector) Cost() int {
+	return totalCost(s)
+}
+
+type notSelector struct {
+	selector Selector
+}
+
+// Not returns a Selector for the processes that don't match selector.
+//
+// NOTE: A process for which selector returns a hard error doesn't match the returned selector either. The selectors on
+// the fields of Info, like ByUser, return one when the field can't be read.
+func Not(selector Selector) Selector {
+	return notSelector{selector}
+}
+
+func (s notSelector) Match(p Process) (matches bool, softerrors []error, harderror error) {
+	matches, softerrors, harderror = s.selector.Match(p)
+	if harderror != nil {
+		return false, softerrors, harderror
+	}
+	return !matches, softerrors, nil
+}
+
+func (s notSelector) Cost() int {
+	return s.selector.Cost()
+}
+
+// sortByCost returns a copy of selectors sorted by increasing cost.
+func sortByCost(selectors []Selector) []Selector {
+	sorted := append([]Selector(nil), selectors...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Cost() < sorted[j].Cost()
+	})
+	return sorted
+}
+
+func totalCost(selectors []Selector) (cost int) {
+	for _, selector := range selectors {
+		cost += selector.Cost()
+	}
+	return cost
+}
+
+// OpenMatching opens all the running processes that match selector. The processes are opened and evaluated one at a
+// time, as OpenIfMatching does, and the ones that don't match are closed right away.
+//
+// Processes that can't be opened or evaluated are reported as soft errors, as OpenAll does.
+func OpenMatching(selector Selector) (ps []Process, softerrors []error, harderror error) {
+	pids, softerrors, harderror := GetAllPids()
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	ps = make([]Process, 0)
+	for _, pid := range pids {
+		p, softs, err := OpenIfMatching(pid, selector)
+		softerrors = append(softerrors, softs...)
+		if err != nil {
+			softerrors = append(softerrors, err)
+			continue
+		}
+		if p != nil {
+			ps = append(ps, p)
+		}
+	}
+
+	return ps, softerrors, nil
+}
+
+// OpenIfMatching opens the process with the given pid and evaluates selector on it. If it doesn't match the process is
+// closed and nil is returned. The hard error is returned when the process can't be opened or evaluated.
+func OpenIfMatching(pid uint, selector Selector) (p Process, softerrors []error, harderror error) {
+	p, softerrors, harderror = OpenFromPid(pid)
+	if harderror != nil {
+		return nil, softerrors, fmt.Errorf("Pid: %d failed to Open. Error: %w", pid, harderror)
+	}
+
+	matches, softs, err := selector.Match(p)
+	softerrors = append(softerrors, softs...)
+	if err != nil {
+		p.Close()
+		return nil, softerrors, fmt.Errorf("Pid: %d failed to be evaluated. Error: %w", pid, err)
+	}
+	if !matches {
+		p.Close()
+		return nil, softerrors, nil
+	}
+	return p, softerrors, nil
+}
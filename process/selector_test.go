@@ -0,0 +1,59 @@
+package process
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSelectorCombinators(t *testing.T) {
+	var evaluated []string
+	selector := func(name string, cost int, matches bool, err error) Selector {
+		return NewSelector(cost, func(p Process) (bool, []error, error) {
+			evaluated = append(evaluated, name)
+			return matches, nil, err
+		})
+	}
+
+	cases := []struct {
+		selector  Selector
+		matches   bool
+		evaluated string
+	}{
+		{And(selector("expensive", CostExpensive, true, nil), selector("cheap", CostCheap, false, nil)), false,
+			"cheap"},
+		{And(selector("expensive", CostExpensive, true, nil), selector("cheap", CostCheap, true, nil)), true,
+			"cheap expensive"},
+		{Or(selector("expensive", CostExpensive, false, nil), selector("cheap", CostCheap, true, nil)), true,
+			"cheap"},
+		{Or(selector("expensive", CostExpensive, false, nil), selector("cheap", CostCheap, false, nil)), false,
+			"cheap expensive"},
+		{Not(And(selector("a", CostCheap, true, nil), selector("b", CostModerate, false, nil))), true, "a b"},
+		{Not(selector("failing", CostCheap, false, errors.New("failed"))), false, "failing"},
+		{And(), true, ""},
+		{Or(), false, ""},
+	}
+
+	for i, c := range cases {
+		evaluated = nil
+		matches, _, _ := c.selector.Match(nil)
+		if matches != c.matches {
+			t.Errorf("Case %d: expected match %v and got %v", i, c.matches, matches)
+		}
+
+		got := ""
+		for _, name := range evaluated {
+			if got != "" {
+				got += " "
+			}
+			got += name
+		}
+		if got != c.evaluated {
+			t.Errorf("Case %d: expected evaluation order %q and got %q", i, c.evaluated, got)
+		}
+	}
+
+	if cost := And(selector("a", CostCheap, true, nil), selector("b", CostModerate, true, nil)).Cost(); cost !=
+		CostCheap+CostModerate {
+		t.Error("Unexpected cost", cost)
+	}
+}
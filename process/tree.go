@@ -0,0 +1,231 @@
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// TreeNode represents a process in a Tree.
+type TreeNode struct {
+	Pid  uint `json:"pid"`
+	PPid uint `json:"ppid"`
+
+	// Name is the short command name of the process as reported by the OS, which may be truncated. Use Process.Name
+	// to get the full path of its binary.
+	Name string `json:"name"`
+
+	// Parent is nil for the roots of the tree, which includes processes whose parent wasn't found when the tree was
+	// built.
+	Parent   *TreeNode   `json:"-"`
+	Children []*TreeNode `json:"children,omitempty"`
+
+	// startTime is an OS-specific value that, together with the pid, identifies the process. It's used to check that
+	// the process opened for a node is the one that was seen when the tree was built.
+	startTime uint64
+}
+
+// Tree represents the hierarchy of the running processes, built from a single pass over them.
+//
+// NOTE: The tree is a snapshot, processes may have been created or may have exited since it was built.
+type Tree struct {
+	// Roots are the nodes without a parent in the tree, sorted by pid.
+	Roots []*TreeNode
+
+	nodes map[uint]*TreeNode
+}
+
+// treeEntry holds the information about a process needed to build a Tree, as returned by the OS-specific
+// getTreeEntries function.
+type treeEntry struct {
+	pid       uint
+	ppid      uint
+	name      string
+	startTime uint64
+}
+
+// GetTree returns the Tree of the running processes. The processes that exit while the tree is being built are
+// reported as soft errors and left out of it.
+func GetTree() (tree *Tree, softerrors []error, harderror error) {
+	// The processes are read by the OS-specific getTreeEntries function.
+	entries, softerrors, harderror := getTreeEntries()
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	return buildTree(entries), softerrors, nil
+}
+
+// buildTree links the entries by their parent pids. An entry whose parent is missing, or that started before it (which
+// means that the parent pid was reused after the original parent exited), becomes a root.
+func buildTree(entries []treeEntry) *Tree {
+	tree := &Tree{Roots: make([]*TreeNode, 0), nodes: make(map[uint]*TreeNode, len(entries))}
+
+	for _, e := range entries {
+		tree.nodes[e.pid] = &TreeNode{Pid: e.pid, PPid: e.ppid, Name: e.name, startTime: e.startTime}
+	}
+
+	for _, node := range tree.nodes {
+		parent, ok := tree.nodes[node.PPid]
+		if !ok || parent == node || parent.startTime > node.startTime {
+			tree.Roots = append(tree.Roots, node)
+			continue
+		}
+
+		node.Parent = parent
+		parent.Children = append(parent.Children, node)
+	}
+
+	sortNodes(tree.Roots)
+	for _, node := range tree.nodes {
+		sortNodes(node.Children)
+	}
+
+	return tree
+}
+
+func sortNodes(nodes []*TreeNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Pid < nodes[j].Pid
+	})
+}
+
+// Node returns the node of the process with the given pid, or nil if it isn't in the tree.
+func (t *Tree) Node(pid uint) *TreeNode {
+	return t.nodes[pid]
+}
+
+// Len returns the number of processes in the tree.
+func (t *Tree) Len() int {
+	return len(t.nodes)
+}
+
+// Ancestors returns the ancestors of the process with the given pid, starting from its parent and ending with a root of
+// the tree. It returns nil if the process isn't in the tree.
+func (t *Tree) Ancestors(pid uint) []*TreeNode {
+	node := t.nodes[pid]
+	if node == nil {
+		return nil
+	}
+
+	ancestors := make([]*TreeNode, 0)
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		ancestors = append(ancestors, parent)
+	}
+	return ancestors
+}
+
+// Descendants returns the descendants of the process with the given pid in depth-first order, where every process
+// comes before its children. It returns nil if the process isn't in the tree.
+func (t *Tree) Descendants(pid uint) []*TreeNode {
+	node := t.nodes[pid]
+	if node == nil {
+		return nil
+	}
+
+	descendants := make([]*TreeNode, 0)
+	var walk func(n *TreeNode)
+	walk = func(n *TreeNode) {
+		for _, child := range n.Children {
+			descendants = append(descendants, child)
+			walk(child)
+		}
+	}
+	walk(node)
+
+	return descendants
+}
+
+// WriteASCII writes the tree to w as text, one process per line, in the style of pstree(1).
+func (t *Tree) WriteASCII(w io.Writer) error {
+	for _, root := range t.Roots {
+		if _, err := fmt.Fprintf(w, "%d %s\n", root.Pid, root.Name); err != nil {
+			return err
+		}
+		if err := writeASCIIChildren(w, root, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeASCIIChildren(w io.Writer, node *TreeNode, indent string) error {
+	for i, child := range node.Children {
+		branch, childIndent := "|-- ", "|   "
+		if i == len(node.Children)-1 {
+			branch, childIndent = "`-- ", "    "
+		}
+
+		if _, err := fmt.Fprintf(w, "%s%s%d %s\n", indent, branch, child.Pid, child.Name); err != nil {
+			return err
+		}
+		if err := writeASCIIChildren(w, child, indent+childIndent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns the tree rendered as WriteASCII does.
+func (t *Tree) String() string {
+	var buf bytes.Buffer
+	t.WriteASCII(&buf)
+	return buf.String()
+}
+
+// MarshalJSON encodes the tree as a JSON array with its roots, each of them with its children nested.
+func (t *Tree) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Roots)
+}
+
+// OpenSubtree opens the process with the given pid and all its descendants, in the order returned by Descendants.
+// The processes that can't be opened, or that were replaced by another one with the same pid since the tree was built,
+// are reported as soft errors. A hard error is returned if the pid isn't in the tree.
+func (t *Tree) OpenSubtree(pid uint) (ps []Process, softerrors []error, harderror error) {
+	node := t.nodes[pid]
+	if node == nil {
+		return nil, nil, fmt.Errorf("Pid %d is not in the process tree", pid)
+	}
+
+	ps, softerrors = openNodes(append([]*TreeNode{node}, t.Descendants(pid)...))
+	return ps, softerrors, nil
+}
+
+// OpenAncestors opens the ancestors of the process with the given pid, in the order returned by Ancestors. Errors are
+// reported as in OpenSubtree.
+func (t *Tree) OpenAncestors(pid uint) (ps []Process, softerrors []error, harderror error) {
+	if t.nodes[pid] == nil {
+		return nil, nil, fmt.Errorf("Pid %d is not in the process tree", pid)
+	}
+
+	ps, softerrors = openNodes(t.Ancestors(pid))
+	return ps, softerrors, nil
+}
+
+// openNodes opens the processes of the given nodes, as OpenAll does.
+func openNodes(nodes []*TreeNode) (ps []Process, softerrors []error) {
+	ps = make([]Process, 0)
+	for _, node := range nodes {
+		p, softs, err := OpenFromPid(node.Pid)
+		if softs != nil {
+			softerrors = append(softerrors, softs...)
+		}
+		if err != nil {
+			softerrors = append(softerrors, fmt.Errorf("Pid: %d failed to Open. Error: %w", node.Pid, err))
+			continue
+		}
+
+		// This function is implemented by the OS-specific isTreeNodeProcess function.
+		if !isTreeNodeProcess(p, node) {
+			p.Close()
+			softerrors = append(softerrors, fmt.Errorf("Pid: %d failed to Open. Error: %w", node.Pid,
+				ErrProcessGone))
+			continue
+		}
+
+		ps = append(ps, p)
+	}
+	return ps, softerrors
+}
@@ -0,0 +1,38 @@
+package process
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+func getTreeEntries() (entries []treeEntry, softerrors []error, harderror error) {
+	pids, softerrors, harderror := getAllPids()
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	entries = make([]treeEntry, 0, len(pids))
+	for _, pid := range pids {
+		stat, err := readStat(filepath.Join("/proc", strconv.Itoa(int(pid)), "stat"))
+		if err != nil {
+			if isGoneError(err) {
+				err = ErrProcessGone
+			}
+			softerrors = append(softerrors, fmt.Errorf("Pid: %d failed to be added to the process tree. Error: %w",
+				pid, err))
+			continue
+		}
+
+		entries = append(entries, treeEntry{pid: pid, ppid: stat.ppid, name: stat.comm, startTime: stat.startTime})
+	}
+
+	return entries, softerrors, nil
+}
+
+func isTreeNodeProcess(p Process, node *TreeNode) bool {
+	if p, ok := p.(*proc); ok {
+		return p.startTime == node.startTime
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package process
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildTree(t *testing.T) {
+	tree := buildTree([]treeEntry{
+		{pid: 1, ppid: 0, name: "init", startTime: 1},
+		{pid: 10, ppid: 1, name: "sshd", startTime: 5},
+		{pid: 20, ppid: 10, name: "bash", startTime: 7},
+		{pid: 30, ppid: 20, name: "vim", startTime: 9},
+		{pid: 25, ppid: 10, name: "bash", startTime: 8},
+		// Its parent pid was reused by a process that started after it.
+		{pid: 40, ppid: 50, name: "orphan", startTime: 2},
+		{pid: 50, ppid: 1, name: "new", startTime: 10},
+	})
+
+	if tree.Len() != 7 {
+		t.Fatal("Expected 7 processes and got", tree.Len())
+	}
+
+	if len(tree.Roots) != 2 || tree.Roots[0].Pid != 1 || tree.Roots[1].Pid != 40 {
+		t.Fatal("Unexpected roots", tree.Roots)
+	}
+
+	var ancestors []uint
+	for _, node := range tree.Ancestors(30) {
+		ancestors = append(ancestors, node.Pid)
+	}
+	if len(ancestors) != 3 || ancestors[0] != 20 || ancestors[1] != 10 || ancestors[2] != 1 {
+		t.Error("Unexpected ancestors", ancestors)
+	}
+
+	var descendants []uint
+	for _, node := range tree.Descendants(10) {
+		descendants = append(descendants, node.Pid)
+	}
+	if len(descendants) != 3 || descendants[0] != 20 || descendants[1] != 30 || descendants[2] != 25 {
+		t.Error("Unexpected descendants", descendants)
+	}
+
+	if tree.Ancestors(99) != nil || tree.Descendants(99) != nil {
+		t.Error("Expected nil for a pid that isn't in the tree")
+	}
+
+	expected := "1 init\n" +
+		"|-- 10 sshd\n" +
+		"|   |-- 20 bash\n" +
+		"|   |   `-- 30 vim\n" +
+		"|   `-- 25 bash\n" +
+		"`-- 50 new\n" +
+		"40 orphan\n"
+	if tree.String() != expected {
+		t.Errorf("Expected tree\n%s\nand got\n%s", expected, tree.String())
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roots []struct {
+		Pid      uint
+		Children []struct{ Pid uint }
+	}
+	if err := json.Unmarshal(data, &roots); err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || len(roots[0].Children) != 2 || roots[0].Children[1].Pid != 50 {
+		t.Error("Unexpected JSON tree", string(data))
+	}
+}
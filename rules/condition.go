@@ -0,0 +1,177 @@
+package rules
+
+// expr is a node of the condition of a rule. Boolean expressions evaluate to 0 or 1. Integer expressions can be
+// undefined, e.g. the offset of a match that doesn't exist, in which case the comparisons that use them are false.
+type expr interface {
+	eval(s *evalScope) (value int64, defined bool)
+}
+
+// evalScope holds the matches a condition is evaluated with. matches has the matches of each string of the rule, in
+// the order they are defined, sorted by address.
+type evalScope struct {
+	matches [][]StringMatch
+}
+
+func boolValue(b bool) (int64, bool) {
+	if b {
+		return 1, true
+	}
+	return 0, true
+}
+
+type constExpr int64
+
+func (e constExpr) eval(s *evalScope) (int64, bool) {
+	return int64(e), true
+}
+
+type notExpr struct {
+	operand expr
+}
+
+func (e notExpr) eval(s *evalScope) (int64, bool) {
+	v, _ := e.operand.eval(s)
+	return boolValue(v == 0)
+}
+
+type andExpr struct {
+	left, right expr
+}
+
+func (e andExpr) eval(s *evalScope) (int64, bool) {
+	if v, _ := e.left.eval(s); v == 0 {
+		return boolValue(false)
+	}
+	v, _ := e.right.eval(s)
+	return boolValue(v != 0)
+}
+
+type orExpr struct {
+	left, right expr
+}
+
+func (e orExpr) eval(s *evalScope) (int64, bool) {
+	if v, _ := e.left.eval(s); v != 0 {
+		return boolValue(true)
+	}
+	v, _ := e.right.eval(s)
+	return boolValue(v != 0)
+}
+
+type comparisonExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e comparisonExpr) eval(s *evalScope) (int64, bool) {
+	l, lDefined := e.left.eval(s)
+	r, rDefined := e.right.eval(s)
+	if !lDefined || !rDefined {
+		return boolValue(false)
+	}
+
+	switch e.op {
+	case "==":
+		return boolValue(l == r)
+	case "!=":
+		return boolValue(l != r)
+	case "<":
+		return boolValue(l < r)
+	case "<=":
+		return boolValue(l <= r)
+	case ">":
+		return boolValue(l > r)
+	}
+	return boolValue(l >= r)
+}
+
+// stringPresentExpr is true if the string has any match.
+type stringPresentExpr int
+
+func (e stringPresentExpr) eval(s *evalScope) (int64, bool) {
+	return boolValue(len(s.matches[e]) > 0)
+}
+
+// stringCountExpr is the number of matches of the string.
+type stringCountExpr int
+
+func (e stringCountExpr) eval(s *evalScope) (int64, bool) {
+	return int64(len(s.matches[e])), true
+}
+
+// stringOffsetExpr is the offset of the index-th (1-based) match of the string, relative to the start of its mapping.
+type stringOffsetExpr struct {
+	str   int
+	index expr
+}
+
+func (e stringOffsetExpr) eval(s *evalScope) (int64, bool) {
+	i, defined := e.index.eval(s)
+	if !defined || i < 1 || i > int64(len(s.matches[e.str])) {
+		return 0, false
+	}
+	return int64(s.matches[e.str][i-1].Offset), true
+}
+
+// stringAtExpr is true if the string has a match at the given offset, relative to the start of its mapping.
+type stringAtExpr struct {
+	str    int
+	offset expr
+}
+
+func (e stringAtExpr) eval(s *evalScope) (int64, bool) {
+	offset, defined := e.offset.eval(s)
+	if !defined {
+		return boolValue(false)
+	}
+	for _, m := range s.matches[e.str] {
+		if int64(m.Offset) == offset {
+			return boolValue(true)
+		}
+	}
+	return boolValue(false)
+}
+
+// stringInExpr is true if the string has a match at an offset in the range [from, to], relative to the start of its
+// mapping.
+type stringInExpr struct {
+	str      int
+	from, to expr
+}
+
+func (e stringInExpr) eval(s *evalScope) (int64, bool) {
+	from, fromDefined := e.from.eval(s)
+	to, toDefined := e.to.eval(s)
+	if !fromDefined || !toDefined {
+		return boolValue(false)
+	}
+	for _, m := range s.matches[e.str] {
+		if int64(m.Offset) >= from && int64(m.Offset) <= to {
+			return boolValue(true)
+		}
+	}
+	return boolValue(false)
+}
+
+// ofExpr is true if at least count strings of the set have matches. If all is true every one of them must have.
+type ofExpr struct {
+	all   bool
+	count expr
+	set   []int
+}
+
+func (e ofExpr) eval(s *evalScope) (int64, bool) {
+	matched := 0
+	for _, str := range e.set {
+		if len(s.matches[str]) > 0 {
+			matched++
+		}
+	}
+
+	if e.all {
+		return boolValue(matched == len(e.set))
+	}
+
+	count, defined := e.count.eval(s)
+	return boolValue(defined && int64(matched) >= count)
+}
@@ -0,0 +1,270 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind is the kind of a token of the rules language.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenStringID // $a, or $a* in string sets
+	tokenCountID  // #a
+	tokenOffsetID // @a
+	tokenNumber
+	tokenPunct
+)
+
+// token is a token of the rules language. For identifiers and punctuation text holds the token as written, for string
+// references the name without the prefix (which can be empty, for the anonymous $ in sets), and for numbers their
+// value is in number.
+type token struct {
+	kind   tokenKind
+	text   string
+	number int64
+	pos    int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of input"
+	case tokenStringID:
+		return "$" + t.text
+	case tokenCountID:
+		return "#" + t.text
+	case tokenOffsetID:
+		return "@" + t.text
+	case tokenNumber:
+		return strconv.FormatInt(t.number, 10)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lexer splits the source of the rules in tokens. The values of the strings are not tokenized, as their syntax depends
+// on their kind, so the parser reads them directly with readText, readHex and readRegexp.
+type lexer struct {
+	src string
+	pos int
+}
+
+// punctuation holds the punctuation tokens, with the longer ones first so they take precedence.
+var punctuation = []string{"..", "==", "!=", "<=", ">=", "{", "}", "(", ")", "[", "]", "=", ",", ":", "<", ">"}
+
+// SyntaxError is returned by Compile when the rules are not valid. Line and Column are 1-based.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("Syntax error at line %d, column %d: %s", err.Line, err.Column, err.Msg)
+}
+
+// errorAt returns a *SyntaxError for the position pos of the source.
+func (l *lexer) errorAt(pos int, format string, args ...interface{}) error {
+	line := 1 + strings.Count(l.src[:pos], "\n")
+	column := pos - strings.LastIndex(l.src[:pos], "\n")
+	return &SyntaxError{Line: line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skipSpaces skips whitespace and comments.
+func (l *lexer) skipSpaces() error {
+	for l.pos < len(l.src) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(l.src[l.pos])):
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			if end == -1 {
+				l.pos = len(l.src)
+			} else {
+				l.pos += end + 1
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end == -1 {
+				return l.errorAt(l.pos, "Unclosed comment")
+			}
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// peek returns the next token without consuming it.
+func (l *lexer) peek() (token, error) {
+	pos := l.pos
+	t, err := l.next()
+	l.pos = pos
+	return t, err
+}
+
+// next consumes and returns the next token.
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpaces(); err != nil {
+		return token{}, err
+	}
+
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: tokenEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case c == '$' || c == '#' || c == '@':
+		l.pos++
+		name := l.readName()
+		kind := map[byte]tokenKind{'$': tokenStringID, '#': tokenCountID, '@': tokenOffsetID}[c]
+		if kind == tokenStringID && l.pos < len(l.src) && l.src[l.pos] == '*' {
+			l.pos++
+			name += "*"
+		}
+		if name == "" && kind != tokenStringID {
+			return token{}, l.errorAt(start, "Expected a string identifier after %q", c)
+		}
+		return token{kind: kind, text: name, pos: start}, nil
+
+	case isNameChar(c) && !isDigit(c):
+		return token{kind: tokenIdentifier, text: l.readName(), pos: start}, nil
+
+	case isDigit(c):
+		for l.pos < len(l.src) && (isNameChar(l.src[l.pos])) {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+		n, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			return token{}, l.errorAt(start, "Invalid number %q", text)
+		}
+		return token{kind: tokenNumber, text: text, number: n, pos: start}, nil
+	}
+
+	for _, p := range punctuation {
+		if strings.HasPrefix(l.src[l.pos:], p) {
+			l.pos += len(p)
+			return token{kind: tokenPunct, text: p, pos: start}, nil
+		}
+	}
+
+	return token{}, l.errorAt(start, "Unexpected character %q", c)
+}
+
+func (l *lexer) readName() string {
+	start := l.pos
+	for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isNameChar(c byte) bool {
+	return isDigit(c) || c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// readText reads a text string between double quotes, with the escape sequences \", \\, \n, \r, \t and \xNN.
+func (l *lexer) readText() ([]byte, error) {
+	start := l.pos
+	l.pos++
+
+	var text []byte
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return text, nil
+		case '\n':
+			return nil, l.errorAt(start, "Unclosed text string")
+		case '\\':
+			if l.pos+1 == len(l.src) {
+				return nil, l.errorAt(start, "Unclosed text string")
+			}
+			escape := l.src[l.pos+1]
+			switch escape {
+			case '"', '\\':
+				text = append(text, escape)
+			case 'n':
+				text = append(text, '\n')
+			case 'r':
+				text = append(text, '\r')
+			case 't':
+				text = append(text, '\t')
+			case 'x':
+				if l.pos+4 > len(l.src) {
+					return nil, l.errorAt(l.pos, "Invalid escape sequence")
+				}
+				b, err := strconv.ParseUint(l.src[l.pos+2:l.pos+4], 16, 8)
+				if err != nil {
+					return nil, l.errorAt(l.pos, "Invalid escape sequence %q", l.src[l.pos:l.pos+4])
+				}
+				text = append(text, byte(b))
+				l.pos += 2
+			default:
+				return nil, l.errorAt(l.pos, "Invalid escape sequence %q", l.src[l.pos:l.pos+2])
+			}
+			l.pos += 2
+		default:
+			text = append(text, c)
+			l.pos++
+		}
+	}
+
+	return nil, l.errorAt(start, "Unclosed text string")
+}
+
+// readHex reads a hex string between braces, returning its content and the position where it starts.
+func (l *lexer) readHex() (string, int, error) {
+	start := l.pos
+	end := strings.IndexByte(l.src[l.pos:], '}')
+	if end == -1 {
+		return "", 0, l.errorAt(start, "Unclosed hex string")
+	}
+	l.pos += end + 1
+	return l.src[start+1 : start+end], start + 1, nil
+}
+
+// readRegexp reads a regular expression between slashes, followed by its flags.
+func (l *lexer) readRegexp() (expr string, flags string, err error) {
+	start := l.pos
+	l.pos++
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '/':
+			l.pos++
+			flagsStart := l.pos
+			for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+				l.pos++
+			}
+			return b.String(), l.src[flagsStart:l.pos], nil
+		case c == '\n':
+			return "", "", l.errorAt(start, "Unclosed regular expression")
+		case c == '\\' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '/':
+			b.WriteByte('/')
+			l.pos += 2
+		case c == '\\' && l.pos+1 < len(l.src):
+			b.WriteString(l.src[l.pos : l.pos+2])
+			l.pos += 2
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+
+	return "", "", l.errorAt(start, "Unclosed regular expression")
+}
@@ -0,0 +1,31 @@
+package rules
+
+import (
+	"github.com/mozilla/masche/memsearch"
+)
+
+// textMatchers returns the matchers for a text string with the given modifiers. The string is searched as ASCII unless
+// only the wide modifier is used, and as UTF-16LE (every byte followed by a zero) if the wide modifier is used.
+func textMatchers(text []byte, modifiers map[string]bool) []memsearch.Matcher {
+	var encodings [][]byte
+	if !modifiers["wide"] || modifiers["ascii"] {
+		encodings = append(encodings, text)
+	}
+	if modifiers["wide"] {
+		wide := make([]byte, 0, 2*len(text))
+		for _, b := range text {
+			wide = append(wide, b, 0)
+		}
+		encodings = append(encodings, wide)
+	}
+
+	matchers := make([]memsearch.Matcher, len(encodings))
+	for i, encoded := range encodings {
+		if modifiers["nocase"] {
+			matchers[i] = memsearch.LiteralIgnoreCase(encoded)
+		} else {
+			matchers[i] = memsearch.Literal(encoded)
+		}
+	}
+	return matchers
+}
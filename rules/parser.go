@@ -0,0 +1,542 @@
+package rules
+
+import (
+	"github.com/mozilla/masche/memsearch"
+	"regexp"
+	"strings"
+)
+
+// exprType is the type of the value of a condition expression.
+type exprType int
+
+const (
+	boolType exprType = iota
+	intType
+)
+
+func (t exprType) String() string {
+	if t == boolType {
+		return "boolean"
+	}
+	return "integer"
+}
+
+// parser is a recursive descent parser for the rules language.
+type parser struct {
+	lexer
+	rule  *rule
+	names map[string]bool
+}
+
+// expect consumes the next token, returning an error if it isn't the punctuation or keyword text.
+func (p *parser) expect(text string) (token, error) {
+	t, err := p.next()
+	if err != nil {
+		return t, err
+	}
+	if (t.kind != tokenPunct && t.kind != tokenIdentifier) || t.text != text {
+		return t, p.errorAt(t.pos, "Expected %q and found %v", text, t)
+	}
+	return t, nil
+}
+
+// accept consumes the next token if it's the punctuation or keyword text, returning whether it was.
+func (p *parser) accept(text string) (bool, error) {
+	t, err := p.peek()
+	if err != nil {
+		return false, err
+	}
+	if (t.kind == tokenPunct || t.kind == tokenIdentifier) && t.text == text {
+		_, err = p.next()
+		return true, err
+	}
+	return false, nil
+}
+
+func (p *parser) parseRules() ([]*rule, error) {
+	var rules []*rule
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tokenEOF {
+			return rules, nil
+		}
+
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+}
+
+func (p *parser) parseRule() (*rule, error) {
+	if _, err := p.expect("rule"); err != nil {
+		return nil, err
+	}
+
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if name.kind != tokenIdentifier {
+		return nil, p.errorAt(name.pos, "Expected a rule name and found %v", name)
+	}
+	if p.names[name.text] {
+		return nil, p.errorAt(name.pos, "Duplicated rule %s", name.text)
+	}
+	p.names[name.text] = true
+
+	p.rule = &rule{name: name.text}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	hasStrings, err := p.accept("strings")
+	if err != nil {
+		return nil, err
+	}
+	if hasStrings {
+		if err := p.parseStrings(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := p.expect("condition"); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	start, _ := p.peek()
+	condition, conditionType, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if conditionType != boolType {
+		return nil, p.errorAt(start.pos, "The condition must be a boolean expression")
+	}
+	p.rule.condition = condition
+
+	if _, err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return p.rule, nil
+}
+
+func (p *parser) parseStrings() error {
+	if _, err := p.expect(":"); err != nil {
+		return err
+	}
+
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if t.kind != tokenStringID {
+			if len(p.rule.strings) == 0 {
+				return p.errorAt(t.pos, "Expected a string definition and found %v", t)
+			}
+			return nil
+		}
+		p.next()
+
+		if t.text == "" || strings.HasSuffix(t.text, "*") {
+			return p.errorAt(t.pos, "Invalid string identifier %v", t)
+		}
+		if p.rule.stringIndex(t.text) != -1 {
+			return p.errorAt(t.pos, "Duplicated string identifier %v", t)
+		}
+		if _, err := p.expect("="); err != nil {
+			return err
+		}
+
+		matchers, err := p.parseStringValue()
+		if err != nil {
+			return err
+		}
+		p.rule.strings = append(p.rule.strings, &ruleString{id: t.text, matchers: matchers})
+	}
+}
+
+// parseStringValue parses the value of a string definition and its modifiers, returning the matchers for it.
+func (p *parser) parseStringValue() ([]memsearch.Matcher, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	if p.pos == len(p.src) {
+		return nil, p.errorAt(p.pos, "Expected a string value")
+	}
+
+	start := p.pos
+	switch p.src[p.pos] {
+	case '"':
+		text, err := p.readText()
+		if err != nil {
+			return nil, err
+		}
+		if len(text) == 0 {
+			return nil, p.errorAt(start, "Empty text string")
+		}
+
+		modifiers, err := p.parseModifiers("nocase", "wide", "ascii")
+		if err != nil {
+			return nil, err
+		}
+		return textMatchers(text, modifiers), nil
+
+	case '{':
+		hex, hexStart, err := p.readHex()
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := memsearch.ParseHexPattern(hex)
+		if err != nil {
+			patternErr := err.(*memsearch.HexPatternError)
+			return nil, p.errorAt(hexStart+patternErr.Column-1, "%s", patternErr.Msg)
+		}
+		return []memsearch.Matcher{pattern}, nil
+
+	case '/':
+		expr, flags, err := p.readRegexp()
+		if err != nil {
+			return nil, err
+		}
+
+		modifiers, err := p.parseModifiers("nocase")
+		if err != nil {
+			return nil, err
+		}
+		if modifiers["nocase"] {
+			flags += "i"
+		}
+		for _, flag := range flags {
+			if flag != 'i' && flag != 's' {
+				return nil, p.errorAt(start, "Invalid regular expression flag %q", flag)
+			}
+		}
+		if flags != "" {
+			expr = "(?" + flags + ")" + expr
+		}
+
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, p.errorAt(start, "Invalid regular expression: %v", err)
+		}
+		return []memsearch.Matcher{r}, nil
+	}
+
+	return nil, p.errorAt(start, "Expected a text string, a hex string or a regular expression")
+}
+
+// parseModifiers parses the modifiers that follow a string value, which must be some of allowed.
+func (p *parser) parseModifiers(allowed ...string) (map[string]bool, error) {
+	modifiers := make(map[string]bool)
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokenIdentifier || !isModifier(t.text) {
+			return modifiers, nil
+		}
+		p.next()
+
+		valid := false
+		for _, modifier := range allowed {
+			valid = valid || t.text == modifier
+		}
+		if !valid {
+			return nil, p.errorAt(t.pos, "Modifier %s is not allowed here", t.text)
+		}
+		modifiers[t.text] = true
+	}
+}
+
+func isModifier(s string) bool {
+	return s == "nocase" || s == "wide" || s == "ascii"
+}
+
+func (p *parser) parseOr() (expr, exprType, error) {
+	left, leftType, err := p.parseAnd()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		t, _ := p.peek()
+		if ok, err := p.accept("or"); err != nil || !ok {
+			return left, leftType, err
+		}
+
+		right, rightType, err := p.parseAnd()
+		if err != nil {
+			return nil, 0, err
+		}
+		if leftType != boolType || rightType != boolType {
+			return nil, 0, p.errorAt(t.pos, "The operands of or must be boolean expressions")
+		}
+		left = orExpr{left, right}
+	}
+}
+
+func (p *parser) parseAnd() (expr, exprType, error) {
+	left, leftType, err := p.parseNot()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		t, _ := p.peek()
+		if ok, err := p.accept("and"); err != nil || !ok {
+			return left, leftType, err
+		}
+
+		right, rightType, err := p.parseNot()
+		if err != nil {
+			return nil, 0, err
+		}
+		if leftType != boolType || rightType != boolType {
+			return nil, 0, p.errorAt(t.pos, "The operands of and must be boolean expressions")
+		}
+		left = andExpr{left, right}
+	}
+}
+
+func (p *parser) parseNot() (expr, exprType, error) {
+	t, _ := p.peek()
+	if ok, err := p.accept("not"); err != nil || !ok {
+		if err != nil {
+			return nil, 0, err
+		}
+		return p.parseComparison()
+	}
+
+	operand, operandType, err := p.parseNot()
+	if err != nil {
+		return nil, 0, err
+	}
+	if operandType != boolType {
+		return nil, 0, p.errorAt(t.pos, "The operand of not must be a boolean expression")
+	}
+	return notExpr{operand}, boolType, nil
+}
+
+func (p *parser) parseComparison() (expr, exprType, error) {
+	left, leftType, err := p.parsePrimary()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	t, err := p.peek()
+	if err != nil {
+		return nil, 0, err
+	}
+	if t.kind != tokenPunct || !strings.Contains(" == != < <= > >= ", " "+t.text+" ") {
+		return left, leftType, nil
+	}
+	p.next()
+
+	right, rightType, err := p.parsePrimary()
+	if err != nil {
+		return nil, 0, err
+	}
+	if leftType != intType || rightType != intType {
+		return nil, 0, p.errorAt(t.pos, "The operands of %s must be integer expressions", t.text)
+	}
+	return comparisonExpr{op: t.text, left: left, right: right}, boolType, nil
+}
+
+// parseInt parses a primary expression that must be an integer.
+func (p *parser) parseInt() (expr, error) {
+	t, _ := p.peek()
+	e, eType, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if eType != intType {
+		return nil, p.errorAt(t.pos, "Expected an integer expression")
+	}
+	return e, nil
+}
+
+func (p *parser) parsePrimary() (expr, exprType, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch t.kind {
+	case tokenNumber:
+		if ok, err := p.accept("of"); err != nil || ok {
+			if err != nil {
+				return nil, 0, err
+			}
+			set, err := p.parseStringSet()
+			return ofExpr{count: constExpr(t.number), set: set}, boolType, err
+		}
+		return constExpr(t.number), intType, nil
+
+	case tokenIdentifier:
+		switch t.text {
+		case "true":
+			return constExpr(1), boolType, nil
+		case "false":
+			return constExpr(0), boolType, nil
+		case "all", "any":
+			if _, err := p.expect("of"); err != nil {
+				return nil, 0, err
+			}
+			set, err := p.parseStringSet()
+			return ofExpr{all: t.text == "all", count: constExpr(1), set: set}, boolType, err
+		}
+		return nil, 0, p.errorAt(t.pos, "Unexpected %v", t)
+
+	case tokenStringID:
+		str, err := p.stringReference(t)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if ok, err := p.accept("at"); err != nil || ok {
+			if err != nil {
+				return nil, 0, err
+			}
+			offset, err := p.parseInt()
+			return stringAtExpr{str: str, offset: offset}, boolType, err
+		}
+
+		if ok, err := p.accept("in"); err != nil || ok {
+			if err != nil {
+				return nil, 0, err
+			}
+			return p.parseRange(str)
+		}
+
+		return stringPresentExpr(str), boolType, nil
+
+	case tokenCountID:
+		str, err := p.stringReference(t)
+		return stringCountExpr(str), intType, err
+
+	case tokenOffsetID:
+		str, err := p.stringReference(t)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		index := expr(constExpr(1))
+		if ok, err := p.accept("["); err != nil || ok {
+			if err != nil {
+				return nil, 0, err
+			}
+			if index, err = p.parseInt(); err != nil {
+				return nil, 0, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, 0, err
+			}
+		}
+		return stringOffsetExpr{str: str, index: index}, intType, nil
+
+	case tokenPunct:
+		if t.text == "(" {
+			e, eType, err := p.parseOr()
+			if err != nil {
+				return nil, 0, err
+			}
+			_, err = p.expect(")")
+			return e, eType, err
+		}
+	}
+
+	return nil, 0, p.errorAt(t.pos, "Unexpected %v", t)
+}
+
+// parseRange parses the "(from..to)" range of an "in" expression.
+func (p *parser) parseRange(str int) (expr, exprType, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, 0, err
+	}
+	from, err := p.parseInt()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := p.expect(".."); err != nil {
+		return nil, 0, err
+	}
+	to, err := p.parseInt()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, 0, err
+	}
+	return stringInExpr{str: str, from: from, to: to}, boolType, nil
+}
+
+// stringReference returns the index of the string referenced by t.
+func (p *parser) stringReference(t token) (int, error) {
+	if t.text == "" || strings.HasSuffix(t.text, "*") {
+		return 0, p.errorAt(t.pos, "Invalid string reference %v", t)
+	}
+	str := p.rule.stringIndex(t.text)
+	if str == -1 {
+		return 0, p.errorAt(t.pos, "Undefined string %v", t)
+	}
+	return str, nil
+}
+
+// parseStringSet parses the set of strings of an "of" expression: "them", or a list of string identifiers between
+// parenthesis, where a trailing * matches any string with that prefix.
+func (p *parser) parseStringSet() ([]int, error) {
+	if ok, err := p.accept("them"); err != nil || ok {
+		set := make([]int, len(p.rule.strings))
+		for i := range set {
+			set[i] = i
+		}
+		return set, err
+	}
+
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var set []int
+	included := make(map[int]bool)
+	for {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokenStringID {
+			return nil, p.errorAt(t.pos, "Expected a string identifier and found %v", t)
+		}
+
+		matched := false
+		for i, s := range p.rule.strings {
+			if s.id == t.text || (strings.HasSuffix(t.text, "*") && strings.HasPrefix(s.id, t.text[:len(t.text)-1])) {
+				matched = true
+				if !included[i] {
+					included[i] = true
+					set = append(set, i)
+				}
+			}
+		}
+		if !matched {
+			return nil, p.errorAt(t.pos, "No string matches %v", t)
+		}
+
+		if ok, err := p.accept(","); err != nil || !ok {
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.expect(")")
+			return set, err
+		}
+	}
+}
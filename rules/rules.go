@@ -0,0 +1,290 @@
+// Package rules implements a rule language in the spirit of YARA, evaluated on the memory of processes.
+//
+// A rule has a name, a set of named strings and a condition over them, e.g.:
+//
+//	rule Example {
+//	    strings:
+//	        $text = "secret" nocase wide ascii
+//	        $hex = { 4D 5A ?? ?? [4-16] (50|45) }
+//	        $re = /key=[0-9a-f]{32}/i
+//	    condition:
+//	        #text > 2 and ($hex at 0 or any of ($re, $t*))
+//	}
+//
+// Text strings support the escape sequences \", \\, \n, \r, \t and \xNN, and the nocase, wide and ascii modifiers. Hex
+// strings use the syntax of memsearch.HexPattern. Regular expressions use the syntax of the regexp package, and support
+// the i and s flags and the nocase modifier.
+//
+// A condition is a boolean expression that combines with and, or, not and parenthesis:
+//
+//   - true and false.
+//   - $a, which is true if the string has any match.
+//   - $a at N, which is true if the string has a match at the offset N.
+//   - $a in (N..M), which is true if the string has a match at an offset between N and M, both included.
+//   - #a, the number of matches of the string, and @a[i], the offset of its i-th match, counting from 1 (@a is @a[1]).
+//     They can be compared with ==, !=, <, <=, > and >= to other integers or integer literals.
+//   - all of S, any of S and N of S, where S is either "them", every string of the rule, or a list of string
+//     identifiers between parenthesis in which $a* stands for every string whose identifier starts with $a.
+//
+// Offsets are relative to the start of the mapping the match is in. Comments are written as in Go.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/memsearch"
+	"github.com/mozilla/masche/process"
+	"sort"
+)
+
+// Rules is a set of compiled rules.
+type Rules struct {
+	rules []*rule
+
+	// patterns holds the matchers of the strings of every rule, and patternStrings the string each of them belongs to.
+	patterns       []memsearch.Matcher
+	patternStrings []stringRef
+}
+
+type rule struct {
+	name      string
+	strings   []*ruleString
+	condition expr
+}
+
+// stringIndex returns the index of the string with the given identifier (without the $), or -1 if there's none.
+func (r *rule) stringIndex(id string) int {
+	for i, s := range r.strings {
+		if s.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+type ruleString struct {
+	id       string
+	matchers []memsearch.Matcher
+}
+
+// stringRef identifies the string str of the rule rule.
+type stringRef struct {
+	rule int
+	str  int
+}
+
+// Compile compiles the rules in src. If they are not valid the error returned is a *SyntaxError.
+func Compile(src string) (*Rules, error) {
+	p := &parser{lexer: lexer{src: src}, names: make(map[string]bool)}
+	parsed, err := p.parseRules()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &Rules{rules: parsed}
+	for i, r := range parsed {
+		for j, s := range r.strings {
+			for _, m := range s.matchers {
+				rules.patterns = append(rules.patterns, m)
+				rules.patternStrings = append(rules.patternStrings, stringRef{rule: i, str: j})
+			}
+		}
+	}
+	return rules, nil
+}
+
+// Names returns the names of the rules, in the order they are defined.
+func (r *Rules) Names() []string {
+	names := make([]string, len(r.rules))
+	for i, rule := range r.rules {
+		names[i] = rule.name
+	}
+	return names
+}
+
+// StringMatch represents a match of a string of a rule.
+type StringMatch struct {
+	// Identifier is the identifier of the string, including the $.
+	Identifier string
+
+	Address uintptr
+
+	// Offset is the offset of the match from the start of Mapping.
+	Offset uintptr
+
+	Data []byte
+
+	// Mapping is the mapping where the match starts.
+	Mapping memaccess.Mapping
+}
+
+// RuleMatch represents a rule whose condition is true.
+type RuleMatch struct {
+	Rule string
+
+	// Mapping is the mapping the rule was evaluated on, or nil if it was evaluated on the whole process.
+	Mapping *memaccess.Mapping
+
+	// Strings holds the matches of the strings of the rule, sorted by address.
+	Strings []StringMatch
+}
+
+// Scope is the part of the memory of a process a rule is evaluated on.
+type Scope int
+
+const (
+	// ProcessScope evaluates each rule once, with the matches in the whole memory of the process.
+	ProcessScope Scope = iota
+
+	// MappingScope evaluates each rule once per readable mapping, with the matches in it.
+	MappingScope
+)
+
+// MaxMatchesPerString is the maximum number of matches of each string kept when scanning a process. The matches after
+// it are ignored, so #a is at most MaxMatchesPerString, and a soft error is returned for each string that has more.
+const MaxMatchesPerString = 10000
+
+// ScanProcess evaluates the rules on the whole memory of the process p, returning the rules that matched.
+func (r *Rules) ScanProcess(p process.Process) (matches []RuleMatch, softerrors []error, harderror error) {
+	return r.ScanContext(context.Background(), p, ProcessScope, nil)
+}
+
+// ScanMappings evaluates the rules on each readable mapping of the process p, returning the rules that matched in each
+// of them, sorted by the address of the mapping.
+func (r *Rules) ScanMappings(p process.Process) (matches []RuleMatch, softerrors []error, harderror error) {
+	return r.ScanContext(context.Background(), p, MappingScope, nil)
+}
+
+// ScanContext evaluates the rules on the memory of the process p with the given scope, only searching the strings in
+// the mappings accepted by filter. If filter is nil every readable mapping is searched. The memory is read once,
+// searching the strings of every rule at the same time.
+//
+// If ctx is done the scan is stopped and ctx.Err() is returned as the hard error.
+func (r *Rules) ScanContext(ctx context.Context, p process.Process, scope Scope, filter memaccess.RegionFilter) (
+	matches []RuleMatch, softerrors []error, harderror error) {
+
+	mappings, softerrors, harderror := memaccess.ListMappings(p)
+	if harderror != nil {
+		return nil, softerrors, harderror
+	}
+
+	var result memsearch.ProcessResult
+	scanner := memsearch.Scanner{Patterns: r.patterns, Filter: filter, Workers: 1,
+		MaxMatchesPerPattern: MaxMatchesPerString}
+	err := scanner.Scan(ctx, []process.Process{p}, func(res memsearch.ProcessResult) {
+		result = res
+	})
+
+	// The matchers of a string share its limit, so the patterns with too many matches are reported by string.
+	tooMany := make(map[stringRef]bool)
+	for _, err := range result.Softerrors {
+		var tooManyErr *memsearch.TooManyMatchesError
+		if errors.As(err, &tooManyErr) {
+			tooMany[r.patternStrings[tooManyErr.Pattern]] = true
+		} else {
+			softerrors = append(softerrors, err)
+		}
+	}
+	if result.Harderror != nil {
+		return nil, softerrors, result.Harderror
+	}
+	if err != nil {
+		return nil, softerrors, err
+	}
+
+	found, serrs := r.locateMatches(result.Matches, mappings, tooMany)
+	softerrors = append(softerrors, serrs...)
+
+	if scope == ProcessScope {
+		return r.evaluate(found, nil), softerrors, nil
+	}
+
+	for i := range mappings {
+		m := &mappings[i]
+		if !m.IsReadable() || (filter != nil && !filter(*m)) {
+			continue
+		}
+
+		var inMapping []foundMatch
+		for len(found) > 0 && found[0].Address < m.Address+uintptr(m.Size) {
+			if m.Contains(found[0].Address) {
+				inMapping = append(inMapping, found[0])
+			}
+			found = found[1:]
+		}
+		matches = append(matches, r.evaluate(inMapping, m)...)
+	}
+	return matches, softerrors, nil
+}
+
+// foundMatch is a match of a string, and the string it belongs to.
+type foundMatch struct {
+	stringRef
+	StringMatch
+}
+
+// locateMatches converts the matches found by a memsearch.Scanner, which are sorted by address, in string matches,
+// finding the mapping of each of them. Only the first MaxMatchesPerString matches of each string are kept, and the
+// strings with more, or in tooMany because the Scanner dropped some of their matches, are returned as soft errors.
+func (r *Rules) locateMatches(patternMatches []memsearch.PatternMatch, mappings []memaccess.Mapping,
+	tooMany map[stringRef]bool) (found []foundMatch, softerrors []error) {
+
+	counts := make(map[stringRef]int)
+	for _, pm := range patternMatches {
+		ref := r.patternStrings[pm.Pattern]
+		id := "$" + r.rules[ref.rule].strings[ref.str].id
+
+		if counts[ref] == MaxMatchesPerString {
+			tooMany[ref] = true
+			continue
+		}
+		counts[ref]++
+
+		i := sort.Search(len(mappings), func(i int) bool {
+			return mappings[i].Address+uintptr(mappings[i].Size) > pm.Address
+		})
+		if i == len(mappings) || !mappings[i].Contains(pm.Address) {
+			softerrors = append(softerrors, fmt.Errorf("Match of %s at %x is not in any mapping", id, pm.Address))
+			continue
+		}
+
+		found = append(found, foundMatch{stringRef: ref, StringMatch: StringMatch{
+			Identifier: id,
+			Address:    pm.Address,
+			Offset:     pm.Address - mappings[i].Address,
+			Data:       pm.Data,
+			Mapping:    mappings[i],
+		}})
+	}
+
+	for i, rule := range r.rules {
+		for j, str := range rule.strings {
+			if tooMany[stringRef{rule: i, str: j}] {
+				softerrors = append(softerrors, fmt.Errorf("String $%s of rule %s has more than %d matches, the rest "+
+					"are ignored", str.id, rule.name, MaxMatchesPerString))
+			}
+		}
+	}
+	return found, softerrors
+}
+
+// evaluate evaluates every rule with the given matches, returning the ones whose condition is true.
+func (r *Rules) evaluate(found []foundMatch, mapping *memaccess.Mapping) (matches []RuleMatch) {
+	for i, rule := range r.rules {
+		scope := &evalScope{matches: make([][]StringMatch, len(rule.strings))}
+		var ruleStrings []StringMatch
+		for _, f := range found {
+			if f.rule == i {
+				scope.matches[f.str] = append(scope.matches[f.str], f.StringMatch)
+				ruleStrings = append(ruleStrings, f.StringMatch)
+			}
+		}
+
+		if v, _ := rule.condition.eval(scope); v != 0 {
+			matches = append(matches, RuleMatch{Rule: rule.name, Mapping: mapping, Strings: ruleStrings})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,294 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mozilla/masche/process"
+	"github.com/mozilla/masche/test"
+	"strings"
+	"testing"
+)
+
+const testStrings = `
+	strings:
+		$a1 = "a"
+		$a2 = "b" nocase
+		$b = { 01 02 }
+		$c = /c+/i
+`
+
+func TestConditions(t *testing.T) {
+	// The offsets of the matches of each string of testStrings.
+	offsets := [][]uintptr{
+		{0x10, 0x20, 0x30},
+		{0x40},
+		nil,
+		{0x0},
+	}
+
+	cases := []struct {
+		condition string
+		expected  bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"$a1", true},
+		{"$b", false},
+		{"not $b and ($c or false)", true},
+		{"$b or $a1 and $c", true},
+		{"#a1 == 3 and #b == 0", true},
+		{"#a1 > #a2", true},
+		{"@a1 == 0x10 and @a1[3] == 48 and @a2[1] >= 0x40", true},
+		{"@b == 0 or @a1[4] == 0", false},
+		{"not (@b != 0)", true},
+		{"$a1 at 0x20", true},
+		{"$a1 at 0x21", false},
+		{"$a1 in (0x11..0x20) and not $a1 in (0x31..0x100)", true},
+		{"all of them", false},
+		{"all of ($a*, $c)", true},
+		{"any of ($a*)", true},
+		{"any of ($b)", false},
+		{"3 of them", true},
+		{"4 of them", false},
+		{"2 of ($b, $c, $a2)", true},
+	}
+
+	for _, c := range cases {
+		src := "rule test {" + testStrings + "condition: " + c.condition + "}"
+		rules, err := Compile(src)
+		if err != nil {
+			t.Errorf("Condition %q: %v", c.condition, err)
+			continue
+		}
+
+		rule := rules.rules[0]
+		scope := &evalScope{matches: make([][]StringMatch, len(rule.strings))}
+		for i, strOffsets := range offsets {
+			for _, offset := range strOffsets {
+				scope.matches[i] = append(scope.matches[i], StringMatch{Offset: offset})
+			}
+		}
+
+		if v, _ := rule.condition.eval(scope); (v != 0) != c.expected {
+			t.Errorf("Condition %q: expected %v", c.condition, c.expected)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		src    string
+		line   int
+		column int
+	}{
+		{"rule", 1, 5},
+		{"rule a { condition: true } rule a { condition: false }", 1, 33},
+		{"rule a {\n\tcondition: #b > 1\n}", 2, 13},
+		{"rule a {\n\tstrings:\n\t\t$b = \"b\"\n\tcondition: #b\n}", 4, 13},
+		{"rule a {\n\tstrings:\n\t\t$b = \"b\"\n\t\t$b = \"c\"\n\tcondition: $b\n}", 4, 3},
+		{"rule a {\n\tstrings:\n\t\t$b = { 01 [2-3] }\n\tcondition: $b\n}", 3, 13},
+		{"rule a {\n\tstrings:\n\t\t$b = /b(/\n\tcondition: $b\n}", 3, 8},
+		{"rule a {\n\tstrings:\n\t\t$b = /b/ wide\n\tcondition: $b\n}", 3, 12},
+		{"rule a {\n\tstrings:\n\t\t$b = \"b\\q\"\n\tcondition: $b\n}", 3, 10},
+		{"rule a {\n\tstrings:\n\t\t$b = \"b\"\n\tcondition: any of ($c*)\n}", 4, 21},
+		{"rule a {\n\tstrings:\n\t\t$b = \"b\"\n\tcondition: $b and 1\n}", 4, 16},
+		{"rule a {\n\tstrings:\n\t\t$b = \"b\"\n\tcondition: $b == 1\n}", 4, 16},
+		{"rule a {\n\tstrings:\n\t\t$b = \"b\"\n\tcondition: $b at @b - 1\n}", 4, 22},
+		{"rule a { /* unclosed comment", 1, 10},
+		{"rule a { condition: true", 1, 25},
+	}
+
+	for _, c := range cases {
+		_, err := Compile(c.src)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Rules %q: expected a *SyntaxError and got %v", c.src, err)
+			continue
+		}
+		if syntaxErr.Line != c.line || syntaxErr.Column != c.column {
+			t.Errorf("Rules %q: expected an error at %d:%d and got %v", c.src, c.line, c.column, err)
+		}
+	}
+}
+
+func TestTextMatchers(t *testing.T) {
+	buf := []byte("xAbC a\x00B\x00c\x00abc")
+
+	cases := []struct {
+		definition string
+		expected   []int
+	}{
+		{`"abc"`, []int{11}},
+		{`"abc" nocase`, []int{1, 11}},
+		{`"abc" wide`, nil},
+		{`"aBc" wide nocase`, []int{5}},
+		{`"abc" wide ascii nocase`, []int{1, 5, 11}},
+		{`"\x00b\x00" nocase`, []int{6}},
+	}
+
+	for _, c := range cases {
+		rules, err := Compile("rule a { strings: $a = " + c.definition + " condition: $a }")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var starts []int
+		for _, m := range rules.patterns {
+			for _, loc := range m.FindAllIndex(buf, -1) {
+				starts = append(starts, loc[0])
+			}
+		}
+		// The matchers of the ascii and wide versions are searched separately, but here they are sorted.
+		for i := 1; i < len(starts); i++ {
+			for j := i; j > 0 && starts[j] < starts[j-1]; j-- {
+				starts[j], starts[j-1] = starts[j-1], starts[j]
+			}
+		}
+
+		if len(starts) != len(c.expected) {
+			t.Errorf("String %s: expected matches at %v and got %v", c.definition, c.expected, starts)
+			continue
+		}
+		for i := range starts {
+			if starts[i] != c.expected[i] {
+				t.Errorf("String %s: expected matches at %v and got %v", c.definition, c.expected, starts)
+				break
+			}
+		}
+	}
+}
+
+const testProcessRules = `
+// Strings in the test process.
+rule Vaca {
+	strings:
+		$text = "UN DIA VI" nocase
+		$re = /vaca\s+vestida/
+		$stack = { 0D 0E [2-4] 0E 0F }
+	condition:
+		all of them and #text >= 1
+}
+
+rule Heap {
+	strings:
+		$heap = { 0B 0E 0B 0E 0F 0E }
+	condition:
+		any of them
+}
+
+rule Missing {
+	strings:
+		$missing = "Un dia vi dos vacas vestidas de uniforme"
+	condition:
+		$missing
+}
+`
+
+func TestScanTestProcess(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	rules, err := Compile(testProcessRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, softerrors, err := rules.ScanProcess(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 2 || matches[0].Rule != "Vaca" || matches[1].Rule != "Heap" {
+		t.Fatalf("Expected the rules Vaca and Heap to match and got %+v", matches)
+	}
+	for _, m := range matches {
+		if m.Mapping != nil {
+			t.Errorf("Rule %s: expected no mapping in the process scope and got %v", m.Rule, m.Mapping)
+		}
+		for _, s := range m.Strings {
+			if !s.Mapping.Contains(s.Address) || s.Offset != s.Address-s.Mapping.Address {
+				t.Errorf("Rule %s: string %s at %x is not at offset %x of %v", m.Rule, s.Identifier, s.Address,
+					s.Offset, s.Mapping)
+			}
+		}
+	}
+
+	matches, softerrors, err = rules.ScanMappings(proc)
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The strings of Vaca are in different mappings, so it can't match in any of them.
+	heapMatches := 0
+	for _, m := range matches {
+		if m.Rule != "Heap" {
+			t.Errorf("Unexpected match of rule %s in %v", m.Rule, m.Mapping)
+			continue
+		}
+		heapMatches++
+		for _, s := range m.Strings {
+			if s.Mapping != *m.Mapping {
+				t.Errorf("String %s at %x is not in the mapping %v", s.Identifier, s.Address, m.Mapping)
+			}
+		}
+	}
+	if heapMatches == 0 {
+		t.Error("Expected the rule Heap to match in a mapping")
+	}
+}
+
+func TestScanMaxMatchesPerString(t *testing.T) {
+	cmd, err := test.LaunchTestCaseAndWaitForInitialization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	proc, softerrors, err := process.OpenFromPid(uint(cmd.Process.Pid))
+	test.PrintSoftErrors(softerrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Close()
+
+	// Zero bytes are everywhere, in either case of the nocase string.
+	rules, err := Compile(fmt.Sprintf(`rule Zeros {
+	strings:
+		$z = "\x00" nocase wide
+	condition:
+		#z == %d
+}`, MaxMatchesPerString))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, softerrors, err := rules.ScanProcess(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || len(matches[0].Strings) != MaxMatchesPerString {
+		t.Errorf("Expected the rule to match with %d strings", MaxMatchesPerString)
+	}
+
+	tooMany := 0
+	for _, err := range softerrors {
+		if strings.Contains(err.Error(), "$z of rule Zeros has more than") {
+			tooMany++
+		}
+	}
+	if tooMany != 1 {
+		t.Errorf("Expected one error about $z having too many matches and got %v", softerrors)
+	}
+}